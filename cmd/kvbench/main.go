@@ -0,0 +1,261 @@
+// Command kvbench runs workloads against an embedded database or a running
+// server and reports throughput and latency percentiles.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"math/rand"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// workload configures a benchmark run.
+type workload struct {
+	URL          string        `json:"url,omitempty"`
+	File         string        `json:"file,omitempty"`
+	SyncWrites   bool          `json:"syncWrites,omitempty"`
+	Duration     time.Duration `json:"durationNs"`
+	Concurrency  int           `json:"concurrency"`
+	ReadRatio    float64       `json:"readRatio"`
+	Keys         int           `json:"keys"`
+	Distribution string        `json:"distribution"`
+	ZipfS        float64       `json:"zipfS,omitempty"`
+	ValueSize    int           `json:"valueSize"`
+	ValueSizeMax int           `json:"valueSizeMax,omitempty"`
+	Preload      bool          `json:"preload"`
+	Seed         int64         `json:"seed"`
+}
+
+// latencies are the latency percentiles of an operation in microseconds.
+type latencies struct {
+	Ops   int     `json:"ops"`
+	Mean  float64 `json:"meanUs"`
+	P50   float64 `json:"p50Us"`
+	P90   float64 `json:"p90Us"`
+	P99   float64 `json:"p99Us"`
+	P999  float64 `json:"p999Us"`
+	Max   float64 `json:"maxUs"`
+	Error int     `json:"errors"`
+}
+
+// result is the machine readable result of a run.
+type result struct {
+	Workload  workload  `json:"workload"`
+	Start     time.Time `json:"start"`
+	Elapsed   float64   `json:"elapsedSeconds"`
+	Ops       int       `json:"ops"`
+	OpsPerSec float64   `json:"opsPerSec"`
+	Reads     latencies `json:"reads"`
+	Writes    latencies `json:"writes"`
+}
+
+func main() {
+	var w workload
+	flag.StringVar(&w.URL, "url", "", "base URL of a running server, e.g. http://localhost:8080, the embedded database is used if empty")
+	flag.StringVar(&w.File, "file", "", "log file of the embedded database, kept in memory if empty")
+	flag.BoolVar(&w.SyncWrites, "sync", false, "fsync the log of the embedded database after every write")
+	flag.DurationVar(&w.Duration, "duration", 10*time.Second, "duration of the run")
+	flag.IntVar(&w.Concurrency, "concurrency", 16, "number of concurrent workers")
+	flag.Float64Var(&w.ReadRatio, "reads", 0.9, "ratio of reads, between 0 and 1")
+	flag.IntVar(&w.Keys, "keys", 10000, "number of distinct keys")
+	flag.StringVar(&w.Distribution, "dist", "uniform", "key distribution, uniform or zipfian")
+	flag.Float64Var(&w.ZipfS, "zipf-s", 1.1, "skew of the zipfian distribution, greater than 1")
+	flag.IntVar(&w.ValueSize, "value-size", 128, "value size in bytes")
+	flag.IntVar(&w.ValueSizeMax, "value-size-max", 0, "if set, value sizes are uniform between value-size and value-size-max")
+	flag.BoolVar(&w.Preload, "preload", true, "write all keys before the run")
+	flag.Int64Var(&w.Seed, "seed", 1, "seed of the random workload")
+	asJSON := flag.Bool("json", false, "print the result as JSON")
+	flag.Parse()
+
+	if err := w.validate(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	s, err := newStore(w)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer s.Close()
+
+	if w.Preload {
+		if err := preload(s, w); err != nil {
+			log.Fatal(err)
+		}
+	}
+	res := run(s, w)
+
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(res); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	res.print()
+}
+
+func (w workload) validate() error {
+	switch {
+	case w.Concurrency < 1:
+		return fmt.Errorf("concurrency must be at least 1")
+	case w.ReadRatio < 0 || w.ReadRatio > 1:
+		return fmt.Errorf("reads must be between 0 and 1")
+	case w.Keys < 1:
+		return fmt.Errorf("keys must be at least 1")
+	case w.Distribution != "uniform" && w.Distribution != "zipfian":
+		return fmt.Errorf("dist must be uniform or zipfian")
+	case w.Distribution == "zipfian" && w.ZipfS <= 1:
+		return fmt.Errorf("zipf-s must be greater than 1")
+	case w.ValueSize < 0 || (w.ValueSizeMax != 0 && w.ValueSizeMax < w.ValueSize):
+		return fmt.Errorf("value-size-max must not be smaller than value-size")
+	}
+	return nil
+}
+
+// generator produces the operations of a worker.
+type generator struct {
+	w    workload
+	r    *rand.Rand
+	zipf *rand.Zipf
+}
+
+func newGenerator(w workload, seed int64) *generator {
+	g := &generator{w: w, r: rand.New(rand.NewSource(seed))}
+	if w.Distribution == "zipfian" {
+		g.zipf = rand.NewZipf(g.r, w.ZipfS, 1, uint64(w.Keys-1))
+	}
+	return g
+}
+
+func (g *generator) key() string {
+	if g.zipf != nil {
+		return fmt.Sprintf("bench-%d", g.zipf.Uint64())
+	}
+	return fmt.Sprintf("bench-%d", g.r.Intn(g.w.Keys))
+}
+
+func (g *generator) value() []byte {
+	size := g.w.ValueSize
+	if g.w.ValueSizeMax > size {
+		size += g.r.Intn(g.w.ValueSizeMax - size + 1)
+	}
+	value := make([]byte, size)
+	g.r.Read(value)
+	return value
+}
+
+func preload(s store, w workload) error {
+	g := newGenerator(w, w.Seed)
+	for i := 0; i < w.Keys; i++ {
+		if err := s.Set(fmt.Sprintf("bench-%d", i), g.value()); err != nil {
+			return fmt.Errorf("preload failed: %v", err)
+		}
+	}
+	return nil
+}
+
+// workerResult holds the latencies measured by a worker.
+type workerResult struct {
+	reads, writes           []time.Duration
+	readErrors, writeErrors int
+}
+
+func run(s store, w workload) result {
+	results := make([]workerResult, w.Concurrency)
+	start := time.Now()
+	deadline := start.Add(w.Duration)
+
+	var wg sync.WaitGroup
+	for i := range results {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			g := newGenerator(w, w.Seed+int64(i)+1)
+			res := &results[i]
+			for time.Now().Before(deadline) {
+				key := g.key()
+				if g.r.Float64() < w.ReadRatio {
+					t := time.Now()
+					err := s.Get(key)
+					res.reads = append(res.reads, time.Since(t))
+					if err != nil {
+						res.readErrors++
+					}
+					continue
+				}
+				value := g.value()
+				t := time.Now()
+				err := s.Set(key, value)
+				res.writes = append(res.writes, time.Since(t))
+				if err != nil {
+					res.writeErrors++
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+	elapsed := time.Since(start)
+
+	var reads, writes []time.Duration
+	var readErrors, writeErrors int
+	for _, r := range results {
+		reads = append(reads, r.reads...)
+		writes = append(writes, r.writes...)
+		readErrors += r.readErrors
+		writeErrors += r.writeErrors
+	}
+	ops := len(reads) + len(writes)
+	return result{
+		Workload:  w,
+		Start:     start,
+		Elapsed:   elapsed.Seconds(),
+		Ops:       ops,
+		OpsPerSec: float64(ops) / elapsed.Seconds(),
+		Reads:     percentiles(reads, readErrors),
+		Writes:    percentiles(writes, writeErrors),
+	}
+}
+
+func percentiles(d []time.Duration, errors int) latencies {
+	l := latencies{Ops: len(d), Error: errors}
+	if len(d) == 0 {
+		return l
+	}
+	sort.Slice(d, func(i, j int) bool { return d[i] < d[j] })
+	us := func(d time.Duration) float64 { return float64(d) / float64(time.Microsecond) }
+	at := func(p float64) float64 { return us(d[int(p*float64(len(d)-1))]) }
+	var sum time.Duration
+	for _, v := range d {
+		sum += v
+	}
+	l.Mean = us(sum / time.Duration(len(d)))
+	l.P50, l.P90, l.P99, l.P999 = at(0.5), at(0.9), at(0.99), at(0.999)
+	l.Max = us(d[len(d)-1])
+	return l
+}
+
+func (r result) print() {
+	target := "embedded"
+	if r.Workload.URL != "" {
+		target = r.Workload.URL
+	}
+	fmt.Printf("target %s, %d workers, %.0f%% reads, %d keys (%s), %d byte values\n",
+		target, r.Workload.Concurrency, r.Workload.ReadRatio*100, r.Workload.Keys, r.Workload.Distribution, r.Workload.ValueSize)
+	fmt.Printf("%d ops in %.2fs, %.0f ops/sec\n\n", r.Ops, r.Elapsed, r.OpsPerSec)
+	fmt.Printf("%-6s %9s %7s %9s %9s %9s %9s %9s %9s\n", "op", "ops", "errors", "mean(us)", "p50(us)", "p90(us)", "p99(us)", "p999(us)", "max(us)")
+	for _, op := range []struct {
+		name string
+		l    latencies
+	}{{"read", r.Reads}, {"write", r.Writes}} {
+		fmt.Printf("%-6s %9d %7d %9.1f %9.1f %9.1f %9.1f %9.1f %9.1f\n",
+			op.name, op.l.Ops, op.l.Error, op.l.Mean, op.l.P50, op.l.P90, op.l.P99, op.l.P999, op.l.Max)
+	}
+}
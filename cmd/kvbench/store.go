@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/gerlacdt/db-key-value-store/pb"
+	"github.com/gerlacdt/db-key-value-store/pkg/db"
+)
+
+// store is the target of the benchmark.
+type store interface {
+	Get(key string) error
+	Set(key string, value []byte) error
+	Close() error
+}
+
+func newStore(w workload) (store, error) {
+	if w.URL != "" {
+		return newHTTPStore(w.URL, w.Concurrency), nil
+	}
+	var fs db.FS = db.NewMemFS()
+	name := "kvbench.db.bin"
+	if w.File != "" {
+		fs, name = db.OSFS{}, w.File
+	}
+	d, err := db.Open(fs, name)
+	if err != nil {
+		return nil, err
+	}
+	d.SetSyncWrites(w.SyncWrites)
+	return embeddedStore{d}, nil
+}
+
+// embeddedStore runs the benchmark against a database in the process.
+type embeddedStore struct {
+	db *db.DB
+}
+
+func (s embeddedStore) Get(key string) error {
+	_, err := s.db.Get(key)
+	return err
+}
+
+func (s embeddedStore) Set(key string, value []byte) error {
+	return s.db.Set(&pb.Entity{Key: key, Value: value})
+}
+
+func (s embeddedStore) Close() error {
+	return s.db.Close()
+}
+
+// httpStore runs the benchmark against a running server.
+type httpStore struct {
+	url    string
+	client *http.Client
+}
+
+func newHTTPStore(url string, concurrency int) httpStore {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.MaxIdleConnsPerHost = concurrency
+	return httpStore{url: url, client: &http.Client{Transport: transport, Timeout: 10 * time.Second}}
+}
+
+func (s httpStore) do(req *http.Request, expected ...int) error {
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	// drain the body, so the connection is reused
+	io.Copy(ioutil.Discard, resp.Body)
+	for _, code := range expected {
+		if resp.StatusCode == code {
+			return nil
+		}
+	}
+	return fmt.Errorf("%s %s: unexpected status %s", req.Method, req.URL, resp.Status)
+}
+
+func (s httpStore) Get(key string) error {
+	req, err := http.NewRequest(http.MethodGet, s.url+"/db/"+key, nil)
+	if err != nil {
+		return err
+	}
+	return s.do(req, http.StatusOK, http.StatusNotFound)
+}
+
+func (s httpStore) Set(key string, value []byte) error {
+	req, err := http.NewRequest(http.MethodPost, s.url+"/db/"+key, bytes.NewReader(value))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	return s.do(req, http.StatusCreated)
+}
+
+func (s httpStore) Close() error {
+	s.client.CloseIdleConnections()
+	return nil
+}
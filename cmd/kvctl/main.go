@@ -0,0 +1,359 @@
+// Command kvctl is a command line client of the key-value store.
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/signal"
+	"time"
+
+	"github.com/gerlacdt/db-key-value-store/pkg/client"
+)
+
+// exit codes
+const (
+	exitOK       = 0
+	exitError    = 1
+	exitUsage    = 2
+	exitNotFound = 3
+)
+
+const usage = `usage: kvctl [-addr url] [-admin-addr url] [-cacert file] [-cert file -key file] [-token token]
+             [-json] [-timeout duration] command [flags] [args]
+
+commands:
+  get <key>              write the value to stdout or to -o file
+  set <key> [value]      store the value, read from -f file or stdin if not given
+  delete <key>           delete the key
+  list [-prefix p]       list the keys in sorted order
+  watch <key>            print the changes of the key, with -prefix of all keys under it
+  export [-format f]     write the keyspace as jsonl or pb to stdout
+  import [-format f]     load an export from -f file or stdin
+
+The address defaults to $KVCTL_ADDR or http://localhost:8080, the address of
+the admin API used by export and import to $KVCTL_ADMIN_ADDR or
+http://localhost:8081. For https addresses -cacert is the CA bundle of the
+server, -cert and -key the client certificate for mutual TLS, they default to
+$KVCTL_CACERT, $KVCTL_CERT and $KVCTL_KEY. The bearer token defaults to
+$KVCTL_TOKEN. Exit codes are 0 on success, 1 on errors, 2 on usage errors and 3
+if the key does not exist.
+`
+
+// usageError is returned for invalid command lines.
+type usageError struct{ msg string }
+
+func (e usageError) Error() string { return e.msg }
+
+// cli holds the global flags.
+type cli struct {
+	client  *client.Client
+	json    bool
+	timeout time.Duration
+	stdin   io.Reader
+	stdout  io.Writer
+}
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+}
+
+func run(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	addr := os.Getenv("KVCTL_ADDR")
+	if addr == "" {
+		addr = "http://localhost:8080"
+	}
+	adminAddr := os.Getenv("KVCTL_ADMIN_ADDR")
+	if adminAddr == "" {
+		adminAddr = "http://localhost:8081"
+	}
+	flags := flag.NewFlagSet("kvctl", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	flags.Usage = func() { fmt.Fprint(stderr, usage) }
+	flags.StringVar(&addr, "addr", addr, "server address")
+	flags.StringVar(&adminAddr, "admin-addr", adminAddr, "admin API address")
+	caFile := flags.String("cacert", os.Getenv("KVCTL_CACERT"), "CA bundle of the server certificate")
+	certFile := flags.String("cert", os.Getenv("KVCTL_CERT"), "client certificate")
+	keyFile := flags.String("key", os.Getenv("KVCTL_KEY"), "key of the client certificate")
+	token := flags.String("token", os.Getenv("KVCTL_TOKEN"), "bearer token")
+	asJSON := flags.Bool("json", false, "print JSON")
+	timeout := flags.Duration("timeout", 30*time.Second, "timeout of a request, watch runs until interrupted")
+	if err := flags.Parse(args); err != nil {
+		return exitUsage
+	}
+	if flags.NArg() == 0 {
+		fmt.Fprint(stderr, usage)
+		return exitUsage
+	}
+
+	httpClient, err := newHTTPClient(*caFile, *certFile, *keyFile)
+	if err != nil {
+		fmt.Fprintf(stderr, "kvctl: %v\n", err)
+		return exitUsage
+	}
+	kv := client.New(addr, client.WithAdminURL(adminAddr), client.WithHTTPClient(httpClient), client.WithToken(*token))
+	c := &cli{client: kv, json: *asJSON, timeout: *timeout, stdin: stdin, stdout: stdout}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	cmd, cmdArgs := flags.Arg(0), flags.Args()[1:]
+	switch cmd {
+	case "get":
+		err = c.get(ctx, cmdArgs)
+	case "set":
+		err = c.set(ctx, cmdArgs)
+	case "delete":
+		err = c.delete(ctx, cmdArgs)
+	case "list":
+		err = c.list(ctx, cmdArgs)
+	case "watch":
+		err = c.watch(ctx, cmdArgs)
+	case "export":
+		err = c.export(ctx, cmdArgs)
+	case "import":
+		err = c.load(ctx, cmdArgs)
+	default:
+		err = usageError{fmt.Sprintf("unknown command %q", cmd)}
+	}
+
+	var uerr usageError
+	switch {
+	case err == nil:
+		return exitOK
+	case errors.As(err, &uerr):
+		fmt.Fprintf(stderr, "kvctl: %v\n\n%s", err, usage)
+		return exitUsage
+	case errors.Is(err, flag.ErrHelp):
+		return exitUsage
+	case errors.Is(err, client.ErrNotFound):
+		fmt.Fprintf(stderr, "kvctl: %v\n", err)
+		return exitNotFound
+	default:
+		fmt.Fprintf(stderr, "kvctl: %v\n", err)
+		return exitError
+	}
+}
+
+// newHTTPClient returns the default client or a client with the TLS
+// configuration of the files.
+func newHTTPClient(caFile, certFile, keyFile string) (*http.Client, error) {
+	if caFile == "" && certFile == "" && keyFile == "" {
+		return http.DefaultClient, nil
+	}
+	config := &tls.Config{MinVersion: tls.VersionTLS12}
+	if caFile != "" {
+		pem, err := ioutil.ReadFile(caFile)
+		if err != nil {
+			return nil, err
+		}
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates in %s", caFile)
+		}
+	}
+	if certFile != "" || keyFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = config
+	return &http.Client{Transport: transport}, nil
+}
+
+// parse parses the flags of a command and returns its positional arguments,
+// of which there must be between min and max.
+func parse(flags *flag.FlagSet, args []string, min, max int) ([]string, error) {
+	flags.SetOutput(ioutil.Discard)
+	if err := flags.Parse(args); err != nil {
+		if err == flag.ErrHelp {
+			return nil, usageError{flags.Name() + " has no help, see kvctl -h"}
+		}
+		return nil, usageError{err.Error()}
+	}
+	if flags.NArg() < min || flags.NArg() > max {
+		return nil, usageError{fmt.Sprintf("%s takes %d to %d arguments", flags.Name(), min, max)}
+	}
+	return flags.Args(), nil
+}
+
+func (c *cli) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(ctx, c.timeout)
+}
+
+func (c *cli) printJSON(v interface{}) error {
+	enc := json.NewEncoder(c.stdout)
+	return enc.Encode(v)
+}
+
+func (c *cli) get(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("get", flag.ContinueOnError)
+	out := flags.String("o", "", "write the value to the file")
+	args, err := parse(flags, args, 1, 1)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+	value, err := c.client.Get(ctx, args[0])
+	if err != nil {
+		return err
+	}
+	switch {
+	case *out != "":
+		return ioutil.WriteFile(*out, value, 0644)
+	case c.json:
+		return c.printJSON(struct {
+			Key   string `json:"key"`
+			Value []byte `json:"value"`
+		}{args[0], value})
+	}
+	_, err = c.stdout.Write(value)
+	return err
+}
+
+func (c *cli) set(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("set", flag.ContinueOnError)
+	file := flags.String("f", "", "read the value from the file, - is stdin")
+	args, err := parse(flags, args, 1, 2)
+	if err != nil {
+		return err
+	}
+	var value []byte
+	switch {
+	case len(args) == 2 && *file != "":
+		return usageError{"set takes either a value or -f"}
+	case len(args) == 2:
+		value = []byte(args[1])
+	case *file != "" && *file != "-":
+		value, err = ioutil.ReadFile(*file)
+	default:
+		value, err = ioutil.ReadAll(c.stdin)
+	}
+	if err != nil {
+		return err
+	}
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+	return c.client.Set(ctx, args[0], value)
+}
+
+func (c *cli) delete(ctx context.Context, args []string) error {
+	args, err := parse(flag.NewFlagSet("delete", flag.ContinueOnError), args, 1, 1)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+	return c.client.Delete(ctx, args[0])
+}
+
+func (c *cli) list(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("list", flag.ContinueOnError)
+	prefix := flags.String("prefix", "", "list only keys with the prefix")
+	limit := flags.Int("limit", 0, "list at most limit keys")
+	if _, err := parse(flags, args, 0, 0); err != nil {
+		return err
+	}
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+	keys, err := c.client.List(ctx, *prefix, *limit)
+	if err != nil {
+		return err
+	}
+	if c.json {
+		return c.printJSON(keys)
+	}
+	for _, key := range keys {
+		if _, err := fmt.Fprintln(c.stdout, key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *cli) watch(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("watch", flag.ContinueOnError)
+	prefix := flags.Bool("prefix", false, "watch all keys starting with the key")
+	since := flags.Uint64("since", 0, "print the changes after this version, from now on if zero")
+	once := flags.Bool("once", false, "exit after the first change")
+	args, err := parse(flags, args, 1, 1)
+	if err != nil {
+		return err
+	}
+
+	opts := client.WatchOptions{Prefix: *prefix, Since: *since}
+	for {
+		record, version, err := c.client.Watch(ctx, args[0], opts)
+		if errors.Is(err, context.Canceled) {
+			// interrupted
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		opts.Since = version
+		if record == nil {
+			continue
+		}
+		if c.json {
+			err = c.printJSON(record)
+		} else if record.Tombstone {
+			_, err = fmt.Fprintf(c.stdout, "%d DELETE %s\n", record.Seq, record.Key)
+		} else {
+			_, err = fmt.Fprintf(c.stdout, "%d SET %s %s\n", record.Seq, record.Key, record.Value)
+		}
+		if err != nil || *once {
+			return err
+		}
+	}
+}
+
+func (c *cli) export(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("export", flag.ContinueOnError)
+	format := flags.String("format", "jsonl", "export format, jsonl or pb")
+	if _, err := parse(flags, args, 0, 0); err != nil {
+		return err
+	}
+	// exports may take longer than a request, they run until interrupted
+	return c.client.Export(ctx, *format, c.stdout)
+}
+
+func (c *cli) load(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("import", flag.ContinueOnError)
+	format := flags.String("format", "jsonl", "import format, jsonl or pb")
+	file := flags.String("f", "", "read the export from the file instead of stdin")
+	if _, err := parse(flags, args, 0, 0); err != nil {
+		return err
+	}
+	r := c.stdin
+	if *file != "" && *file != "-" {
+		f, err := os.Open(*file)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r = f
+	}
+	n, err := c.client.Import(ctx, *format, r)
+	if err != nil {
+		return err
+	}
+	if c.json {
+		return c.printJSON(struct {
+			Imported int `json:"imported"`
+		}{n})
+	}
+	_, err = fmt.Fprintf(c.stdout, "imported %d entities\n", n)
+	return err
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gerlacdt/db-key-value-store/pkg/db"
+	"github.com/gerlacdt/db-key-value-store/pkg/handler"
+	"github.com/mattetti/filebuffer"
+)
+
+func setup(t *testing.T) string {
+	t.Parallel()
+	h, err := handler.New(db.New(filebuffer.New(nil)))
+	if err != nil {
+		t.Fatalf("could not create handler: %v", err)
+	}
+	srv := httptest.NewServer(h)
+	t.Cleanup(srv.Close)
+	return srv.URL
+}
+
+// kvctl runs the command against the server and returns the exit code and the
+// output.
+func kvctl(addr, stdin string, args ...string) (int, string, string) {
+	var stdout, stderr bytes.Buffer
+	args = append([]string{"-addr", addr}, args...)
+	code := run(args, strings.NewReader(stdin), &stdout, &stderr)
+	return code, stdout.String(), stderr.String()
+}
+
+func TestRun(t *testing.T) {
+	addr := setup(t)
+
+	if code, _, stderr := kvctl(addr, "", "set", "foo-1", "bar"); code != exitOK {
+		t.Fatalf("set exit code expected %d, got %d: %s", exitOK, code, stderr)
+	}
+	if code, _, stderr := kvctl(addr, "baz", "set", "foo-2"); code != exitOK {
+		t.Fatalf("set from stdin exit code expected %d, got %d: %s", exitOK, code, stderr)
+	}
+	code, stdout, _ := kvctl(addr, "", "get", "foo-2")
+	if code != exitOK || stdout != "baz" {
+		t.Fatalf("get expected %d baz, got %d %q", exitOK, code, stdout)
+	}
+	code, stdout, _ = kvctl(addr, "", "list", "-prefix", "foo-")
+	if code != exitOK || stdout != "foo-1\nfoo-2\n" {
+		t.Fatalf("list expected %d foo-1 foo-2, got %d %q", exitOK, code, stdout)
+	}
+	code, stdout, _ = kvctl(addr, "", "-json", "list")
+	if code != exitOK || stdout != "[\"foo-1\",\"foo-2\"]\n" {
+		t.Fatalf("json list expected %d [foo-1 foo-2], got %d %q", exitOK, code, stdout)
+	}
+	if code, _, stderr := kvctl(addr, "", "delete", "foo-1"); code != exitOK {
+		t.Fatalf("delete exit code expected %d, got %d: %s", exitOK, code, stderr)
+	}
+	if code, _, _ := kvctl(addr, "", "get", "foo-1"); code != exitNotFound {
+		t.Fatalf("get of deleted key exit code expected %d, got %d", exitNotFound, code)
+	}
+	if code, _, _ := kvctl(addr, "", "delete", "foo-1"); code != exitNotFound {
+		t.Fatalf("delete of deleted key exit code expected %d, got %d", exitNotFound, code)
+	}
+}
+
+func TestRunExitCodes(t *testing.T) {
+	addr := setup(t)
+	out := filepath.Join(t.TempDir(), "missing", "value")
+	if code, _, _ := kvctl(addr, "", "set", "foo", "bar"); code != exitOK {
+		t.Fatalf("set exit code expected %d, got %d", exitOK, code)
+	}
+
+	tests := []struct {
+		name string
+		args []string
+		code int
+	}{
+		{"no command", nil, exitUsage},
+		{"unknown command", []string{"move", "foo"}, exitUsage},
+		{"unknown flag", []string{"-verbose", "get", "foo"}, exitUsage},
+		{"missing key", []string{"get"}, exitUsage},
+		{"too many arguments", []string{"delete", "foo", "bar"}, exitUsage},
+		{"value and file", []string{"set", "-f", "value", "foo", "bar"}, exitUsage},
+		{"not found", []string{"get", "missing"}, exitNotFound},
+		{"write error", []string{"get", "-o", out, "foo"}, exitError},
+		{"invalid key", []string{"set", strings.Repeat("k", 2048), "bar"}, exitError},
+	}
+	for _, test := range tests {
+		code, _, stderr := kvctl(addr, "", test.args...)
+		if code != test.code {
+			t.Errorf("%s: exit code expected %d, got %d: %s", test.name, test.code, code, stderr)
+		}
+	}
+}
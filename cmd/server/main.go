@@ -2,60 +2,429 @@ package main
 
 import (
 	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
 	"log"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 
 	"github.com/kelseyhightower/envconfig"
 
+	"github.com/gerlacdt/db-key-value-store/pkg/certs"
 	"github.com/gerlacdt/db-key-value-store/pkg/db"
 	"github.com/gerlacdt/db-key-value-store/pkg/handler"
 )
 
+type config struct {
+	Port       string   `default:"8080"`
+	AdminPort  string   `envconfig:"ADMIN_PORT" default:"8081"` // listener of the /admin group, on Port if empty
+	Filename   string   `required:"true"`
+	Indexes    []string // secondary indexes as name:prefix:path, e.g. email:user-:$.email
+	NSDir      string   `envconfig:"NS_DIR"`                   // directory of the namespace logs, namespaces are disabled if empty
+	QuotaFile  string   `envconfig:"QUOTA_FILE"`               // JSON list of quotas, see db.Quota, with an optional namespace
+	SyncWrites bool     `envconfig:"SYNC_WRITES"`              // fsync the log after every write
+	LogLevel   string   `envconfig:"LOG_LEVEL" default:"info"` // access log level: info, warn, error or off
+	LogSample  float64  `envconfig:"LOG_SAMPLE" default:"1"`   // fraction of the logged info requests
+
+	// limits of the keys and values of writes, see db.Limits
+	MaxKeyLength int    `envconfig:"MAX_KEY_LENGTH"` // bytes, db.DefaultMaxKeyLength if zero
+	MaxValueSize int    `envconfig:"MAX_VALUE_SIZE"` // bytes, db.MaxRecordSize if zero
+	KeyPattern   string `envconfig:"KEY_PATTERN"`    // regular expression which matches whole keys, e.g. [A-Za-z0-9._-]+
+
+	// TLS is enabled with a certificate and key, client certificates are verified against the CA bundle if
+	// given. The files are reloaded after changes and on SIGHUP.
+	TLSCertFile       string        `envconfig:"TLS_CERT_FILE"`
+	TLSKeyFile        string        `envconfig:"TLS_KEY_FILE"`
+	TLSClientCAFile   string        `envconfig:"TLS_CLIENT_CA_FILE"`
+	TLSClientOptional bool          `envconfig:"TLS_CLIENT_CERT_OPTIONAL"` // accept clients without certificate
+	TLSReload         time.Duration `envconfig:"TLS_RELOAD_INTERVAL" default:"10s"`
+
+	// bearer tokens with their grants, see handler.Token, authentication is disabled if empty. The file is
+	// reloaded after changes and on SIGHUP.
+	TokenFile   string        `envconfig:"TOKEN_FILE"`
+	TokenReload time.Duration `envconfig:"TOKEN_RELOAD_INTERVAL" default:"10s"`
+}
+
+const usage = `usage: app [command] [flags]
+
+commands:
+  serve   start the http server (default)
+  export  write the live keyspace to stdout
+  import  load entities from stdin into the database
+  restore rebuild the database file from a backup
+  recover write the database as of a sequence number or time into a new file
+`
+
 func main() {
-	var config struct {
-		Port     string `required:"true"`
-		Filename string `required:"true"`
-	}
+	var config config
 	if err := envconfig.Process("", &config); err != nil {
 		log.Print(err)
 		envconfig.Usage("", &config)
 		os.Exit(1)
 	}
 
-	f, err := os.Create(config.Filename)
+	cmd, args := "serve", []string{}
+	if len(os.Args) > 1 {
+		cmd, args = os.Args[1], os.Args[2:]
+	}
+
+	var err error
+	switch cmd {
+	case "serve":
+		err = serve(config)
+	case "export":
+		err = export(config, args)
+	case "import":
+		err = load(config, args)
+	case "restore":
+		err = restore(config, args)
+	case "recover":
+		err = recoverTo(config, args)
+	default:
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
 	if err != nil {
-		log.Printf("could not create file %s: %v", config.Filename, err)
+		log.Print(err)
 		os.Exit(1)
 	}
-	defer f.Close()
-	defer os.Remove(config.Filename)
+}
 
-	h, err := handler.New(db.New(f))
+func serve(config config) error {
+	d, err := db.Open(db.OSFS{}, config.Filename)
 	if err != nil {
-		log.Printf("could not create handler: %v", err)
-		os.Exit(1)
+		return err
+	}
+	defer d.Close()
+
+	d.SetSyncWrites(config.SyncWrites)
+	for _, s := range config.Indexes {
+		spec, err := db.ParseIndexSpec(s)
+		if err != nil {
+			return err
+		}
+		if err := d.CreateIndex(spec); err != nil {
+			return fmt.Errorf("could not create index: %v", err)
+		}
+	}
+	quotas, err := readQuotas(config.QuotaFile)
+	if err != nil {
+		return err
+	}
+	d.SetQuotas(quotas[""])
+	limits := db.Limits{MaxKeyLength: config.MaxKeyLength, MaxValueSize: config.MaxValueSize}
+	if config.KeyPattern != "" {
+		if limits.KeyPattern, err = db.ParseKeyPattern(config.KeyPattern); err != nil {
+			return err
+		}
+	}
+	d.SetLimits(limits)
+
+	level, err := handler.ParseLogLevel(config.LogLevel)
+	if err != nil {
+		return err
+	}
+	opts := []handler.Option{handler.WithAccessLog(os.Stdout, level, config.LogSample)}
+	if config.NSDir != "" {
+		namespaces, err := db.OpenNamespaces(config.NSDir)
+		if err != nil {
+			return err
+		}
+		defer namespaces.Close()
+		namespaces.SetLimits(limits)
+		namespaces.SetSyncWrites(config.SyncWrites)
+		for name, q := range quotas {
+			if name != "" {
+				namespaces.SetQuotas(name, q)
+			}
+		}
+		opts = append(opts, handler.WithNamespaces(namespaces))
+	}
+	if config.AdminPort != "" {
+		opts = append(opts, handler.WithSeparateAdmin())
 	}
 
-	srv := &http.Server{Addr: ":" + config.Port, Handler: h}
+	// streaming requests end when the base context is cancelled on shutdown
+	baseCtx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var reloads []func() error
+	reloader, err := loadCertificates(baseCtx, config)
+	if err != nil {
+		return err
+	}
+	if reloader != nil {
+		reloads = append(reloads, reloader.Reload)
+	}
+	if config.TokenFile != "" {
+		tokens, err := handler.LoadTokens(config.TokenFile)
+		if err != nil {
+			return err
+		}
+		if config.TokenReload > 0 {
+			go tokens.Watch(baseCtx, config.TokenReload)
+		}
+		reloads = append(reloads, tokens.Reload)
+		opts = append(opts, handler.WithTokens(tokens))
+		log.Printf("authentication enabled with token file %s", config.TokenFile)
+	}
+	go reloadOnHangup(baseCtx, reloads...)
+
+	h, err := handler.New(d, opts...)
+	if err != nil {
+		return fmt.Errorf("could not create handler: %v", err)
+	}
+	listen := func(srv *http.Server) error {
+		if reloader == nil {
+			return srv.ListenAndServe()
+		}
+		srv.TLSConfig = reloader.TLSConfig()
+		return srv.ListenAndServeTLS("", "")
+	}
+
+	var adminSrv *http.Server
+	if config.AdminPort != "" {
+		adminSrv = &http.Server{Addr: ":" + config.AdminPort, Handler: handler.NewAdmin(d, opts...)}
+		go func() {
+			log.Printf("admin api listens on port %s", config.AdminPort)
+			if err := listen(adminSrv); err != http.ErrServerClosed {
+				log.Printf("admin server failed: %v", err)
+			}
+		}()
+	}
+
+	srv := &http.Server{
+		Addr:        ":" + config.Port,
+		Handler:     h,
+		BaseContext: func(net.Listener) context.Context { return baseCtx },
+	}
+	srv.RegisterOnShutdown(cancel)
 	go func() {
 		// graceful shutdown
 		interrupt := make(chan os.Signal, 1)
 		signal.Notify(interrupt, os.Interrupt, syscall.SIGTERM)
 		<-interrupt
 		log.Print("app is shutting down...")
+		if adminSrv != nil {
+			if err := adminSrv.Shutdown(context.Background()); err != nil {
+				log.Printf("could not shutdown admin server: %v\n", err)
+			}
+		}
 		if err := srv.Shutdown(context.Background()); err != nil {
 			log.Printf("could not shutdown: %v\n", err)
 		}
 	}()
 
 	log.Printf("app is ready to listen and serve on port %s", config.Port)
-	if err := srv.ListenAndServe(); err != http.ErrServerClosed {
-		log.Printf("server failed: %v", err)
-		os.Exit(1)
+	if err := listen(srv); err != http.ErrServerClosed {
+		return fmt.Errorf("server failed: %v", err)
 	}
 
 	log.Print("good bye!")
+	return nil
+}
+
+// loadCertificates returns the certificates of the TLS configuration, nil if
+// TLS is disabled. They are reloaded after file changes until the context is
+// cancelled.
+func loadCertificates(ctx context.Context, config config) (*certs.Reloader, error) {
+	if config.TLSCertFile == "" && config.TLSKeyFile == "" {
+		if config.TLSClientCAFile != "" {
+			return nil, fmt.Errorf("TLS_CLIENT_CA_FILE needs TLS_CERT_FILE and TLS_KEY_FILE")
+		}
+		return nil, nil
+	}
+	var opts []certs.Option
+	if config.TLSClientCAFile != "" {
+		opts = append(opts, certs.WithClientCA(config.TLSClientCAFile, !config.TLSClientOptional))
+	}
+	reloader, err := certs.New(config.TLSCertFile, config.TLSKeyFile, opts...)
+	if err != nil {
+		return nil, err
+	}
+	if config.TLSReload > 0 {
+		go reloader.Watch(ctx, config.TLSReload)
+	}
+	log.Printf("tls enabled with certificate %s", config.TLSCertFile)
+	return reloader, nil
+}
+
+// reloadOnHangup calls the reload functions, e.g. of the certificates and
+// tokens, on SIGHUP until the context is cancelled.
+func reloadOnHangup(ctx context.Context, reloads ...func() error) {
+	hangup := make(chan os.Signal, 1)
+	signal.Notify(hangup, syscall.SIGHUP)
+	defer signal.Stop(hangup)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hangup:
+		}
+		for _, reload := range reloads {
+			if err := reload(); err != nil {
+				log.Printf("could not reload: %v", err)
+			}
+		}
+		log.Print("reloaded configuration files")
+	}
+}
+
+// readQuotas reads the quota file and returns the quotas by namespace, the
+// default keyspace has the empty name.
+func readQuotas(filename string) (map[string][]db.Quota, error) {
+	quotas := make(map[string][]db.Quota)
+	if filename == "" {
+		return quotas, nil
+	}
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("could not read quota file %s: %v", filename, err)
+	}
+	var list []struct {
+		Namespace string `json:"namespace"`
+		db.Quota
+	}
+	if err := json.Unmarshal(data, &list); err != nil {
+		return nil, fmt.Errorf("could not parse quota file %s: %v", filename, err)
+	}
+	for _, q := range list {
+		quotas[q.Namespace] = append(quotas[q.Namespace], q.Quota)
+	}
+	return quotas, nil
+}
+
+func export(config config, args []string) error {
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	format := fs.String("format", "jsonl", "export format, jsonl or pb")
+	fs.Parse(args)
+
+	exportFormat, err := db.ParseFormat(*format)
+	if err != nil {
+		return err
+	}
+	d, err := db.OpenReadOnly(db.OSFS{}, config.Filename)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Export(os.Stdout, exportFormat)
+}
+
+func load(config config, args []string) error {
+	fs := flag.NewFlagSet("import", flag.ExitOnError)
+	format := fs.String("format", "jsonl", "import format, jsonl or pb")
+	fs.Parse(args)
+
+	importFormat, err := db.ParseFormat(*format)
+	if err != nil {
+		return err
+	}
+	d, err := db.Open(db.OSFS{}, config.Filename)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	n, err := d.Import(os.Stdin, importFormat)
+	if err != nil {
+		return err
+	}
+	log.Printf("imported %d entities", n)
+	return nil
+}
+
+func restore(config config, args []string) error {
+	fs := flag.NewFlagSet("restore", flag.ExitOnError)
+	from := fs.String("from", "", "backup file, e.g. downloaded from /admin/backup")
+	force := fs.Bool("force", false, "overwrite an existing database file")
+	fs.Parse(args)
+
+	if *from == "" {
+		return fmt.Errorf("restore needs a backup file, use -from")
+	}
+	if d, err := db.Open(db.OSFS{}, config.Filename); err == nil {
+		size := d.Stats().LogSize
+		d.Close()
+		if size > 0 && !*force {
+			return fmt.Errorf("database file %s is not empty, use -force to overwrite it", config.Filename)
+		}
+	}
+	backup, err := os.Open(*from)
+	if err != nil {
+		return fmt.Errorf("could not open backup %s: %v", *from, err)
+	}
+	defer backup.Close()
+
+	// restore into a temporary file, so a broken backup never replaces the database file
+	tmpName := config.Filename + ".restore"
+	tmp, err := os.OpenFile(tmpName, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return fmt.Errorf("could not create file %s: %v", tmpName, err)
+	}
+	defer tmp.Close()
+	defer os.Remove(tmpName)
+
+	if _, err := db.Restore(tmp, backup); err != nil {
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		return fmt.Errorf("could not sync file %s: %v", tmpName, err)
+	}
+	// the segments of the old log are replaced by the restored file
+	if err := db.RemoveLog(db.OSFS{}, config.Filename); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpName, config.Filename); err != nil {
+		return fmt.Errorf("could not rename %s: %v", tmpName, err)
+	}
+	log.Printf("restored and verified %s from %s", config.Filename, *from)
+	return nil
+}
+
+func recoverTo(config config, args []string) error {
+	fs := flag.NewFlagSet("recover", flag.ExitOnError)
+	seq := fs.Uint64("seq", 0, "last sequence number to recover")
+	at := fs.String("time", "", "last commit time to recover, RFC3339 format")
+	out := fs.String("out", "", "new database file, must not exist")
+	fs.Parse(args)
+
+	var p db.Point
+	p.Seq = *seq
+	if *at != "" {
+		t, err := time.Parse(time.RFC3339Nano, *at)
+		if err != nil {
+			return fmt.Errorf("could not parse time %s: %v", *at, err)
+		}
+		p.Time = t
+	}
+	if *out == "" {
+		return fmt.Errorf("recover needs a new database file, use -out")
+	}
+
+	src, err := db.OpenReadOnly(db.OSFS{}, config.Filename)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	dst, err := os.OpenFile(*out, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return fmt.Errorf("could not create file %s: %v", *out, err)
+	}
+	defer dst.Close()
+
+	recovered, err := src.RecoverTo(dst, p)
+	if err != nil {
+		os.Remove(*out)
+		return err
+	}
+	if err := dst.Sync(); err != nil {
+		return fmt.Errorf("could not sync file %s: %v", *out, err)
+	}
+	log.Printf("recovered %s up to sequence number %d into %s", config.Filename, recovered.Seq(), *out)
+	return nil
 }
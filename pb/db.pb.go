@@ -29,9 +29,15 @@ var _ = math.Inf
 const _ = proto.ProtoPackageIsVersion2 // please upgrade the proto package
 
 type Entity struct {
-	Tombstone bool   `protobuf:"varint,1,opt,name=tombstone" json:"tombstone,omitempty"`
-	Key       string `protobuf:"bytes,2,opt,name=key" json:"key,omitempty"`
-	Value     []byte `protobuf:"bytes,3,opt,name=value,proto3" json:"value,omitempty"`
+	Tombstone    bool              `protobuf:"varint,1,opt,name=tombstone" json:"tombstone,omitempty"`
+	Key          string            `protobuf:"bytes,2,opt,name=key" json:"key,omitempty"`
+	Value        []byte            `protobuf:"bytes,3,opt,name=value,proto3" json:"value,omitempty"`
+	Seq          uint64            `protobuf:"varint,4,opt,name=seq" json:"seq,omitempty"`
+	Timestamp    int64             `protobuf:"varint,5,opt,name=timestamp" json:"timestamp,omitempty"`
+	ContentType  string            `protobuf:"bytes,6,opt,name=content_type,json=contentType" json:"content_type,omitempty"`
+	Metadata     map[string]string `protobuf:"bytes,7,rep,name=metadata" json:"metadata,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
+	Hash         []byte            `protobuf:"bytes,8,opt,name=hash,proto3" json:"hash,omitempty"`
+	CacheControl string            `protobuf:"bytes,9,opt,name=cache_control,json=cacheControl" json:"cache_control,omitempty"`
 }
 
 func (m *Entity) Reset()                    { *m = Entity{} }
@@ -60,6 +66,48 @@ func (m *Entity) GetValue() []byte {
 	return nil
 }
 
+func (m *Entity) GetSeq() uint64 {
+	if m != nil {
+		return m.Seq
+	}
+	return 0
+}
+
+func (m *Entity) GetTimestamp() int64 {
+	if m != nil {
+		return m.Timestamp
+	}
+	return 0
+}
+
+func (m *Entity) GetContentType() string {
+	if m != nil {
+		return m.ContentType
+	}
+	return ""
+}
+
+func (m *Entity) GetMetadata() map[string]string {
+	if m != nil {
+		return m.Metadata
+	}
+	return nil
+}
+
+func (m *Entity) GetHash() []byte {
+	if m != nil {
+		return m.Hash
+	}
+	return nil
+}
+
+func (m *Entity) GetCacheControl() string {
+	if m != nil {
+		return m.CacheControl
+	}
+	return ""
+}
+
 func init() {
 	proto.RegisterType((*Entity)(nil), "pb.Entity")
 }
@@ -67,12 +115,21 @@ func init() {
 func init() { proto.RegisterFile("db.proto", fileDescriptor0) }
 
 var fileDescriptor0 = []byte{
-	// 109 bytes of a gzipped FileDescriptorProto
-	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0xe2, 0xe2, 0x48, 0x49, 0xd2, 0x2b,
-	0x28, 0xca, 0x2f, 0xc9, 0x17, 0x62, 0x2a, 0x48, 0x52, 0xf2, 0xe3, 0x62, 0x73, 0xcd, 0x2b, 0xc9,
-	0x2c, 0xa9, 0x14, 0x92, 0xe1, 0xe2, 0x2c, 0xc9, 0xcf, 0x4d, 0x2a, 0x2e, 0xc9, 0xcf, 0x4b, 0x95,
-	0x60, 0x54, 0x60, 0xd4, 0xe0, 0x08, 0x42, 0x08, 0x08, 0x09, 0x70, 0x31, 0x67, 0xa7, 0x56, 0x4a,
-	0x30, 0x29, 0x30, 0x6a, 0x70, 0x06, 0x81, 0x98, 0x42, 0x22, 0x5c, 0xac, 0x65, 0x89, 0x39, 0xa5,
-	0xa9, 0x12, 0xcc, 0x0a, 0x8c, 0x1a, 0x3c, 0x41, 0x10, 0x4e, 0x12, 0x1b, 0xd8, 0x68, 0x63, 0x40,
-	0x00, 0x00, 0x00, 0xff, 0xff, 0x9c, 0x45, 0xd3, 0x20, 0x66, 0x00, 0x00, 0x00,
+	// 253 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x6c, 0x90, 0x31, 0x4b, 0xc4, 0x40,
+	0x10, 0x85, 0xd9, 0x24, 0x17, 0x93, 0xb9, 0x1c, 0xc8, 0x62, 0x31, 0x88, 0xc5, 0xaa, 0xcd, 0x56,
+	0x29, 0xd4, 0x42, 0xb4, 0x94, 0x2b, 0x6d, 0x16, 0xfb, 0x63, 0x93, 0x1b, 0xc8, 0xe1, 0x25, 0xbb,
+	0x26, 0xa3, 0x90, 0x1f, 0xe9, 0x7f, 0x92, 0x6c, 0x4e, 0x0f, 0xe1, 0xba, 0x37, 0xdf, 0x0c, 0x6f,
+	0x66, 0x1e, 0x64, 0xdb, 0xaa, 0xf4, 0xbd, 0x63, 0x27, 0x23, 0x5f, 0xdd, 0x7c, 0x47, 0x90, 0xae,
+	0x3b, 0xde, 0xf1, 0x28, 0xaf, 0x20, 0x67, 0xd7, 0x56, 0x03, 0xbb, 0x8e, 0x50, 0x28, 0xa1, 0x33,
+	0x73, 0x04, 0xf2, 0x1c, 0xe2, 0x77, 0x1a, 0x31, 0x52, 0x42, 0xe7, 0x66, 0x92, 0xf2, 0x02, 0x16,
+	0x5f, 0x76, 0xff, 0x49, 0x18, 0x2b, 0xa1, 0x0b, 0x33, 0x17, 0xd3, 0xdc, 0x40, 0x1f, 0x98, 0x28,
+	0xa1, 0x13, 0x33, 0xc9, 0xe0, 0xbb, 0x6b, 0x69, 0x60, 0xdb, 0x7a, 0x5c, 0x28, 0xa1, 0x63, 0x73,
+	0x04, 0xf2, 0x1a, 0x8a, 0xda, 0x75, 0x4c, 0x1d, 0x6f, 0x78, 0xf4, 0x84, 0x69, 0x58, 0xb0, 0x3c,
+	0xb0, 0xb7, 0xd1, 0x93, 0x7c, 0x80, 0xac, 0x25, 0xb6, 0x5b, 0xcb, 0x16, 0xcf, 0x54, 0xac, 0x97,
+	0x77, 0x58, 0xfa, 0xaa, 0x9c, 0xcf, 0x2e, 0x5f, 0x0f, 0xad, 0x75, 0xc7, 0xfd, 0x68, 0xfe, 0x26,
+	0xa5, 0x84, 0xa4, 0xb1, 0x43, 0x83, 0x59, 0xb8, 0x2e, 0x68, 0x79, 0x0b, 0xab, 0xda, 0xd6, 0x0d,
+	0x6d, 0x26, 0xfb, 0xde, 0xed, 0x31, 0x0f, 0xdb, 0x8a, 0x00, 0x5f, 0x66, 0x76, 0xf9, 0x0c, 0xab,
+	0x7f, 0x9e, 0xbf, 0xaf, 0x8b, 0x13, 0xaf, 0xcf, 0x71, 0xcc, 0xc5, 0x53, 0xf4, 0x28, 0xaa, 0x34,
+	0x44, 0x7b, 0xff, 0x33, 0x00, 0xe0, 0xdc, 0xfa, 0x77, 0x66, 0x01, 0x00, 0x00,
 }
@@ -0,0 +1,128 @@
+// Package certs provides the TLS configuration of the server. The certificate
+// and the CA bundle of client certificates are reloaded from their files
+// without a restart, open connections keep the certificate of their handshake.
+package certs
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"sync"
+	"time"
+
+	"github.com/gerlacdt/db-key-value-store/pkg/filewatch"
+)
+
+// Reloader holds the certificate of the server and the CA bundle of client
+// certificates. It is safe for concurrent use.
+type Reloader struct {
+	certFile     string
+	keyFile      string
+	clientCAFile string
+	clientAuth   tls.ClientAuthType
+
+	lock     sync.RWMutex
+	cert     *tls.Certificate
+	clientCA *x509.CertPool
+	version  filewatch.Version // of certFile, keyFile and clientCAFile
+}
+
+// Option configures a Reloader.
+type Option func(*Reloader)
+
+// WithClientCA verifies client certificates against the PEM encoded CA bundle
+// (mutual TLS). Clients without a certificate are rejected if required is
+// set, otherwise only given certificates are verified.
+func WithClientCA(caFile string, required bool) Option {
+	return func(r *Reloader) {
+		r.clientCAFile = caFile
+		r.clientAuth = tls.VerifyClientCertIfGiven
+		if required {
+			r.clientAuth = tls.RequireAndVerifyClientCert
+		}
+	}
+}
+
+// New loads the PEM encoded certificate and key of the server.
+func New(certFile, keyFile string, opts ...Option) (*Reloader, error) {
+	r := &Reloader{certFile: certFile, keyFile: keyFile, clientAuth: tls.NoClientCert}
+	for _, opt := range opts {
+		opt(r)
+	}
+	if err := r.Reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Reload loads the files again. On errors the previous certificate stays in
+// use, e.g. if the certificate was replaced but the key not yet.
+func (r *Reloader) Reload() error {
+	version := filewatch.Stat(r.certFile, r.keyFile, r.clientCAFile)
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return fmt.Errorf("could not load certificate %s: %v", r.certFile, err)
+	}
+	var clientCA *x509.CertPool
+	if r.clientCAFile != "" {
+		pem, err := ioutil.ReadFile(r.clientCAFile)
+		if err != nil {
+			return fmt.Errorf("could not read client CA %s: %v", r.clientCAFile, err)
+		}
+		clientCA = x509.NewCertPool()
+		if !clientCA.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("no certificates in client CA %s", r.clientCAFile)
+		}
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.cert, r.clientCA, r.version = &cert, clientCA, version
+	return nil
+}
+
+// Watch reloads the files after changes until the context is cancelled, see
+// filewatch.Watch.
+func (r *Reloader) Watch(ctx context.Context, interval time.Duration) {
+	filewatch.Watch(ctx, interval, r.loaded, r.Reload, "certificate "+r.certFile)
+}
+
+// loaded returns the version of the files of the last successful reload.
+func (r *Reloader) loaded() filewatch.Version {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	return r.version
+}
+
+// GetCertificate returns the certificate loaded last, see
+// tls.Config.GetCertificate.
+func (r *Reloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	return r.cert, nil
+}
+
+// TLSConfig returns the configuration of a server. Every handshake uses the
+// certificate and client CA bundle loaded last.
+func (r *Reloader) TLSConfig() *tls.Config {
+	nextProtos := []string{"h2", "http/1.1"}
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		NextProtos: nextProtos,
+		// lets http.Server.ListenAndServeTLS start without certificate files
+		GetCertificate: r.GetCertificate,
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			r.lock.RLock()
+			defer r.lock.RUnlock()
+			return &tls.Config{
+				MinVersion:   tls.VersionTLS12,
+				NextProtos:   nextProtos,
+				Certificates: []tls.Certificate{*r.cert},
+				ClientAuth:   r.clientAuth,
+				ClientCAs:    r.clientCA,
+			}, nil
+		},
+	}
+}
@@ -0,0 +1,272 @@
+package certs
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// ca is a locally generated certificate authority.
+type ca struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pem  []byte
+}
+
+func newCA(t *testing.T) *ca {
+	t.Helper()
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("error generating key %v", err)
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("error creating certificate %v", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("error parsing certificate %v", err)
+	}
+	return &ca{cert: cert, key: key, pem: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})}
+}
+
+// issue returns a PEM encoded certificate and key signed by the CA, a server
+// certificate for 127.0.0.1 or a client certificate.
+func (c *ca) issue(t *testing.T, serial int64, server bool) (certPEM, keyPEM []byte) {
+	t.Helper()
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: "test"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	if server {
+		template.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}
+		template.IPAddresses = []net.IP{net.ParseIP("127.0.0.1")}
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("error generating key %v", err)
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, c.cert, &key.PublicKey, c.key)
+	if err != nil {
+		t.Fatalf("error creating certificate %v", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("error marshaling key %v", err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+}
+
+func (c *ca) pool() *x509.CertPool {
+	pool := x509.NewCertPool()
+	pool.AddCert(c.cert)
+	return pool
+}
+
+func writeFile(t *testing.T, name string, data []byte) {
+	t.Helper()
+	if err := ioutil.WriteFile(name, data, 0600); err != nil {
+		t.Fatalf("error writing %s: %v", name, err)
+	}
+}
+
+// serverFiles writes the server certificate and key into dir.
+func serverFiles(t *testing.T, c *ca, dir string, serial int64) (certFile, keyFile string) {
+	t.Helper()
+	certPEM, keyPEM := c.issue(t, serial, true)
+	certFile, keyFile = filepath.Join(dir, "server.crt"), filepath.Join(dir, "server.key")
+	writeFile(t, certFile, certPEM)
+	writeFile(t, keyFile, keyPEM)
+	return certFile, keyFile
+}
+
+// startServer starts a TLS server with the configuration of the reloader.
+func startServer(t *testing.T, r *Reloader) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	srv.TLS = r.TLSConfig()
+	srv.StartTLS()
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// newClient returns a client trusting the CA with an optional client
+// certificate.
+func newClient(c *ca, cert *tls.Certificate) *http.Client {
+	config := &tls.Config{RootCAs: c.pool()}
+	if cert != nil {
+		config.Certificates = []tls.Certificate{*cert}
+	}
+	return &http.Client{Transport: &http.Transport{TLSClientConfig: config}}
+}
+
+// serial returns the serial number of the server certificate of a new
+// connection.
+func serial(t *testing.T, client *http.Client, url string) int64 {
+	t.Helper()
+	resp, err := client.Get(url)
+	if err != nil {
+		t.Fatalf("error http GET %v", err)
+	}
+	defer resp.Body.Close()
+	return resp.TLS.PeerCertificates[0].SerialNumber.Int64()
+}
+
+func TestTLS(t *testing.T) {
+	t.Parallel()
+	c := newCA(t)
+	certFile, keyFile := serverFiles(t, c, t.TempDir(), 2)
+	r, err := New(certFile, keyFile)
+	if err != nil {
+		t.Fatalf("error loading certificates %v", err)
+	}
+	srv := startServer(t, r)
+
+	if s := serial(t, newClient(c, nil), srv.URL); s != 2 {
+		t.Fatalf("serial expected 2, got %d", s)
+	}
+	// the server is not trusted without the CA
+	if _, err := http.Get(srv.URL); err == nil {
+		t.Fatalf("expected certificate error")
+	}
+
+	if _, err := New(certFile, filepath.Join(t.TempDir(), "missing.key")); err == nil {
+		t.Fatalf("expected error for missing key")
+	}
+}
+
+func TestMutualTLS(t *testing.T) {
+	t.Parallel()
+	c := newCA(t)
+	dir := t.TempDir()
+	certFile, keyFile := serverFiles(t, c, dir, 2)
+	caFile := filepath.Join(dir, "ca.crt")
+	writeFile(t, caFile, c.pem)
+	r, err := New(certFile, keyFile, WithClientCA(caFile, true))
+	if err != nil {
+		t.Fatalf("error loading certificates %v", err)
+	}
+	srv := startServer(t, r)
+
+	certPEM, keyPEM := c.issue(t, 3, false)
+	clientCert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		t.Fatalf("error loading client certificate %v", err)
+	}
+	if s := serial(t, newClient(c, &clientCert), srv.URL); s != 2 {
+		t.Fatalf("serial expected 2, got %d", s)
+	}
+
+	// no certificate or a certificate of an unknown CA is rejected
+	certPEM, keyPEM = newCA(t).issue(t, 4, false)
+	unknownCert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		t.Fatalf("error loading client certificate %v", err)
+	}
+	for _, cert := range []*tls.Certificate{nil, &unknownCert} {
+		if _, err := newClient(c, cert).Get(srv.URL); err == nil {
+			t.Fatalf("expected handshake error")
+		}
+	}
+}
+
+func TestReload(t *testing.T) {
+	t.Parallel()
+	c := newCA(t)
+	dir := t.TempDir()
+	certFile, keyFile := serverFiles(t, c, dir, 2)
+	r, err := New(certFile, keyFile)
+	if err != nil {
+		t.Fatalf("error loading certificates %v", err)
+	}
+	srv := startServer(t, r)
+	client := newClient(c, nil)
+	if s := serial(t, client, srv.URL); s != 2 {
+		t.Fatalf("serial expected 2, got %d", s)
+	}
+
+	// a broken key keeps the previous certificate
+	writeFile(t, keyFile, []byte("broken"))
+	if err := r.Reload(); err == nil {
+		t.Fatalf("expected error for broken key")
+	}
+	if s := serial(t, newClient(c, nil), srv.URL); s != 2 {
+		t.Fatalf("previous certificate expected serial 2, got %d", s)
+	}
+
+	serverFiles(t, c, dir, 5)
+	if err := r.Reload(); err != nil {
+		t.Fatalf("error reloading %v", err)
+	}
+	// the open connection is reused with its certificate, new connections get the new one
+	if s := serial(t, client, srv.URL); s != 2 {
+		t.Fatalf("open connection expected serial 2, got %d", s)
+	}
+	if s := serial(t, newClient(c, nil), srv.URL); s != 5 {
+		t.Fatalf("serial expected 5, got %d", s)
+	}
+}
+
+func TestWatch(t *testing.T) {
+	t.Parallel()
+	c := newCA(t)
+	dir := t.TempDir()
+	certFile, keyFile := serverFiles(t, c, dir, 2)
+	r, err := New(certFile, keyFile)
+	if err != nil {
+		t.Fatalf("error loading certificates %v", err)
+	}
+	srv := startServer(t, r)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go r.Watch(ctx, 10*time.Millisecond)
+
+	serverFiles(t, c, dir, 6)
+	// the modification time may not change within its granularity
+	future := time.Now().Add(time.Minute)
+	for _, name := range []string{certFile, keyFile} {
+		if err := os.Chtimes(name, future, future); err != nil {
+			t.Fatalf("error touching %s: %v", name, err)
+		}
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if s := serial(t, newClient(c, nil), srv.URL); s == 6 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("certificate not reloaded")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,372 @@
+// Package client is a Go client of the HTTP API of the key-value store.
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrNotFound is returned for keys which do not exist.
+	ErrNotFound = errors.New("key does not exist")
+	// ErrBadRequest is returned for invalid requests, e.g. invalid keys.
+	ErrBadRequest = errors.New("bad request")
+	// ErrUnauthenticated is returned for requests without a valid token.
+	ErrUnauthenticated = errors.New("unauthenticated")
+	// ErrPermissionDenied is returned if the token has no grant for the request.
+	ErrPermissionDenied = errors.New("permission denied")
+	// ErrQuotaExceeded is returned for writes exceeding a storage quota.
+	ErrQuotaExceeded = errors.New("quota exceeded")
+	// ErrRateLimited is returned for writes exceeding the write rate limit.
+	ErrRateLimited = errors.New("rate limited")
+	// ErrUnavailable is returned if the server failed or is unavailable.
+	ErrUnavailable = errors.New("server unavailable")
+)
+
+// Error codes of the server which the client distinguishes, see the codes of
+// the handler package.
+const (
+	CodeTooLarge = "too_large"
+	CodeReadOnly = "read_only"
+)
+
+// Error is an error response of the server. It matches the sentinel error of
+// its status code with errors.Is.
+type Error struct {
+	StatusCode int
+	Code       string // stable error code, e.g. not_found or read_only
+	Message    string
+	RequestID  string
+	RetryAfter time.Duration // from the Retry-After header
+}
+
+func (e *Error) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("%d %s", e.StatusCode, http.StatusText(e.StatusCode))
+	}
+	return fmt.Sprintf("%d %s: %s", e.StatusCode, http.StatusText(e.StatusCode), e.Message)
+}
+
+// Is reports whether target is the sentinel error of the status code.
+func (e *Error) Is(target error) bool {
+	switch e.StatusCode {
+	case http.StatusNotFound:
+		return target == ErrNotFound
+	case http.StatusBadRequest:
+		return target == ErrBadRequest
+	case http.StatusUnauthorized:
+		return target == ErrUnauthenticated
+	case http.StatusForbidden:
+		return target == ErrPermissionDenied
+	case http.StatusRequestEntityTooLarge:
+		return target == ErrQuotaExceeded && e.Code != CodeTooLarge
+	case http.StatusTooManyRequests:
+		return target == ErrRateLimited
+	case http.StatusInternalServerError, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return target == ErrUnavailable
+	}
+	return false
+}
+
+// temporary reports whether a retry may succeed.
+func (e *Error) temporary() bool {
+	switch e.StatusCode {
+	case http.StatusServiceUnavailable:
+		// a read-only database stays read-only
+		return e.Code != CodeReadOnly
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// Client is a client of the HTTP API. It is safe for concurrent use and reuses
+// connections.
+type Client struct {
+	baseURL    string
+	adminURL   string
+	token      string
+	httpClient *http.Client
+	retries    int
+	backoff    time.Duration
+	maxBackoff time.Duration
+}
+
+// Option configures a Client.
+type Option func(*Client)
+
+// WithHTTPClient sets the HTTP client, e.g. for timeouts or TLS.
+func WithHTTPClient(httpClient *http.Client) Option {
+	return func(c *Client) { c.httpClient = httpClient }
+}
+
+// WithRetries retries idempotent operations up to n times after network errors
+// and temporary failures. The backoff doubles with every attempt up to
+// maxBackoff, a Retry-After of the server takes precedence.
+func WithRetries(n int, backoff, maxBackoff time.Duration) Option {
+	return func(c *Client) { c.retries, c.backoff, c.maxBackoff = n, backoff, maxBackoff }
+}
+
+// WithAdminURL sets the address of the admin API, e.g. http://localhost:8081,
+// if the server serves it on a separate listener. Export and Import use the
+// admin API.
+func WithAdminURL(adminURL string) Option {
+	return func(c *Client) { c.adminURL = strings.TrimSuffix(adminURL, "/") }
+}
+
+// WithToken sends the bearer token with every request.
+func WithToken(token string) Option {
+	return func(c *Client) { c.token = token }
+}
+
+// New returns a client of the server at baseURL, e.g. http://localhost:8080.
+// By default idempotent operations are retried 3 times with a backoff starting
+// at 50ms.
+func New(baseURL string, opts ...Option) *Client {
+	c := &Client{
+		baseURL:    strings.TrimSuffix(baseURL, "/"),
+		adminURL:   strings.TrimSuffix(baseURL, "/"),
+		httpClient: http.DefaultClient,
+		retries:    3,
+		backoff:    50 * time.Millisecond,
+		maxBackoff: 2 * time.Second,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// Get returns the value of the key or an error matching ErrNotFound.
+func (c *Client) Get(ctx context.Context, key string) ([]byte, error) {
+	var value []byte
+	err := c.do(ctx, http.MethodGet, c.keyURL(key), nil, func(resp *http.Response) error {
+		var err error
+		value, err = ioutil.ReadAll(resp.Body)
+		return err
+	})
+	return value, err
+}
+
+// Set stores the value of the key. Set is idempotent and retried.
+func (c *Client) Set(ctx context.Context, key string, value []byte) error {
+	return c.do(ctx, http.MethodPost, c.keyURL(key), value, nil)
+}
+
+// Delete deletes the key. Delete is retried, a missing key on a retry is
+// deleted by an earlier attempt whose response was lost.
+func (c *Client) Delete(ctx context.Context, key string) error {
+	return c.do(ctx, http.MethodDelete, c.keyURL(key), nil, nil)
+}
+
+// List returns up to limit keys with the prefix in sorted order, all of them
+// if limit is not positive.
+func (c *Client) List(ctx context.Context, prefix string, limit int) ([]string, error) {
+	query := url.Values{"prefix": {prefix}}
+	if limit > 0 {
+		query.Set("limit", strconv.Itoa(limit))
+	}
+	var keys []string
+	err := c.do(ctx, http.MethodGet, c.baseURL+"/db/?"+query.Encode(), nil, func(resp *http.Response) error {
+		return json.NewDecoder(resp.Body).Decode(&keys)
+	})
+	return keys, err
+}
+
+// Record is a committed write, a delete if Tombstone is set.
+type Record struct {
+	Key       string    `json:"key"`
+	Value     []byte    `json:"value"`
+	Seq       uint64    `json:"seq"`
+	Timestamp time.Time `json:"timestamp"`
+	Tombstone bool      `json:"tombstone,omitempty"`
+
+	ContentType  string            `json:"contentType,omitempty"`
+	CacheControl string            `json:"cacheControl,omitempty"`
+	Metadata     map[string]string `json:"metadata,omitempty"`
+}
+
+// WatchOptions configure a watch.
+type WatchOptions struct {
+	Prefix  bool          // watch all keys starting with the key
+	Since   uint64        // version after which changes are returned, the current version if zero
+	Timeout time.Duration // server side timeout, the server default if zero
+}
+
+// Watch waits for the next write of the key after opts.Since and returns it
+// with its version. After the timeout the record is nil and the version is the
+// current version of the database, which is the Since of the next watch.
+func (c *Client) Watch(ctx context.Context, key string, opts WatchOptions) (*Record, uint64, error) {
+	query := url.Values{"watch": {"true"}}
+	if opts.Prefix {
+		query.Set("prefix", "true")
+	}
+	if opts.Since > 0 {
+		query.Set("since", strconv.FormatUint(opts.Since, 10))
+	}
+	if opts.Timeout > 0 {
+		query.Set("timeout", opts.Timeout.String())
+	}
+	var (
+		record  *Record
+		version uint64
+	)
+	err := c.do(ctx, http.MethodGet, c.keyURL(key)+"?"+query.Encode(), nil, func(resp *http.Response) error {
+		var err error
+		version, err = strconv.ParseUint(resp.Header.Get("X-Version"), 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid X-Version %q", resp.Header.Get("X-Version"))
+		}
+		if resp.StatusCode == http.StatusNoContent {
+			return nil
+		}
+		record = &Record{}
+		return json.NewDecoder(resp.Body).Decode(record)
+	})
+	return record, version, err
+}
+
+// Export writes the live keyspace in the format, "jsonl" or "pb", to w. The
+// export is streamed and not retried.
+func (c *Client) Export(ctx context.Context, format string, w io.Writer) error {
+	u := c.adminURL + "/admin/export?" + url.Values{"format": {format}}.Encode()
+	return c.send(ctx, http.MethodGet, u, nil, func(resp *http.Response) error {
+		_, err := io.Copy(w, resp.Body)
+		return err
+	})
+}
+
+// Import loads an export in the format from r and returns the number of
+// imported entities. The import is streamed and not retried.
+func (c *Client) Import(ctx context.Context, format string, r io.Reader) (int, error) {
+	u := c.adminURL + "/admin/import?" + url.Values{"format": {format}}.Encode()
+	var result struct {
+		Imported int `json:"imported"`
+	}
+	err := c.send(ctx, http.MethodPost, u, r, func(resp *http.Response) error {
+		return json.NewDecoder(resp.Body).Decode(&result)
+	})
+	return result.Imported, err
+}
+
+func (c *Client) keyURL(key string) string {
+	return c.baseURL + "/db/" + url.PathEscape(key)
+}
+
+// do sends the request with retries and calls read with the response of
+// success status codes.
+func (c *Client) do(ctx context.Context, method, url string, body []byte, read func(*http.Response) error) error {
+	var err error
+	for attempt := 0; ; attempt++ {
+		var retryAfter time.Duration
+		var r io.Reader
+		if body != nil {
+			r = bytes.NewReader(body)
+		}
+		err = c.send(ctx, method, url, r, read)
+		var herr *Error
+		switch {
+		case err == nil:
+			return nil
+		case attempt > 0 && method == http.MethodDelete && errors.Is(err, ErrNotFound):
+			return nil
+		case ctx.Err() != nil:
+			return ctx.Err()
+		case errors.As(err, &herr):
+			if !herr.temporary() {
+				return err
+			}
+			retryAfter = herr.RetryAfter
+		}
+		if attempt >= c.retries {
+			return err
+		}
+
+		wait := c.backoff << uint(attempt)
+		if wait > c.maxBackoff || wait <= 0 {
+			wait = c.maxBackoff
+		}
+		// jitter, so clients do not retry in lockstep
+		wait = wait/2 + time.Duration(rand.Int63n(int64(wait/2)+1))
+		if retryAfter > 0 {
+			wait = retryAfter
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// send sends the request once and calls read with the response of success
+// status codes.
+func (c *Client) send(ctx context.Context, method, url string, body io.Reader, read func(*http.Response) error) error {
+	req, err := http.NewRequestWithContext(ctx, method, url, body)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/octet-stream")
+	}
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		// drain the body, so the connection is reused
+		io.Copy(ioutil.Discard, resp.Body)
+		resp.Body.Close()
+	}()
+
+	if resp.StatusCode >= 300 {
+		return newError(resp)
+	}
+	if read != nil {
+		return read(resp)
+	}
+	return nil
+}
+
+// newError reads the error of the response, the code, message and request id
+// are taken from a JSON body with code, message and requestId fields. Other
+// bodies are used as message.
+func newError(resp *http.Response) error {
+	e := &Error{StatusCode: resp.StatusCode}
+	if s := resp.Header.Get("Retry-After"); s != "" {
+		if seconds, err := strconv.Atoi(s); err == nil {
+			e.RetryAfter = time.Duration(seconds) * time.Second
+		}
+	}
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	if err != nil {
+		return e
+	}
+	var jsonError struct {
+		Code      string `json:"code"`
+		Message   string `json:"message"`
+		RequestID string `json:"requestId"`
+	}
+	if json.Unmarshal(body, &jsonError) == nil {
+		e.Code, e.Message, e.RequestID = jsonError.Code, jsonError.Message, jsonError.RequestID
+	} else {
+		e.Message = strings.TrimSpace(string(body))
+	}
+	return e
+}
@@ -0,0 +1,280 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gerlacdt/db-key-value-store/pb"
+	"github.com/gerlacdt/db-key-value-store/pkg/db"
+	"github.com/gerlacdt/db-key-value-store/pkg/handler"
+	"github.com/mattetti/filebuffer"
+)
+
+func setup(t *testing.T, d *db.DB) *httptest.Server {
+	t.Parallel()
+	return newServer(t, d)
+}
+
+func newServer(t *testing.T, d *db.DB) *httptest.Server {
+	h, err := handler.New(d)
+	if err != nil {
+		t.Fatalf("could not create handler: %v", err)
+	}
+	srv := httptest.NewServer(h)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestSetGetDelete(t *testing.T) {
+	srv := setup(t, db.New(filebuffer.New(nil)))
+	c := New(srv.URL)
+	ctx := context.Background()
+
+	if err := c.Set(ctx, "foo-key", []byte("foo-value")); err != nil {
+		t.Fatalf("error SET %v", err)
+	}
+	value, err := c.Get(ctx, "foo-key")
+	if err != nil {
+		t.Fatalf("error GET %v", err)
+	}
+	if string(value) != "foo-value" {
+		t.Fatalf("value expected %s, got %s", "foo-value", value)
+	}
+	if err := c.Delete(ctx, "foo-key"); err != nil {
+		t.Fatalf("error DELETE %v", err)
+	}
+	_, err = c.Get(ctx, "foo-key")
+	if !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected %v, got %v", ErrNotFound, err)
+	}
+	var herr *Error
+	if !errors.As(err, &herr) || herr.StatusCode != http.StatusNotFound {
+		t.Fatalf("expected *Error with status 404, got %v", err)
+	}
+	if herr.Code != "not_found" || herr.Message == "" || herr.RequestID == "" {
+		t.Fatalf("expected code, message and request ID, got %+v", herr)
+	}
+	if err := c.Delete(ctx, "foo-key"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected %v, got %v", ErrNotFound, err)
+	}
+	if _, err := c.Get(ctx, "foo/key"); !errors.Is(err, ErrBadRequest) {
+		t.Fatalf("expected %v, got %v", ErrBadRequest, err)
+	}
+}
+
+func TestCodes(t *testing.T) {
+	t.Parallel()
+	if CodeTooLarge != handler.CodeTooLarge || CodeReadOnly != handler.CodeReadOnly {
+		t.Fatalf("codes expected to match the handler codes")
+	}
+}
+
+func TestRetries(t *testing.T) {
+	t.Parallel()
+	h, err := handler.New(db.New(filebuffer.New(nil)))
+	if err != nil {
+		t.Fatalf("could not create handler: %v", err)
+	}
+	// the first two requests fail
+	var requests int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&requests, 1) <= 2 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		h.ServeHTTP(w, r)
+	}))
+	defer srv.Close()
+	ctx := context.Background()
+
+	c := New(srv.URL, WithRetries(2, time.Millisecond, 10*time.Millisecond))
+	if err := c.Set(ctx, "foo-key", []byte("foo-value")); err != nil {
+		t.Fatalf("error SET %v", err)
+	}
+	if requests != 3 {
+		t.Fatalf("requests expected %d, got %d", 3, requests)
+	}
+
+	atomic.StoreInt32(&requests, 0)
+	c = New(srv.URL, WithRetries(1, time.Millisecond, 10*time.Millisecond))
+	if _, err := c.Get(ctx, "foo-key"); !errors.Is(err, ErrUnavailable) {
+		t.Fatalf("expected %v, got %v", ErrUnavailable, err)
+	}
+}
+
+func TestRetriedDelete(t *testing.T) {
+	t.Parallel()
+	d := db.New(filebuffer.New(nil))
+	h, err := handler.New(d)
+	if err != nil {
+		t.Fatalf("could not create handler: %v", err)
+	}
+	if err := d.Set(&pb.Entity{Key: "foo-key", Value: []byte("foo-value")}); err != nil {
+		t.Fatalf("error SET %v", err)
+	}
+	// the response of the first request is lost after the key was deleted
+	var requests int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&requests, 1) > 1 {
+			h.ServeHTTP(w, r)
+			return
+		}
+		h.ServeHTTP(httptest.NewRecorder(), r)
+		conn, _, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			t.Errorf("could not hijack connection: %v", err)
+			return
+		}
+		conn.Close()
+	}))
+	defer srv.Close()
+	ctx := context.Background()
+
+	c := New(srv.URL, WithRetries(2, time.Millisecond, 10*time.Millisecond))
+	if err := c.Delete(ctx, "foo-key"); err != nil {
+		t.Fatalf("error DELETE %v", err)
+	}
+	if requests != 2 {
+		t.Fatalf("requests expected %d, got %d", 2, requests)
+	}
+	// without retries a missing key is still reported
+	if err := c.Delete(ctx, "foo-key"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected %v, got %v", ErrNotFound, err)
+	}
+}
+
+func TestToken(t *testing.T) {
+	t.Parallel()
+	file := filepath.Join(t.TempDir(), "tokens.json")
+	tokens := `[{"name":"reader","token":"secret","grants":[{"prefix":"user-","ops":["read"]}]}]`
+	if err := ioutil.WriteFile(file, []byte(tokens), 0600); err != nil {
+		t.Fatalf("error writing tokens %v", err)
+	}
+	loaded, err := handler.LoadTokens(file)
+	if err != nil {
+		t.Fatalf("error loading tokens %v", err)
+	}
+	h, err := handler.New(db.New(filebuffer.New(nil)), handler.WithTokens(loaded))
+	if err != nil {
+		t.Fatalf("could not create handler: %v", err)
+	}
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+	ctx := context.Background()
+
+	if _, err := New(srv.URL).Get(ctx, "user-1"); !errors.Is(err, ErrUnauthenticated) {
+		t.Fatalf("expected %v, got %v", ErrUnauthenticated, err)
+	}
+	c := New(srv.URL, WithToken("secret"))
+	if _, err := c.Get(ctx, "user-1"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected %v, got %v", ErrNotFound, err)
+	}
+	if err := c.Set(ctx, "user-1", []byte("value")); !errors.Is(err, ErrPermissionDenied) {
+		t.Fatalf("expected %v, got %v", ErrPermissionDenied, err)
+	}
+}
+
+func TestRateLimited(t *testing.T) {
+	d := db.New(filebuffer.New(nil))
+	d.SetQuotas([]db.Quota{{WritesPerSecond: 1}})
+	srv := setup(t, d)
+	c := New(srv.URL, WithRetries(0, 0, 0))
+	ctx := context.Background()
+
+	if err := c.Set(ctx, "foo-key", []byte("foo-value")); err != nil {
+		t.Fatalf("error SET %v", err)
+	}
+	err := c.Set(ctx, "foo-key", []byte("foo-value"))
+	if !errors.Is(err, ErrRateLimited) {
+		t.Fatalf("expected %v, got %v", ErrRateLimited, err)
+	}
+	var herr *Error
+	if !errors.As(err, &herr) || herr.RetryAfter != time.Second {
+		t.Fatalf("expected Retry-After of 1s, got %v", err)
+	}
+
+	// cancelled while waiting for the retry
+	c = New(srv.URL, WithRetries(3, time.Millisecond, time.Millisecond))
+	ctx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	if err := c.Set(ctx, "foo-key", []byte("foo-value")); err != context.DeadlineExceeded {
+		t.Fatalf("expected %v, got %v", context.DeadlineExceeded, err)
+	}
+}
+
+func TestListWatch(t *testing.T) {
+	srv := setup(t, db.New(filebuffer.New(nil)))
+	c := New(srv.URL)
+	ctx := context.Background()
+
+	for _, key := range []string{"user-2", "user-1", "order-1"} {
+		if err := c.Set(ctx, key, []byte("value")); err != nil {
+			t.Fatalf("error SET %v", err)
+		}
+	}
+	keys, err := c.List(ctx, "user-", 0)
+	if err != nil {
+		t.Fatalf("error listing keys %v", err)
+	}
+	if !reflect.DeepEqual([]string{"user-1", "user-2"}, keys) {
+		t.Fatalf("keys expected %v, got %v", []string{"user-1", "user-2"}, keys)
+	}
+
+	record, version, err := c.Watch(ctx, "user-", WatchOptions{Prefix: true, Timeout: 10 * time.Millisecond})
+	if err != nil || record != nil || version != 3 {
+		t.Fatalf("expected timeout at version 3, got %v %d %v", record, version, err)
+	}
+	if err := c.Delete(ctx, "user-1"); err != nil {
+		t.Fatalf("error DELETE %v", err)
+	}
+	record, version, err = c.Watch(ctx, "user-", WatchOptions{Prefix: true, Since: version})
+	if err != nil {
+		t.Fatalf("error watching %v", err)
+	}
+	if record == nil || record.Key != "user-1" || !record.Tombstone || version != 4 {
+		t.Fatalf("expected delete of user-1 at version 4, got %+v %d", record, version)
+	}
+}
+
+func TestExportImport(t *testing.T) {
+	src := New(setup(t, db.New(filebuffer.New(nil))).URL)
+	// the target serves the admin API on a separate listener
+	d := db.New(filebuffer.New(nil))
+	h, err := handler.New(d, handler.WithSeparateAdmin())
+	if err != nil {
+		t.Fatalf("could not create handler: %v", err)
+	}
+	data, admin := httptest.NewServer(h), httptest.NewServer(handler.NewAdmin(d))
+	defer data.Close()
+	defer admin.Close()
+	dst := New(data.URL, WithAdminURL(admin.URL))
+	ctx := context.Background()
+
+	if err := src.Set(ctx, "foo-key", []byte("foo-value")); err != nil {
+		t.Fatalf("error SET %v", err)
+	}
+	var buf bytes.Buffer
+	if err := src.Export(ctx, "pb", &buf); err != nil {
+		t.Fatalf("error exporting %v", err)
+	}
+	n, err := dst.Import(ctx, "pb", &buf)
+	if err != nil || n != 1 {
+		t.Fatalf("expected 1 imported entity, got %d %v", n, err)
+	}
+	value, err := dst.Get(ctx, "foo-key")
+	if err != nil || string(value) != "foo-value" {
+		t.Fatalf("expected foo-value, got %s %v", value, err)
+	}
+	if _, err := dst.Import(ctx, "xml", &buf); !errors.Is(err, ErrBadRequest) {
+		t.Fatalf("expected %v, got %v", ErrBadRequest, err)
+	}
+}
@@ -0,0 +1,164 @@
+package db
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+
+	"github.com/gerlacdt/db-key-value-store/pb"
+	"github.com/golang/protobuf/proto"
+)
+
+// backupChunkSize is the number of bytes copied while holding the lock.
+const backupChunkSize = 64 * 1024
+
+// Size returns the current size of the log in bytes.
+func (db *DB) Size() (int64, error) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	return db.end()
+}
+
+// Snapshot is a consistent view of the log, the log prefix up to Size.
+type Snapshot struct {
+	db          *DB
+	Size        int64
+	compactions uint64
+}
+
+// Snapshot captures the end of the last committed record of the log, a torn
+// record of a failed write is not part of it. The log is append-only, so the
+// snapshot stays consistent while the database keeps accepting writes. Reads
+// of the snapshot fail with ErrCompacted after a compaction.
+func (db *DB) Snapshot() (*Snapshot, error) {
+	db.lock.RLock()
+	defer db.lock.RUnlock()
+
+	if db.closed {
+		return nil, ErrClosed
+	}
+	return &Snapshot{db: db, Size: db.size, compactions: db.compactions}, nil
+}
+
+// WriteTo writes the snapshot to w. It returns the number of bytes written.
+func (s *Snapshot) WriteTo(w io.Writer) (int64, error) {
+	buf := make([]byte, backupChunkSize)
+	written := int64(0)
+	for written < s.Size {
+		chunk := buf
+		if rest := s.Size - written; rest < int64(len(chunk)) {
+			chunk = chunk[:rest]
+		}
+		if err := s.db.readChunk(chunk, written, s.compactions); err != nil {
+			return written, fmt.Errorf("backup read error %w", err)
+		}
+		n, err := w.Write(chunk)
+		written += int64(n)
+		if err != nil {
+			return written, fmt.Errorf("backup write error %v", err)
+		}
+	}
+	return written, nil
+}
+
+// Backup writes a consistent copy of the log to w while the database keeps
+// accepting writes. It returns the number of bytes written.
+func (db *DB) Backup(w io.Writer) (int64, error) {
+	snap, err := db.Snapshot()
+	if err != nil {
+		return 0, err
+	}
+	return snap.WriteTo(w)
+}
+
+// readChunk fills buf with the log content at the given offset, which may
+// span segments. It fails with ErrCompacted if the log was compacted since the
+// given number of compactions.
+func (db *DB) readChunk(buf []byte, offset int64, compactions uint64) error {
+	// segments of Open are files, the log of New is never replaced
+	positional := db.fs != nil
+	if !positional {
+		_, positional = db.f.(io.ReaderAt)
+	}
+	if positional {
+		db.lock.RLock()
+		defer db.lock.RUnlock()
+	} else {
+		db.lock.Lock()
+		defer db.lock.Unlock()
+	}
+
+	if db.compactions != compactions {
+		return ErrCompacted
+	}
+	for offset < db.base && len(buf) > 0 {
+		s := db.segmentAt(offset)
+		chunk := buf
+		if rest := s.base + s.size - offset; rest < int64(len(chunk)) {
+			chunk = chunk[:rest]
+		}
+		if n, err := s.f.ReadAt(chunk, offset-s.base); err != nil && !(err == io.EOF && n == len(chunk)) {
+			return err
+		}
+		buf, offset = buf[len(chunk):], offset+int64(len(chunk))
+	}
+	if len(buf) == 0 {
+		return nil
+	}
+	if positional {
+		n, err := db.f.(io.ReaderAt).ReadAt(buf, offset-db.base)
+		if err == io.EOF && n == len(buf) {
+			return nil
+		}
+		return err
+	}
+	if _, err := db.f.Seek(offset-db.base, 0); err != nil {
+		return err
+	}
+	_, err := io.ReadFull(db.f, buf)
+	return err
+}
+
+// Restore copies a backup from r into the empty file f and verifies it. The
+// returned database is recovered and ready to use.
+func Restore(f io.ReadWriteSeeker, r io.Reader) (*DB, error) {
+	n, err := Verify(io.TeeReader(r, f))
+	if err != nil {
+		return nil, fmt.Errorf("backup verification failed after %d records: %v", n, err)
+	}
+	db := New(f)
+	if err := db.Recover(); err != nil {
+		return nil, fmt.Errorf("could not recover restored database: %v", err)
+	}
+	return db, nil
+}
+
+// Verify reads a complete log from r and checks that every record is intact.
+// It returns the number of records.
+func Verify(r io.Reader) (int, error) {
+	br := bufio.NewReader(r)
+	count := 0
+	sizeBuf := make([]byte, 8)
+	for {
+		_, err := io.ReadFull(br, sizeBuf)
+		if err == io.EOF {
+			return count, nil
+		}
+		if err != nil {
+			return count, fmt.Errorf("%w: read size error, %v", ErrCorrupt, err)
+		}
+		// the size is not trusted, a corrupt header must not allocate huge buffers
+		size := binary.LittleEndian.Uint64(sizeBuf)
+		var data bytes.Buffer
+		if _, err := io.CopyN(&data, br, int64(size)); err != nil {
+			return count, fmt.Errorf("%w: key readData error, %v", ErrCorrupt, err)
+		}
+		if err := proto.Unmarshal(data.Bytes(), &pb.Entity{}); err != nil {
+			return count, fmt.Errorf("%w: proto unmarshal error %v", ErrCorrupt, err)
+		}
+		count++
+	}
+}
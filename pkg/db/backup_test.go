@@ -0,0 +1,111 @@
+package db
+
+import (
+	"bytes"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/gerlacdt/db-key-value-store/pb"
+	"github.com/mattetti/filebuffer"
+)
+
+func TestBackupRestore(t *testing.T) {
+	db := setup(t)
+	maxItems := 100
+	for i := 0; i < maxItems; i++ {
+		key := "foo-key-" + strconv.Itoa(i)
+		err := db.Set(&pb.Entity{Key: key, Value: []byte("foo-value-" + strconv.Itoa(i))})
+		if err != nil {
+			t.Fatalf("error SET %v", err)
+		}
+	}
+
+	// keep writing while the backup is taken
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < maxItems; i++ {
+			err := db.Set(&pb.Entity{Key: "bar-key-" + strconv.Itoa(i), Value: []byte("bar-value")})
+			if err != nil {
+				t.Errorf("error SET %v", err)
+			}
+		}
+	}()
+	var backup bytes.Buffer
+	n, err := db.Backup(&backup)
+	wg.Wait()
+	if err != nil {
+		t.Fatalf("error backup %v", err)
+	}
+	if n != int64(backup.Len()) {
+		t.Fatalf("backup size expected %d, got %d", backup.Len(), n)
+	}
+
+	restored, err := Restore(filebuffer.New(nil), &backup)
+	if err != nil {
+		t.Fatalf("error restore %v", err)
+	}
+	for i := 0; i < maxItems; i++ {
+		key := "foo-key-" + strconv.Itoa(i)
+		entity, err := restored.Get(key)
+		if err != nil {
+			t.Fatalf("error getting entity %v", err)
+		}
+		expectedValue := "foo-value-" + strconv.Itoa(i)
+		if entity == nil || string(entity.Value) != expectedValue {
+			t.Fatalf("value expected %v, got %v", expectedValue, entity)
+		}
+	}
+}
+
+func TestBackupAfterFailedWrite(t *testing.T) {
+	t.Parallel()
+	fs := NewFaultFS(NewMemFS())
+	db, err := Open(fs, "db")
+	if err != nil {
+		t.Fatalf("error opening %v", err)
+	}
+	if err := db.Set(&pb.Entity{Key: "foo-key", Value: []byte("foo-value")}); err != nil {
+		t.Fatalf("error SET %v", err)
+	}
+	// the write is torn after a few bytes
+	fs.FailAfter(10)
+	if err := db.Set(&pb.Entity{Key: "bar-key", Value: []byte("bar-value")}); err == nil {
+		t.Fatalf("expected error of torn write")
+	}
+
+	var backup bytes.Buffer
+	if _, err := db.Backup(&backup); err != nil {
+		t.Fatalf("error backup %v", err)
+	}
+	restored, err := Restore(filebuffer.New(nil), &backup)
+	if err != nil {
+		t.Fatalf("error restore %v", err)
+	}
+	if entity, err := restored.Get("foo-key"); err != nil || entity == nil || string(entity.Value) != "foo-value" {
+		t.Fatalf("expected foo-value, got %v %v", entity, err)
+	}
+	if entity, err := restored.Get("bar-key"); err != nil || entity != nil {
+		t.Fatalf("expected missing bar-key, got %v %v", entity, err)
+	}
+}
+
+func TestRestoreTruncatedBackup(t *testing.T) {
+	db := setup(t)
+	err := db.Set(&pb.Entity{Key: "foo-key", Value: []byte("foo-value")})
+	if err != nil {
+		t.Fatalf("error SET %v", err)
+	}
+	var backup bytes.Buffer
+	if _, err := db.Backup(&backup); err != nil {
+		t.Fatalf("error backup %v", err)
+	}
+
+	truncated := backup.Bytes()[:backup.Len()-1]
+	_, err = Restore(filebuffer.New(nil), bytes.NewReader(truncated))
+	if err == nil {
+		t.Fatalf("expected error restoring truncated backup")
+	}
+}
@@ -0,0 +1,179 @@
+package db
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/gerlacdt/db-key-value-store/pb"
+)
+
+// crashOp is a write of the crash workload, a delete if value is empty.
+type crashOp struct {
+	key   string
+	value string
+}
+
+var crashWorkload = func() []crashOp {
+	var ops []crashOp
+	for i := 0; i < 30; i++ {
+		key := "key-" + strconv.Itoa(i%7)
+		if i%5 == 4 {
+			ops = append(ops, crashOp{key: key})
+			continue
+		}
+		ops = append(ops, crashOp{key, "value-" + strconv.Itoa(i)})
+	}
+	return ops
+}()
+
+func (op crashOp) apply(db *DB) error {
+	if op.value == "" {
+		return db.Delete(op.key)
+	}
+	return db.Set(&pb.Entity{Key: op.key, Value: []byte(op.value)})
+}
+
+// runCrashWorkload runs the workload until the first failed write and returns
+// the acknowledged state.
+func runCrashWorkload(db *DB) map[string]string {
+	acked := make(map[string]string)
+	for _, op := range crashWorkload {
+		if err := op.apply(db); err != nil && err != ErrNotFound {
+			break
+		}
+		if op.value == "" {
+			delete(acked, op.key)
+		} else {
+			acked[op.key] = op.value
+		}
+	}
+	return acked
+}
+
+func assertState(t *testing.T, db *DB, expected map[string]string) {
+	t.Helper()
+	for i := 0; i < 7; i++ {
+		key := "key-" + strconv.Itoa(i)
+		entity, err := db.Get(key)
+		if err != nil {
+			t.Fatalf("error getting %s %v", key, err)
+		}
+		value, ok := expected[key]
+		if !ok {
+			if entity != nil {
+				t.Fatalf("%s expected to not exist, got %s", key, entity.Value)
+			}
+			continue
+		}
+		if entity == nil || string(entity.Value) != value {
+			t.Fatalf("%s expected %s, got %v", key, value, entity)
+		}
+	}
+	if db.Stats().Keys != len(expected) {
+		t.Fatalf("keys expected %d, got %d", len(expected), db.Stats().Keys)
+	}
+}
+
+func TestCrashAfterEveryWritePrefix(t *testing.T) {
+	t.Parallel()
+	fs := NewFaultFS(NewMemFS())
+	db, err := Open(fs, "db")
+	if err != nil {
+		t.Fatalf("error opening %v", err)
+	}
+	runCrashWorkload(db)
+	total := fs.Written()
+
+	for n := int64(0); n <= total; n++ {
+		mem := NewMemFS()
+		fs := NewFaultFS(mem)
+		fs.FailAfter(n)
+		db, err := Open(fs, "db")
+		if err != nil {
+			t.Fatalf("error opening %v", err)
+		}
+		acked := runCrashWorkload(db)
+
+		// the process is killed, the failed write is torn and must be gone
+		recovered, err := Open(mem, "db")
+		if err != nil {
+			t.Fatalf("crash after %d bytes: error recovering %v", n, err)
+		}
+		assertState(t, recovered, acked)
+
+		// the torn tail is truncated, so new writes survive the next recovery
+		if err := recovered.Set(&pb.Entity{Key: "key-0", Value: []byte("after-crash")}); err != nil {
+			t.Fatalf("crash after %d bytes: error SET %v", n, err)
+		}
+		recovered.Close()
+		acked["key-0"] = "after-crash"
+		recovered, err = Open(mem, "db")
+		if err != nil {
+			t.Fatalf("crash after %d bytes: error recovering %v", n, err)
+		}
+		assertState(t, recovered, acked)
+		recovered.Close()
+	}
+}
+
+func TestCrashPowerLoss(t *testing.T) {
+	t.Parallel()
+	mem := NewMemFS()
+	db, err := Open(mem, "db")
+	if err != nil {
+		t.Fatalf("error opening %v", err)
+	}
+	db.SetSyncWrites(true)
+	acked := runCrashWorkload(db)
+
+	mem.Crash()
+	recovered, err := Open(mem, "db")
+	if err != nil {
+		t.Fatalf("error recovering %v", err)
+	}
+	assertState(t, recovered, acked)
+
+	// without syncs the writes are lost
+	recovered.SetSyncWrites(false)
+	if err := recovered.Set(&pb.Entity{Key: "key-0", Value: []byte("not-synced")}); err != nil {
+		t.Fatalf("error SET %v", err)
+	}
+	mem.Crash()
+	if err := recovered.Recover(); err != nil {
+		t.Fatalf("error recovering %v", err)
+	}
+	assertState(t, recovered, acked)
+}
+
+func TestCrashFailedSync(t *testing.T) {
+	t.Parallel()
+	mem := NewMemFS()
+	fs := NewFaultFS(mem)
+	db, err := Open(fs, "db")
+	if err != nil {
+		t.Fatalf("error opening %v", err)
+	}
+	db.SetSyncWrites(true)
+	if err := db.Set(&pb.Entity{Key: "key-0", Value: []byte("synced")}); err != nil {
+		t.Fatalf("error SET %v", err)
+	}
+
+	fs.FailSyncs(true)
+	if err := db.Set(&pb.Entity{Key: "key-1", Value: []byte("not-synced")}); err == nil {
+		t.Fatalf("expected error for failed sync")
+	}
+	fs.FailSyncs(false)
+	// the state of the log is unknown until it is recovered
+	if err := db.Set(&pb.Entity{Key: "key-2", Value: []byte("value")}); err == nil {
+		t.Fatalf("expected error for write after failed sync")
+	}
+
+	mem.Crash()
+	if err := db.Recover(); err != nil {
+		t.Fatalf("error recovering %v", err)
+	}
+	assertState(t, db, map[string]string{"key-0": "synced"})
+	if err := db.Set(&pb.Entity{Key: "key-2", Value: []byte("value")}); err != nil {
+		t.Fatalf("error SET after recovery %v", err)
+	}
+}
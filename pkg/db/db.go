@@ -2,30 +2,151 @@ package db
 
 import (
 	"bytes"
+	"crypto/sha256"
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"io"
 	"log"
+	"os"
+	"sort"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/gerlacdt/db-key-value-store/pb"
+	"github.com/gerlacdt/db-key-value-store/pkg/metrics"
 	"github.com/golang/protobuf/proto"
 )
 
 // DB type
 type DB struct {
-	lock    sync.RWMutex
-	f       io.ReadWriteSeeker
-	offsets map[string]int64
+	lock     sync.RWMutex
+	f        io.ReadWriteSeeker // active segment
+	file     File               // closed by Close if the database was opened with Open
+	index    map[string]entry
+	size     int64  // end of the log
+	seq      uint64 // sequence number of the last committed record
+	counters counters
+	closed   bool
+	sync     bool  // sync the log after every commit
+	err      error // failed write, later writes fail until Recover
+	subs     map[*Subscription]struct{}
+	watchers map[*watcher]struct{}
+	indexes  map[string]*secondaryIndex
+	quotas   []*quotaState
+	limits   Limits
+
+	recoverDuration time.Duration
+	recoveredAt     time.Time
+	compactions     uint64
+	syncLatency     *metrics.Histogram
+
+	// segments, only supported if the database was opened with Open
+	fs         FS
+	name       string
+	segments   []*segment // sealed segments in log order
+	base       int64      // offset of the active segment in the log
+	activeID   int
+	nextID     int
+	minSeq     uint64 // sequence number of the manifest, Recover never goes below it
+	compacted  Point  // the history up to the point was dropped by a compaction
+	readOnly   bool   // maintenance mode
+	readOnlyFS bool   // opened by OpenReadOnly, the files are never written
+	compaction compactionState
+}
+
+// entry locates the latest record of a key in the log. The offset is the
+// position in the log, which spans all segments.
+type entry struct {
+	offset    int64
+	size      int64 // length of the record on disk
+	tombstone bool
+	seq       uint64 // sequence number of the record
 }
 
 // New return a new intialized DB.
 func New(f io.ReadWriteSeeker) *DB {
-	offsetMap := make(map[string]int64)
-	db := &DB{f: f, offsets: offsetMap}
+	db := &DB{
+		f:        f,
+		index:    make(map[string]entry),
+		subs:     make(map[*Subscription]struct{}),
+		watchers: make(map[*watcher]struct{}),
+		indexes:  make(map[string]*secondaryIndex),
+
+		syncLatency: metrics.NewHistogram(metrics.DefBuckets),
+	}
 	return db
 }
 
+// Open opens the named log of fs, which is created if it does not exist, and
+// recovers the database. The log consists of the segments listed in the
+// manifest, see Seal. Close closes the log.
+func Open(fs FS, name string) (*DB, error) {
+	return open(fs, name, false)
+}
+
+// OpenReadOnly opens the named log of fs for reading, e.g. of a database in
+// use by a server. No files are created or written, a torn record at the end
+// of the log is ignored instead of truncated and all writes fail with
+// ErrReadOnly.
+func OpenReadOnly(fs FS, name string) (*DB, error) {
+	return open(fs, name, true)
+}
+
+func open(fs FS, name string, readOnly bool) (*DB, error) {
+	m, err := readManifest(fs, name)
+	if err != nil {
+		return nil, err
+	}
+	ids := m.Segments
+	db := New(nil)
+	db.fs, db.name, db.minSeq = fs, name, m.Seq
+	db.compacted.Seq = m.CompactedSeq
+	if m.CompactedAt != 0 {
+		db.compacted.Time = time.Unix(0, m.CompactedAt)
+	}
+	db.readOnlyFS = readOnly
+	for _, id := range ids[:len(ids)-1] {
+		f, err := fs.OpenFile(segmentName(name, id), os.O_RDONLY, 0)
+		if err != nil {
+			db.closeSegments()
+			return nil, fmt.Errorf("could not open segment: %v", err)
+		}
+		db.segments = append(db.segments, &segment{id: id, f: f})
+	}
+	db.activeID = ids[len(ids)-1]
+	for _, id := range ids {
+		if id >= db.nextID {
+			db.nextID = id + 1
+		}
+	}
+	flag := os.O_RDWR | os.O_CREATE
+	if readOnly {
+		flag = os.O_RDONLY
+	}
+	f, err := fs.OpenFile(segmentName(name, db.activeID), flag, 0644)
+	if err != nil {
+		db.closeSegments()
+		return nil, fmt.Errorf("could not open file %s: %v", segmentName(name, db.activeID), err)
+	}
+	db.f, db.file = f, f
+	if err := db.Recover(); err != nil {
+		db.closeSegments()
+		f.Close()
+		return nil, fmt.Errorf("could not recover database %s: %v", name, err)
+	}
+	return db, nil
+}
+
+// SetSyncWrites syncs the log after every commit if the file supports it, so
+// acknowledged writes survive a power loss.
+func (db *DB) SetSyncWrites(sync bool) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+	db.sync = sync
+}
+
 func writeBinaryBufferLength(data []byte) *bytes.Buffer {
 	var length = uint64(len(data))
 	buf := new(bytes.Buffer)
@@ -36,55 +157,189 @@ func writeBinaryBufferLength(data []byte) *bytes.Buffer {
 	return buf
 }
 
-func (db *DB) pbAppend(entity *pb.Entity) (int64, error) {
+// pbAppend writes the entity at the end of the log and returns the offset
+// and the length of the record.
+func (db *DB) pbAppend(entity *pb.Entity) (entry, error) {
 	entityBytes, err := proto.Marshal(entity)
 	if err != nil {
-		return 0, fmt.Errorf("pb marshall error %v", err)
+		return entry{}, fmt.Errorf("pb marshall error %v", err)
 	}
 	byteBuffer := writeBinaryBufferLength(entityBytes)
 	offset, err := db.f.Seek(0, 2)
 	if err != nil {
-		return 0, fmt.Errorf("file seek error %v", err)
+		return entry{}, fmt.Errorf("file seek error %v", err)
 	}
 	_, err = byteBuffer.Write(entityBytes)
 	if err != nil {
-		return 0, fmt.Errorf("error writing byte buffer %v", err)
+		return entry{}, fmt.Errorf("error writing byte buffer %v", err)
 	}
 	_, err = db.f.Write(byteBuffer.Bytes())
 	if err != nil {
-		return 0, fmt.Errorf("entity size file write error %v", err)
+		// a partial record may be left at the end of the log
+		db.err = fmt.Errorf("entity file write error %w", err)
+		return entry{}, db.err
 	}
-	if err != nil {
-		return 0, fmt.Errorf("entity data file write error %v", err)
+	if db.sync {
+		if s, ok := db.f.(interface{ Sync() error }); ok {
+			start := time.Now()
+			err := s.Sync()
+			db.syncLatency.Observe(time.Since(start).Seconds())
+			if err != nil {
+				db.err = fmt.Errorf("file sync error %w", err)
+				return entry{}, db.err
+			}
+		}
 	}
-	return offset, nil
+	return entry{offset: db.base + offset, size: int64(byteBuffer.Len()), tombstone: entity.Tombstone, seq: entity.Seq}, nil
 }
 
-// Set / stores a key-value pair in the database
-func (db *DB) Set(entity *pb.Entity) error {
+// MaxRecordSize is the maximum size of a marshaled entity.
+const MaxRecordSize = 64 << 20
+
+var (
+	// ErrClosed is returned by operations on a closed database.
+	ErrClosed = errors.New("database closed")
+	// ErrNotFound is returned when deleting a key which does not exist.
+	ErrNotFound = errors.New("key does not exist")
+	// ErrCorrupt is returned for records which can not be read.
+	ErrCorrupt = errors.New("corrupt record")
+	// ErrReadOnly is returned for writes to a database which does not accept
+	// writes.
+	ErrReadOnly = errors.New("database is read-only")
+	// ErrTooLarge is returned for entities larger than MaxRecordSize.
+	ErrTooLarge = errors.New("entity too large")
+)
+
+// Close ends all subscriptions and watches, later operations fail with
+// ErrClosed. The underlying file is only closed if it was opened by Open.
+func (db *DB) Close() error {
 	db.lock.Lock()
 	defer db.lock.Unlock()
 
-	offset, err := db.pbAppend(entity)
+	if db.closed {
+		return nil
+	}
+	db.closed = true
+	for s := range db.subs {
+		db.unsubscribe(s, ErrClosed)
+	}
+	for w := range db.watchers {
+		close(w.ch)
+		delete(db.watchers, w)
+	}
+	db.closeSegments()
+	if db.file != nil {
+		return db.file.Close()
+	}
+	return nil
+}
+
+// Hash returns the hash of a value, which is stored with the value by every
+// commit. Records written before hashes were stored have no hash.
+func Hash(value []byte) []byte {
+	sum := sha256.Sum256(value)
+	return sum[:16]
+}
+
+// commit appends the entity with the next sequence number, the commit
+// timestamp and the hash of the value and updates the index. The caller must
+// hold the write lock.
+func (db *DB) commit(entity *pb.Entity) error {
+	if db.closed {
+		return ErrClosed
+	}
+	if db.err != nil {
+		return fmt.Errorf("%w, recover required after a failed write: %v", ErrReadOnly, db.err)
+	}
+	if db.readOnly {
+		return fmt.Errorf("%w, maintenance mode", ErrReadOnly)
+	}
+	if db.readOnlyFS {
+		return fmt.Errorf("%w, opened read-only", ErrReadOnly)
+	}
+	// the entity of the caller is only updated by a successful commit
+	record := *entity
+	if !record.Tombstone {
+		record.Hash = Hash(record.Value)
+	}
+	if size := proto.Size(&record); size > MaxRecordSize {
+		return fmt.Errorf("%w: %d bytes > %d", ErrTooLarge, size, MaxRecordSize)
+	}
+	record.Seq = db.seq + 1
+	record.Timestamp = time.Now().UnixNano()
+	if err := db.checkQuotas(&record); err != nil {
+		return err
+	}
+	e, err := db.pbAppend(&record)
 	if err != nil {
 		return err
 	}
-	db.offsets[entity.Key] = offset
+	entity.Seq, entity.Timestamp, entity.Hash = record.Seq, record.Timestamp, record.Hash
+	db.seq = record.Seq
+	db.size = e.offset + e.size
+	db.setEntry(record.Key, e)
+	db.updateIndexes(&record)
+	if len(db.subs) > 0 || len(db.watchers) > 0 {
+		// the caller still owns the value and metadata of the committed entity
+		committed := proto.Clone(&record).(*pb.Entity)
+		db.publish(committed)
+		db.notify(committed)
+	}
 	return nil
 }
 
-// Delete an entry for given key from database
-func (db *DB) Delete(key string) error {
+// Set / stores a key-value pair in the database. Seq and Timestamp of the
+// entity are set to the sequence number and time of the commit.
+func (db *DB) Set(entity *pb.Entity) error {
 	db.lock.Lock()
 	defer db.lock.Unlock()
 
-	entity := &pb.Entity{Tombstone: true, Key: key}
-	offset, err := db.pbAppend(entity)
-	if err != nil {
+	if err := db.limits.check(entity); err != nil {
 		return err
 	}
-	db.offsets[key] = offset
-	return nil
+	return db.commit(entity)
+}
+
+// Delete an entry for given key from database, ErrNotFound is returned if the
+// key does not exist.
+func (db *DB) Delete(key string) error {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	if e, ok := db.index[key]; !ok || e.tombstone {
+		if db.closed {
+			return ErrClosed
+		}
+		return ErrNotFound
+	}
+	return db.commit(&pb.Entity{Tombstone: true, Key: key})
+}
+
+// Seq returns the sequence number of the last committed write.
+func (db *DB) Seq() uint64 {
+	db.lock.RLock()
+	defer db.lock.RUnlock()
+
+	return db.seq
+}
+
+// Keys returns up to limit live keys with the prefix in sorted order, all of
+// them if limit is not positive.
+func (db *DB) Keys(prefix string, limit int) []string {
+	db.lock.RLock()
+	keys := []string{}
+	for k, e := range db.index {
+		if !e.tombstone && strings.HasPrefix(k, prefix) && !isLease(k) {
+			keys = append(keys, k)
+		}
+	}
+	db.lock.RUnlock()
+
+	sort.Strings(keys)
+	if limit > 0 && len(keys) > limit {
+		keys = keys[:limit]
+	}
+	return keys
 }
 
 // Get a key-value pair from the database
@@ -92,21 +347,16 @@ func (db *DB) Get(key string) (*pb.Entity, error) {
 	db.lock.RLock()
 	defer db.lock.RUnlock()
 
-	offset, ok := db.offsets[key]
-	if !ok {
-		return nil, nil
-	}
-	_, err := db.f.Seek(offset, 0)
-	if err != nil {
-		return nil, fmt.Errorf("file seek error %v", err)
+	if db.closed {
+		return nil, ErrClosed
 	}
-	size, err := db.readSize()
-	if err != nil {
-		return nil, fmt.Errorf("read size error, %v", err)
+	e, ok := db.index[key]
+	if !ok || e.tombstone {
+		return nil, nil
 	}
-	entity, err := db.readPbData(size)
+	entity, err := db.readAt(e.offset)
 	if err != nil {
-		return nil, fmt.Errorf("key readData error, %v", err)
+		return nil, err
 	}
 	if entity.Tombstone {
 		return nil, nil
@@ -114,10 +364,66 @@ func (db *DB) Get(key string) (*pb.Entity, error) {
 	return entity, nil
 }
 
-func (db *DB) readSize() (uint64, error) {
+// readAt reads the entity stored at the given offset.
+func (db *DB) readAt(offset int64) (*pb.Entity, error) {
+	entity, _, err := db.readRecord(offset)
+	return entity, err
+}
+
+// readRecord reads the entity stored at the given offset and returns it with
+// the length of the record on disk. Concurrent readers only hold the read
+// lock, so the shared file position must not be used if the file supports
+// positional reads.
+func (db *DB) readRecord(offset int64) (*pb.Entity, int64, error) {
+	if offset < db.base {
+		s := db.segmentAt(offset)
+		return readRecordAt(s.f, offset-s.base)
+	}
+	ra, ok := db.f.(io.ReaderAt)
+	if !ok {
+		_, err := db.f.Seek(offset-db.base, 0)
+		if err != nil {
+			return nil, 0, fmt.Errorf("file seek error %v", err)
+		}
+		size, err := readSize(db.f)
+		if err != nil {
+			return nil, 0, fmt.Errorf("read size error, %v", err)
+		}
+		entity, err := readPbData(db.f, size)
+		if err != nil {
+			return nil, 0, fmt.Errorf("key readData error, %v", err)
+		}
+		return entity, int64(size) + 8, nil
+	}
+	return readRecordAt(ra, offset-db.base)
+}
+
+// readRecordAt reads the record at the offset of a segment.
+func readRecordAt(ra io.ReaderAt, offset int64) (*pb.Entity, int64, error) {
+	sizeBuf := make([]byte, 8)
+	if n, err := ra.ReadAt(sizeBuf, offset); err != nil && !(err == io.EOF && n == len(sizeBuf)) {
+		return nil, 0, fmt.Errorf("read size error, %v", err)
+	}
+	size := binary.LittleEndian.Uint64(sizeBuf)
+	if size > MaxRecordSize {
+		return nil, 0, fmt.Errorf("%w: record size %d at offset %d", ErrCorrupt, size, offset)
+	}
+	dataBuf := make([]byte, size)
+	n, err := ra.ReadAt(dataBuf, offset+8)
+	if err != nil && !(err == io.EOF && uint64(n) == size) {
+		return nil, 0, fmt.Errorf("key readData error, %v", err)
+	}
+	entity := &pb.Entity{}
+	if err := proto.Unmarshal(dataBuf, entity); err != nil {
+		return nil, 0, fmt.Errorf("%w: proto unmarshal error %v", ErrCorrupt, err)
+	}
+	return entity, int64(size) + 8, nil
+}
+
+func readSize(r io.Reader) (uint64, error) {
 	intsize := 8
 	byteBuffer := make([]byte, intsize)
-	_, err := db.f.Read(byteBuffer)
+	_, err := io.ReadFull(r, byteBuffer)
 	if err != nil {
 		return 0, err
 	}
@@ -130,42 +436,114 @@ func (db *DB) readSize() (uint64, error) {
 	return readSize, nil
 }
 
-// Recover from a crash and populate in-memory hashmap from existing file
+// Recover from a crash and populate in-memory hashmap from existing file. A
+// torn record at the end of the log, left by a crash in the middle of a write,
+// is truncated. Sealed segments are complete, a torn record in them is
+// reported as ErrCorrupt.
 func (db *DB) Recover() error {
 	db.lock.Lock()
 	defer db.lock.Unlock()
 
+	start := time.Now()
+	defer func() { db.recoverDuration, db.recoveredAt = time.Since(start), start }()
+
+	// the index and secondary indexes are rebuilt from scratch
+	db.index = make(map[string]entry)
+	db.counters = counters{}
+	for _, qs := range db.quotas {
+		qs.Keys, qs.LiveBytes = 0, 0
+	}
+	for name, idx := range db.indexes {
+		db.indexes[name], _ = newSecondaryIndex(idx.spec)
+	}
+	// the records with the highest sequence numbers may have been compacted
+	if db.minSeq > db.seq {
+		db.seq = db.minSeq
+	}
+	base := int64(0)
+	for _, s := range db.segments {
+		offset, end, err := db.scan(s.f, base)
+		if err != nil {
+			return fmt.Errorf("segment %s: %w", segmentName(db.name, s.id), err)
+		}
+		if offset < end {
+			return fmt.Errorf("%w: torn record at offset %d of sealed segment %s", ErrCorrupt, offset, segmentName(db.name, s.id))
+		}
+		s.base, s.size = base, end
+		base += end
+	}
+
+	offset, end, err := db.scan(db.f, base)
+	if err != nil {
+		return err
+	}
+	if offset < end && db.readOnlyFS {
+		// the record may still be written by the owner of the log
+		log.Printf("ignoring torn record at offset %d (%d bytes)", offset, end-offset)
+	} else if offset < end {
+		t, ok := db.f.(interface{ Truncate(int64) error })
+		if !ok {
+			return fmt.Errorf("torn record at offset %d, file can not be truncated", offset)
+		}
+		log.Printf("truncating torn record at offset %d (%d bytes)", offset, end-offset)
+		if err := t.Truncate(offset); err != nil {
+			return fmt.Errorf("file truncate error %v", err)
+		}
+	}
+	db.base = base
+	db.size = base + offset
+	db.err = nil
+	return nil
+}
+
+// scan populates the index from the records of a segment which starts at base
+// in the log. It returns the end of the last complete record and the end of
+// the file. The caller must hold the write lock.
+func (db *DB) scan(r io.ReadSeeker, base int64) (int64, int64, error) {
+	end, err := r.Seek(0, io.SeekEnd)
+	if err != nil {
+		return 0, 0, fmt.Errorf("file seek error %v", err)
+	}
 	// start reading file at beginning
 	offset := int64(0)
-	_, err := db.f.Seek(offset, 0)
+	_, err = r.Seek(offset, io.SeekStart)
 	if err != nil {
-		return fmt.Errorf("file seek error %v", err)
+		return 0, 0, fmt.Errorf("file seek error %v", err)
 	}
 	// run through all key-value pairs and populate in-memory hashmap
-	for {
-		size, err := db.readSize()
-		if err != nil && err == io.EOF {
+	for offset < end {
+		if end-offset < 8 {
 			break
 		}
+		size, err := readSize(r)
 		if err != nil {
-			return fmt.Errorf("read size error, %v", err)
+			return 0, 0, fmt.Errorf("read size error, %v", err)
 		}
-		entity, err := db.readPbData(size)
-		if err != nil && err == io.EOF {
+		if size > MaxRecordSize {
+			// a torn record has a complete and valid size
+			return 0, 0, fmt.Errorf("%w: record size %d at offset %d", ErrCorrupt, size, offset)
+		}
+		if size > uint64(end-offset-8) {
 			break
 		}
+		entity, err := readPbData(r, size)
 		if err != nil {
-			return fmt.Errorf("key readData error, %v", err)
+			return 0, 0, fmt.Errorf("key readData error at offset %d, %w", offset, err)
+		}
+		e := entry{offset: base + offset, size: int64(size) + int64(8), tombstone: entity.Tombstone, seq: entity.Seq}
+		db.setEntry(entity.Key, e)
+		db.updateIndexes(entity)
+		if entity.Seq > db.seq {
+			db.seq = entity.Seq
 		}
-		db.offsets[entity.Key] = offset
-		offset += int64(size) + int64(8) // calculate next offset
+		offset += e.size // calculate next offset
 	}
-	return nil
+	return offset, end, nil
 }
 
-func (db *DB) readPbData(lengthOf uint64) (*pb.Entity, error) {
+func readPbData(r io.Reader, lengthOf uint64) (*pb.Entity, error) {
 	dataBuf := make([]byte, lengthOf)
-	_, err := db.f.Read(dataBuf)
+	_, err := io.ReadFull(r, dataBuf)
 	if err != nil {
 		return nil, err
 	}
@@ -174,7 +552,7 @@ func (db *DB) readPbData(lengthOf uint64) (*pb.Entity, error) {
 	err = proto.Unmarshal(dataBuf, entity)
 
 	if err != nil {
-		return nil, fmt.Errorf("proto unmarshal error %v", err)
+		return nil, fmt.Errorf("%w: proto unmarshal error %v", ErrCorrupt, err)
 	}
 	return entity, nil
 }
@@ -1,6 +1,8 @@
 package db
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
 	"reflect"
 	"strconv"
@@ -90,7 +92,7 @@ func TestSingleRecover(t *testing.T) {
 	}
 
 	// clear map
-	db.offsets = make(map[string]int64)
+	db.index = make(map[string]entry)
 
 	err = db.Recover()
 	if err != nil {
@@ -123,7 +125,7 @@ func TestSingleRecoverWithDelete(t *testing.T) {
 	}
 
 	// clear map
-	db.offsets = make(map[string]int64)
+	db.index = make(map[string]entry)
 
 	err = db.Recover()
 	if err != nil {
@@ -168,7 +170,7 @@ func TestMultipleRecover(t *testing.T) {
 
 	// act
 	// clear map
-	db.offsets = make(map[string]int64)
+	db.index = make(map[string]entry)
 	err = db.Recover()
 	if err != nil {
 		t.Fatalf("error recovering %v", err)
@@ -229,7 +231,7 @@ func TestConcurrentSets(t *testing.T) {
 	wg.Wait() // wait for all goroutines to finish
 
 	// check if all key-values are inserted correctly
-	mapLen := len(db.offsets)
+	mapLen := len(db.index)
 	if maxItems != mapLen {
 		t.Fatalf("mapLen: expected %d, got %d", maxItems, mapLen)
 	}
@@ -246,3 +248,64 @@ func TestConcurrentSets(t *testing.T) {
 		}
 	}
 }
+
+func TestKeys(t *testing.T) {
+	db := setup(t)
+	for _, key := range []string{"user-2", "user-1", "order-1", "user-3"} {
+		if err := db.Set(&pb.Entity{Key: key, Value: []byte("value")}); err != nil {
+			t.Fatalf("error SET %v", err)
+		}
+	}
+	if err := db.Delete("user-3"); err != nil {
+		t.Fatalf("error DELETE %v", err)
+	}
+
+	keys := db.Keys("user-", 0)
+	if !reflect.DeepEqual([]string{"user-1", "user-2"}, keys) {
+		t.Fatalf("keys expected %v, got %v", []string{"user-1", "user-2"}, keys)
+	}
+	keys = db.Keys("", 2)
+	if !reflect.DeepEqual([]string{"order-1", "user-1"}, keys) {
+		t.Fatalf("keys expected %v, got %v", []string{"order-1", "user-1"}, keys)
+	}
+}
+
+func TestErrors(t *testing.T) {
+	db := setup(t)
+	if err := db.Delete("foo-key"); err != ErrNotFound {
+		t.Fatalf("expected %v, got %v", ErrNotFound, err)
+	}
+	err := db.Set(&pb.Entity{Key: "foo-key", Value: make([]byte, MaxRecordSize)})
+	if !errors.Is(err, ErrTooLarge) {
+		t.Fatalf("expected %v, got %v", ErrTooLarge, err)
+	}
+
+	// a record with garbage data in the middle of the log
+	f := filebuffer.New(nil)
+	corrupt := New(f)
+	for _, key := range []string{"foo-key", "bar-key"} {
+		if err := corrupt.Set(&pb.Entity{Key: key, Value: []byte("value")}); err != nil {
+			t.Fatalf("error SET %v", err)
+		}
+	}
+	f.Buff.Bytes()[8] = 0xff
+	if err := New(f).Recover(); !errors.Is(err, ErrCorrupt) {
+		t.Fatalf("expected %v, got %v", ErrCorrupt, err)
+	}
+	if _, err := Verify(bytes.NewReader(f.Buff.Bytes())); !errors.Is(err, ErrCorrupt) {
+		t.Fatalf("expected %v, got %v", ErrCorrupt, err)
+	}
+
+	fs := NewFaultFS(NewMemFS())
+	failed, err := Open(fs, "db")
+	if err != nil {
+		t.Fatalf("error opening %v", err)
+	}
+	fs.FailAfter(0)
+	if err := failed.Set(&pb.Entity{Key: "foo-key"}); !errors.Is(err, ErrInjected) {
+		t.Fatalf("expected %v, got %v", ErrInjected, err)
+	}
+	if err := failed.Set(&pb.Entity{Key: "foo-key"}); !errors.Is(err, ErrReadOnly) {
+		t.Fatalf("expected %v, got %v", ErrReadOnly, err)
+	}
+}
@@ -0,0 +1,212 @@
+package db
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+
+	"github.com/gerlacdt/db-key-value-store/pb"
+	"github.com/golang/protobuf/proto"
+)
+
+// Format of a logical export.
+type Format int
+
+const (
+	// JSONLines writes one JSON object per line with a base64 encoded value.
+	JSONLines Format = iota
+	// Protobuf writes a stream of varint length-delimited pb.Entity messages.
+	Protobuf
+)
+
+// ParseFormat returns the Format for the given name, "jsonl" or "pb".
+func ParseFormat(s string) (Format, error) {
+	switch s {
+	case "", "jsonl":
+		return JSONLines, nil
+	case "pb":
+		return Protobuf, nil
+	default:
+		return 0, fmt.Errorf("unknown export format %q, jsonl and pb are supported", s)
+	}
+}
+
+// String returns the name of the format.
+func (f Format) String() string {
+	if f == Protobuf {
+		return "pb"
+	}
+	return "jsonl"
+}
+
+// Record is the JSON Lines representation of an entity. Seq and Timestamp
+// describe the original commit, imported entities are committed anew.
+type Record struct {
+	Key       string    `json:"key"`
+	Value     []byte    `json:"value"`
+	Size      int       `json:"size"`
+	Seq       uint64    `json:"seq"`
+	Timestamp time.Time `json:"timestamp"`
+	Tombstone bool      `json:"tombstone,omitempty"`
+
+	ContentType  string            `json:"contentType,omitempty"`
+	CacheControl string            `json:"cacheControl,omitempty"`
+	Metadata     map[string]string `json:"metadata,omitempty"`
+}
+
+// NewRecord returns the JSON Lines representation of the entity.
+func NewRecord(entity *pb.Entity) Record {
+	return Record{
+		Key:       entity.Key,
+		Value:     entity.Value,
+		Size:      len(entity.Value),
+		Seq:       entity.Seq,
+		Timestamp: time.Unix(0, entity.Timestamp).UTC(),
+		Tombstone: entity.Tombstone,
+
+		ContentType:  entity.ContentType,
+		CacheControl: entity.CacheControl,
+		Metadata:     entity.Metadata,
+	}
+}
+
+// ForEach calls fn for every live entity in key order. The keyspace is
+// captured when ForEach starts, writes made during the iteration are not seen
+// unless the log is compacted during the iteration.
+func (db *DB) ForEach(fn func(*pb.Entity) error) error {
+	db.lock.RLock()
+	compactions := db.compactions
+	keys := make([]string, 0, len(db.index))
+	offsets := make(map[string]int64, len(db.index))
+	for k, e := range db.index {
+		if e.tombstone || isLease(k) {
+			continue
+		}
+		keys = append(keys, k)
+		offsets[k] = e.offset
+	}
+	db.lock.RUnlock()
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		db.lock.RLock()
+		offset := offsets[k]
+		if db.compactions != compactions {
+			// the captured offsets moved
+			e, ok := db.index[k]
+			if !ok || e.tombstone {
+				db.lock.RUnlock()
+				continue
+			}
+			offset = e.offset
+		}
+		entity, err := db.readAt(offset)
+		db.lock.RUnlock()
+		if err != nil {
+			return err
+		}
+		if err := fn(entity); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Export writes the live keyspace to w in the given format.
+func (db *DB) Export(w io.Writer, format Format) error {
+	bw := bufio.NewWriter(w)
+	enc := json.NewEncoder(bw)
+	err := db.ForEach(func(entity *pb.Entity) error {
+		if format == JSONLines {
+			if err := enc.Encode(NewRecord(entity)); err != nil {
+				return fmt.Errorf("json encode error %v", err)
+			}
+			return nil
+		}
+		data, err := proto.Marshal(entity)
+		if err != nil {
+			return fmt.Errorf("pb marshall error %v", err)
+		}
+		var lenBuf [binary.MaxVarintLen64]byte
+		n := binary.PutUvarint(lenBuf[:], uint64(len(data)))
+		if _, err := bw.Write(lenBuf[:n]); err != nil {
+			return fmt.Errorf("export write error %v", err)
+		}
+		if _, err := bw.Write(data); err != nil {
+			return fmt.Errorf("export write error %v", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+// Import reads entities in the given format from r and stores them. Lease
+// records are skipped. It returns the number of imported entities.
+func (db *DB) Import(r io.Reader, format Format) (int, error) {
+	br := bufio.NewReader(r)
+	dec := json.NewDecoder(br)
+	count := 0
+	for {
+		entity, err := readImportEntity(br, dec, format)
+		if err == io.EOF {
+			return count, nil
+		}
+		if err != nil {
+			return count, fmt.Errorf("import entity %d: %v", count+1, err)
+		}
+		if isLease(entity.Key) {
+			// leases of the exporting database are not held here
+			continue
+		}
+		if err := db.Set(entity); err != nil {
+			return count, err
+		}
+		count++
+	}
+}
+
+func readImportEntity(br *bufio.Reader, dec *json.Decoder, format Format) (*pb.Entity, error) {
+	if format == JSONLines {
+		var rec Record
+		if err := dec.Decode(&rec); err != nil {
+			return nil, err
+		}
+		if rec.Key == "" {
+			return nil, fmt.Errorf("record without key")
+		}
+		if rec.Tombstone {
+			return nil, fmt.Errorf("tombstones can not be imported")
+		}
+		return &pb.Entity{
+			Key:          rec.Key,
+			Value:        rec.Value,
+			ContentType:  rec.ContentType,
+			CacheControl: rec.CacheControl,
+			Metadata:     rec.Metadata,
+		}, nil
+	}
+
+	size, err := binary.ReadUvarint(br)
+	if err != nil {
+		return nil, err
+	}
+	data := make([]byte, size)
+	if _, err := io.ReadFull(br, data); err != nil {
+		return nil, fmt.Errorf("read entity error %v", err)
+	}
+	entity := &pb.Entity{}
+	if err := proto.Unmarshal(data, entity); err != nil {
+		return nil, fmt.Errorf("proto unmarshal error %v", err)
+	}
+	if entity.Tombstone {
+		return nil, fmt.Errorf("tombstones can not be imported")
+	}
+	return entity, nil
+}
@@ -0,0 +1,110 @@
+package db
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/gerlacdt/db-key-value-store/pb"
+	"github.com/mattetti/filebuffer"
+)
+
+func TestExportJSONLines(t *testing.T) {
+	db := setup(t)
+	err := db.Set(&pb.Entity{Key: "foo-key", Value: []byte("foo-value")})
+	if err != nil {
+		t.Fatalf("error SET %v", err)
+	}
+	err = db.Set(&pb.Entity{Key: "bar-key", Value: []byte("bar-value")})
+	if err != nil {
+		t.Fatalf("error SET %v", err)
+	}
+	err = db.Delete("bar-key")
+	if err != nil {
+		t.Fatalf("error DELETE %v", err)
+	}
+
+	var buf bytes.Buffer
+	err = db.Export(&buf, JSONLines)
+	if err != nil {
+		t.Fatalf("error exporting %v", err)
+	}
+
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	if len(lines) != 1 {
+		t.Fatalf("lines expected %d, got %d", 1, len(lines))
+	}
+	var rec Record
+	if err := json.Unmarshal([]byte(lines[0]), &rec); err != nil {
+		t.Fatalf("error decoding record %v", err)
+	}
+	if !strings.Contains(lines[0], `"value":"Zm9vLXZhbHVl"`) {
+		t.Fatalf("expected base64 value, got %s", lines[0])
+	}
+	if rec.Key != "foo-key" || rec.Size != 9 || rec.Seq != 1 || rec.Timestamp.IsZero() {
+		t.Fatalf("unexpected record %+v", rec)
+	}
+}
+
+func TestExportImport(t *testing.T) {
+	for _, format := range []Format{JSONLines, Protobuf} {
+		format := format
+		t.Run(format.String(), func(t *testing.T) {
+			src := setup(t)
+			entities := []*pb.Entity{
+				{Key: "foo-key-1", Value: []byte("foo-value-1")},
+				{Key: "foo-key-2", Value: []byte{0, 1, 2, 255}},
+				{Key: "foo-key-3", Value: []byte{}},
+			}
+			for _, entity := range entities {
+				if err := src.Set(entity); err != nil {
+					t.Fatalf("error SET %v", err)
+				}
+			}
+
+			var buf bytes.Buffer
+			if err := src.Export(&buf, format); err != nil {
+				t.Fatalf("error exporting %v", err)
+			}
+			dst := New(filebuffer.New(nil))
+			n, err := dst.Import(&buf, format)
+			if err != nil {
+				t.Fatalf("error importing %v", err)
+			}
+			if n != len(entities) {
+				t.Fatalf("imported expected %d, got %d", len(entities), n)
+			}
+
+			for _, entity := range entities {
+				readEntity, err := dst.Get(entity.Key)
+				if err != nil {
+					t.Fatalf("error getting entity %v", err)
+				}
+				if readEntity == nil || !bytes.Equal(entity.Value, readEntity.Value) {
+					t.Fatalf("expected %v, got %v", entity, readEntity)
+				}
+			}
+		})
+	}
+}
+
+func TestImportInvalid(t *testing.T) {
+	db := setup(t)
+	input := `{"key":"foo-key","value":"Zm9vLXZhbHVl"}` + "\n" + `{"value":"Zm9v"}` + "\n"
+	n, err := db.Import(strings.NewReader(input), JSONLines)
+	if err == nil {
+		t.Fatalf("expected error importing record without key")
+	}
+	if n != 1 {
+		t.Fatalf("imported expected %d, got %d", 1, n)
+	}
+	readEntity, err := db.Get("foo-key")
+	if err != nil {
+		t.Fatalf("error getting entity %v", err)
+	}
+	if !reflect.DeepEqual([]byte("foo-value"), readEntity.Value) {
+		t.Fatalf("expected %s, got %s", "foo-value", readEntity.Value)
+	}
+}
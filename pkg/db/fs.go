@@ -0,0 +1,355 @@
+package db
+
+import (
+	"errors"
+	"io"
+	"os"
+	"sync"
+)
+
+// File is a log file.
+type File interface {
+	io.ReadWriteSeeker
+	io.ReaderAt
+	io.Closer
+	Sync() error
+	Truncate(size int64) error
+}
+
+// FS opens and manages log files. OSFS stores them on disk, MemFS in memory
+// and FaultFS injects failures into another FS.
+type FS interface {
+	OpenFile(name string, flag int, perm os.FileMode) (File, error)
+	Remove(name string) error
+	Rename(oldname, newname string) error
+}
+
+// OSFS is the file system of the operating system.
+type OSFS struct{}
+
+// OpenFile opens the named file with os.OpenFile.
+func (OSFS) OpenFile(name string, flag int, perm os.FileMode) (File, error) {
+	f, err := os.OpenFile(name, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// Remove removes the named file.
+func (OSFS) Remove(name string) error { return os.Remove(name) }
+
+// Rename renames the file oldname to newname.
+func (OSFS) Rename(oldname, newname string) error { return os.Rename(oldname, newname) }
+
+// MemFS is an in-memory file system which keeps the synced content of every
+// file apart, so Crash can simulate a power loss.
+type MemFS struct {
+	lock  sync.Mutex
+	files map[string]*memData
+}
+
+// memData is the content of a file shared by all its handles.
+type memData struct {
+	data   []byte
+	synced []byte
+}
+
+// NewMemFS returns an empty in-memory file system.
+func NewMemFS() *MemFS {
+	return &MemFS{files: make(map[string]*memData)}
+}
+
+// OpenFile opens the named file, the flags O_CREATE, O_EXCL, O_TRUNC and
+// O_APPEND are supported.
+func (fs *MemFS) OpenFile(name string, flag int, perm os.FileMode) (File, error) {
+	fs.lock.Lock()
+	defer fs.lock.Unlock()
+
+	d, ok := fs.files[name]
+	switch {
+	case ok && flag&os.O_CREATE != 0 && flag&os.O_EXCL != 0:
+		return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrExist}
+	case !ok && flag&os.O_CREATE == 0:
+		return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrNotExist}
+	case !ok:
+		d = &memData{}
+		fs.files[name] = d
+	}
+	if flag&os.O_TRUNC != 0 {
+		d.data = nil
+	}
+	return &memFile{fs: fs, d: d, append: flag&os.O_APPEND != 0}, nil
+}
+
+// Remove removes the named file.
+func (fs *MemFS) Remove(name string) error {
+	fs.lock.Lock()
+	defer fs.lock.Unlock()
+
+	if _, ok := fs.files[name]; !ok {
+		return &os.PathError{Op: "remove", Path: name, Err: os.ErrNotExist}
+	}
+	delete(fs.files, name)
+	return nil
+}
+
+// Rename renames the file oldname to newname, an existing newname is
+// replaced.
+func (fs *MemFS) Rename(oldname, newname string) error {
+	fs.lock.Lock()
+	defer fs.lock.Unlock()
+
+	d, ok := fs.files[oldname]
+	if !ok {
+		return &os.LinkError{Op: "rename", Old: oldname, New: newname, Err: os.ErrNotExist}
+	}
+	delete(fs.files, oldname)
+	fs.files[newname] = d
+	return nil
+}
+
+// Bytes returns a copy of the content of the named file.
+func (fs *MemFS) Bytes(name string) []byte {
+	fs.lock.Lock()
+	defer fs.lock.Unlock()
+
+	d, ok := fs.files[name]
+	if !ok {
+		return nil
+	}
+	return append([]byte(nil), d.data...)
+}
+
+// Crash simulates a power loss, every file loses the content which was not
+// synced. Open handles keep working on the remaining content.
+func (fs *MemFS) Crash() {
+	fs.lock.Lock()
+	defer fs.lock.Unlock()
+
+	for _, d := range fs.files {
+		d.data = append([]byte(nil), d.synced...)
+	}
+}
+
+// memFile is a handle of a MemFS file with its own position.
+type memFile struct {
+	fs     *MemFS
+	d      *memData
+	pos    int64
+	append bool
+	closed bool
+}
+
+func (f *memFile) Read(p []byte) (int, error) {
+	f.fs.lock.Lock()
+	defer f.fs.lock.Unlock()
+
+	if f.closed {
+		return 0, os.ErrClosed
+	}
+	if f.pos >= int64(len(f.d.data)) {
+		return 0, io.EOF
+	}
+	n := copy(p, f.d.data[f.pos:])
+	f.pos += int64(n)
+	return n, nil
+}
+
+func (f *memFile) ReadAt(p []byte, off int64) (int, error) {
+	f.fs.lock.Lock()
+	defer f.fs.lock.Unlock()
+
+	if f.closed {
+		return 0, os.ErrClosed
+	}
+	if off >= int64(len(f.d.data)) {
+		return 0, io.EOF
+	}
+	n := copy(p, f.d.data[off:])
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func (f *memFile) Write(p []byte) (int, error) {
+	f.fs.lock.Lock()
+	defer f.fs.lock.Unlock()
+
+	if f.closed {
+		return 0, os.ErrClosed
+	}
+	if f.append {
+		f.pos = int64(len(f.d.data))
+	}
+	if end := f.pos + int64(len(p)); end > int64(len(f.d.data)) {
+		data := make([]byte, end)
+		copy(data, f.d.data)
+		f.d.data = data
+	}
+	n := copy(f.d.data[f.pos:], p)
+	f.pos += int64(n)
+	return n, nil
+}
+
+func (f *memFile) Seek(offset int64, whence int) (int64, error) {
+	f.fs.lock.Lock()
+	defer f.fs.lock.Unlock()
+
+	if f.closed {
+		return 0, os.ErrClosed
+	}
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += f.pos
+	case io.SeekEnd:
+		offset += int64(len(f.d.data))
+	default:
+		return 0, errors.New("invalid whence")
+	}
+	if offset < 0 {
+		return 0, errors.New("negative position")
+	}
+	f.pos = offset
+	return offset, nil
+}
+
+func (f *memFile) Sync() error {
+	f.fs.lock.Lock()
+	defer f.fs.lock.Unlock()
+
+	if f.closed {
+		return os.ErrClosed
+	}
+	f.d.synced = append([]byte(nil), f.d.data...)
+	return nil
+}
+
+func (f *memFile) Truncate(size int64) error {
+	f.fs.lock.Lock()
+	defer f.fs.lock.Unlock()
+
+	if f.closed {
+		return os.ErrClosed
+	}
+	if size < 0 {
+		return errors.New("negative size")
+	}
+	data := make([]byte, size)
+	copy(data, f.d.data)
+	f.d.data = data
+	return nil
+}
+
+func (f *memFile) Close() error {
+	f.fs.lock.Lock()
+	defer f.fs.lock.Unlock()
+
+	if f.closed {
+		return os.ErrClosed
+	}
+	f.closed = true
+	return nil
+}
+
+// ErrInjected is returned by FaultFS for injected failures.
+var ErrInjected = errors.New("injected fault")
+
+// FaultFS injects failures into the files of another FS. After the write
+// budget is used up, the write which exceeds it is only partially written and
+// it and all later writes, syncs and truncates fail, like a process which is
+// killed in the middle of a write. Syncs can fail independently.
+type FaultFS struct {
+	FS
+
+	lock      sync.Mutex
+	budget    int64 // bytes which can still be written, negative is unlimited
+	written   int64
+	failSyncs bool
+	crashed   bool
+}
+
+// NewFaultFS returns a FaultFS without failures.
+func NewFaultFS(fs FS) *FaultFS {
+	return &FaultFS{FS: fs, budget: -1}
+}
+
+// FailAfter lets writes fail after n more bytes are written.
+func (fs *FaultFS) FailAfter(n int64) {
+	fs.lock.Lock()
+	defer fs.lock.Unlock()
+	fs.budget = n
+}
+
+// FailSyncs lets all syncs fail.
+func (fs *FaultFS) FailSyncs(fail bool) {
+	fs.lock.Lock()
+	defer fs.lock.Unlock()
+	fs.failSyncs = fail
+}
+
+// Written returns the number of bytes written to all files.
+func (fs *FaultFS) Written() int64 {
+	fs.lock.Lock()
+	defer fs.lock.Unlock()
+	return fs.written
+}
+
+// OpenFile opens the named file of the underlying FS.
+func (fs *FaultFS) OpenFile(name string, flag int, perm os.FileMode) (File, error) {
+	f, err := fs.FS.OpenFile(name, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+	return &faultFile{File: f, fs: fs}, nil
+}
+
+// faultFile is a file of a FaultFS.
+type faultFile struct {
+	File
+	fs *FaultFS
+}
+
+func (f *faultFile) Write(p []byte) (int, error) {
+	f.fs.lock.Lock()
+	defer f.fs.lock.Unlock()
+
+	if f.fs.crashed {
+		return 0, ErrInjected
+	}
+	if f.fs.budget < 0 || int64(len(p)) <= f.fs.budget {
+		n, err := f.File.Write(p)
+		f.fs.written += int64(n)
+		if f.fs.budget >= 0 {
+			f.fs.budget -= int64(n)
+		}
+		return n, err
+	}
+	n, _ := f.File.Write(p[:f.fs.budget])
+	f.fs.written += int64(n)
+	f.fs.budget = 0
+	f.fs.crashed = true
+	return n, ErrInjected
+}
+
+func (f *faultFile) Sync() error {
+	f.fs.lock.Lock()
+	defer f.fs.lock.Unlock()
+
+	if f.fs.crashed || f.fs.failSyncs {
+		return ErrInjected
+	}
+	return f.File.Sync()
+}
+
+func (f *faultFile) Truncate(size int64) error {
+	f.fs.lock.Lock()
+	defer f.fs.lock.Unlock()
+
+	if f.fs.crashed {
+		return ErrInjected
+	}
+	return f.File.Truncate(size)
+}
@@ -0,0 +1,247 @@
+package db
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/gerlacdt/db-key-value-store/pb"
+)
+
+// ErrUnknownIndex is returned for queries on an index that was not created.
+var ErrUnknownIndex = errors.New("unknown index")
+
+// IndexSpec declares a secondary index on a field of the JSON values stored
+// under a key prefix.
+type IndexSpec struct {
+	Name   string
+	Prefix string
+	Path   string // JSON path of the field, e.g. $.email or $.address.city
+}
+
+// ParseIndexSpec parses an index declaration in the form name:prefix:path.
+func ParseIndexSpec(s string) (IndexSpec, error) {
+	parts := strings.SplitN(s, ":", 3)
+	if len(parts) != 3 {
+		return IndexSpec{}, fmt.Errorf("index %q must have the form name:prefix:path", s)
+	}
+	return IndexSpec{Name: parts[0], Prefix: parts[1], Path: parts[2]}, nil
+}
+
+// IndexValue is an indexed JSON string, number or boolean.
+type IndexValue struct {
+	kind int
+	num  float64
+	str  string
+}
+
+const (
+	stringValue = iota
+	numberValue
+	boolValue
+)
+
+// ParseIndexValue interprets s as JSON literal, e.g. 42, true or "42". Any
+// other input is taken as string.
+func ParseIndexValue(s string) IndexValue {
+	var v interface{}
+	if err := json.Unmarshal([]byte(s), &v); err == nil {
+		if iv, ok := newIndexValue(v); ok {
+			return iv
+		}
+	}
+	return IndexValue{kind: stringValue, str: s}
+}
+
+func newIndexValue(v interface{}) (IndexValue, bool) {
+	switch v := v.(type) {
+	case string:
+		return IndexValue{kind: stringValue, str: v}, true
+	case float64:
+		return IndexValue{kind: numberValue, num: v}, true
+	case bool:
+		if v {
+			return IndexValue{kind: boolValue, num: 1}, true
+		}
+		return IndexValue{kind: boolValue}, true
+	default:
+		return IndexValue{}, false
+	}
+}
+
+// compare returns -1, 0 or 1. Values of different kinds are not comparable.
+func (v IndexValue) compare(o IndexValue) (int, bool) {
+	if v.kind != o.kind {
+		return 0, false
+	}
+	switch {
+	case v.kind == stringValue && v.str < o.str, v.kind != stringValue && v.num < o.num:
+		return -1, true
+	case v.kind == stringValue && v.str > o.str, v.kind != stringValue && v.num > o.num:
+		return 1, true
+	}
+	return 0, true
+}
+
+// IndexQuery selects the keys whose indexed field equals Eq or, if Eq is nil,
+// lies between From and To. Range bounds are inclusive, a nil bound is open.
+type IndexQuery struct {
+	Eq   *IndexValue
+	From *IndexValue
+	To   *IndexValue
+}
+
+func (q IndexQuery) matches(v IndexValue) bool {
+	if q.Eq != nil {
+		c, ok := v.compare(*q.Eq)
+		return ok && c == 0
+	}
+	if q.From != nil {
+		if c, ok := v.compare(*q.From); !ok || c < 0 {
+			return false
+		}
+	}
+	if q.To != nil {
+		if c, ok := v.compare(*q.To); !ok || c > 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// secondaryIndex maps the indexed field values to keys.
+type secondaryIndex struct {
+	spec    IndexSpec
+	path    []string
+	byValue map[IndexValue]map[string]struct{}
+	byKey   map[string]IndexValue
+}
+
+func newSecondaryIndex(spec IndexSpec) (*secondaryIndex, error) {
+	if spec.Name == "" {
+		return nil, fmt.Errorf("index without name")
+	}
+	if !strings.HasPrefix(spec.Path, "$.") || len(spec.Path) == 2 {
+		return nil, fmt.Errorf("index %s: JSON path %q must start with $. and name a field", spec.Name, spec.Path)
+	}
+	return &secondaryIndex{
+		spec:    spec,
+		path:    strings.Split(spec.Path[2:], "."),
+		byValue: make(map[IndexValue]map[string]struct{}),
+		byKey:   make(map[string]IndexValue),
+	}, nil
+}
+
+// extract returns the value of the indexed field. Values which are not JSON or
+// do not contain the field are not indexed.
+func (idx *secondaryIndex) extract(value []byte) (IndexValue, bool) {
+	var v interface{}
+	if err := json.Unmarshal(value, &v); err != nil {
+		return IndexValue{}, false
+	}
+	for _, field := range idx.path {
+		obj, ok := v.(map[string]interface{})
+		if !ok {
+			return IndexValue{}, false
+		}
+		v, ok = obj[field]
+		if !ok {
+			return IndexValue{}, false
+		}
+	}
+	return newIndexValue(v)
+}
+
+// update indexes the committed entity.
+func (idx *secondaryIndex) update(entity *pb.Entity) {
+	if !strings.HasPrefix(entity.Key, idx.spec.Prefix) {
+		return
+	}
+	if old, ok := idx.byKey[entity.Key]; ok {
+		delete(idx.byKey, entity.Key)
+		delete(idx.byValue[old], entity.Key)
+		if len(idx.byValue[old]) == 0 {
+			delete(idx.byValue, old)
+		}
+	}
+	if entity.Tombstone {
+		return
+	}
+	v, ok := idx.extract(entity.Value)
+	if !ok {
+		return
+	}
+	idx.byKey[entity.Key] = v
+	keys, ok := idx.byValue[v]
+	if !ok {
+		keys = make(map[string]struct{})
+		idx.byValue[v] = keys
+	}
+	keys[entity.Key] = struct{}{}
+}
+
+// updateIndexes maintains all secondary indexes. The caller must hold the
+// write lock.
+func (db *DB) updateIndexes(entity *pb.Entity) {
+	for _, idx := range db.indexes {
+		idx.update(entity)
+	}
+}
+
+// CreateIndex declares a secondary index. Indexes are kept in memory, they are
+// built from the live keyspace and rebuilt by Recover.
+func (db *DB) CreateIndex(spec IndexSpec) error {
+	idx, err := newSecondaryIndex(spec)
+	if err != nil {
+		return err
+	}
+
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	if _, ok := db.indexes[spec.Name]; ok {
+		return fmt.Errorf("index %s already exists", spec.Name)
+	}
+	for key, e := range db.index {
+		if e.tombstone || !strings.HasPrefix(key, spec.Prefix) {
+			continue
+		}
+		entity, err := db.readAt(e.offset)
+		if err != nil {
+			return err
+		}
+		idx.update(entity)
+	}
+	db.indexes[spec.Name] = idx
+	return nil
+}
+
+// Query returns the sorted keys selected by the query on the named index.
+func (db *DB) Query(name string, q IndexQuery) ([]string, error) {
+	db.lock.RLock()
+	defer db.lock.RUnlock()
+
+	idx, ok := db.indexes[name]
+	if !ok {
+		return nil, ErrUnknownIndex
+	}
+	keys := []string{}
+	if q.Eq != nil {
+		for key := range idx.byValue[*q.Eq] {
+			keys = append(keys, key)
+		}
+	} else {
+		for v, byValue := range idx.byValue {
+			if !q.matches(v) {
+				continue
+			}
+			for key := range byValue {
+				keys = append(keys, key)
+			}
+		}
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
@@ -0,0 +1,122 @@
+package db
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/gerlacdt/db-key-value-store/pb"
+)
+
+func setupIndex(t *testing.T) *DB {
+	db := setup(t)
+	err := db.CreateIndex(IndexSpec{Name: "email", Prefix: "user-", Path: "$.email"})
+	if err != nil {
+		t.Fatalf("error creating index %v", err)
+	}
+	err = db.CreateIndex(IndexSpec{Name: "age", Prefix: "user-", Path: "$.profile.age"})
+	if err != nil {
+		t.Fatalf("error creating index %v", err)
+	}
+	values := map[string]string{
+		"user-1":  `{"email": "a@example.com", "profile": {"age": 30}}`,
+		"user-2":  `{"email": "b@example.com", "profile": {"age": 40}}`,
+		"user-3":  `{"email": "a@example.com", "profile": {"age": "unknown"}}`,
+		"user-4":  `not json`,
+		"other-1": `{"email": "a@example.com"}`,
+	}
+	for key, value := range values {
+		if err := db.Set(&pb.Entity{Key: key, Value: []byte(value)}); err != nil {
+			t.Fatalf("error SET %v", err)
+		}
+	}
+	return db
+}
+
+func query(t *testing.T, db *DB, name string, q IndexQuery) []string {
+	keys, err := db.Query(name, q)
+	if err != nil {
+		t.Fatalf("error querying index %v", err)
+	}
+	return keys
+}
+
+func value(s string) *IndexValue {
+	v := ParseIndexValue(s)
+	return &v
+}
+
+func TestIndexEqual(t *testing.T) {
+	db := setupIndex(t)
+
+	keys := query(t, db, "email", IndexQuery{Eq: value("a@example.com")})
+	expected := []string{"user-1", "user-3"}
+	if !reflect.DeepEqual(expected, keys) {
+		t.Fatalf("expected %v, got %v", expected, keys)
+	}
+
+	// updates and deletes are reflected
+	err := db.Set(&pb.Entity{Key: "user-1", Value: []byte(`{"email": "c@example.com"}`)})
+	if err != nil {
+		t.Fatalf("error SET %v", err)
+	}
+	if err := db.Delete("user-3"); err != nil {
+		t.Fatalf("error DELETE %v", err)
+	}
+	keys = query(t, db, "email", IndexQuery{Eq: value("a@example.com")})
+	if len(keys) != 0 {
+		t.Fatalf("expected no keys, got %v", keys)
+	}
+	keys = query(t, db, "email", IndexQuery{Eq: value("c@example.com")})
+	if !reflect.DeepEqual([]string{"user-1"}, keys) {
+		t.Fatalf("expected %v, got %v", []string{"user-1"}, keys)
+	}
+}
+
+func TestIndexRange(t *testing.T) {
+	db := setupIndex(t)
+
+	keys := query(t, db, "age", IndexQuery{From: value("35")})
+	if !reflect.DeepEqual([]string{"user-2"}, keys) {
+		t.Fatalf("expected %v, got %v", []string{"user-2"}, keys)
+	}
+	keys = query(t, db, "age", IndexQuery{From: value("30"), To: value("40")})
+	if !reflect.DeepEqual([]string{"user-1", "user-2"}, keys) {
+		t.Fatalf("expected %v, got %v", []string{"user-1", "user-2"}, keys)
+	}
+	keys = query(t, db, "age", IndexQuery{Eq: value(`"unknown"`)})
+	if !reflect.DeepEqual([]string{"user-3"}, keys) {
+		t.Fatalf("expected %v, got %v", []string{"user-3"}, keys)
+	}
+}
+
+func TestIndexRecover(t *testing.T) {
+	db := setupIndex(t)
+	if err := db.Delete("user-2"); err != nil {
+		t.Fatalf("error DELETE %v", err)
+	}
+
+	// clear map and indexes
+	db.index = make(map[string]entry)
+	err := db.Recover()
+	if err != nil {
+		t.Fatalf("error recovering %v", err)
+	}
+
+	keys := query(t, db, "age", IndexQuery{})
+	if !reflect.DeepEqual([]string{"user-1", "user-3"}, keys) {
+		t.Fatalf("expected %v, got %v", []string{"user-1", "user-3"}, keys)
+	}
+}
+
+func TestIndexErrors(t *testing.T) {
+	db := setupIndex(t)
+	if _, err := db.Query("unknown", IndexQuery{}); err != ErrUnknownIndex {
+		t.Fatalf("expected %v, got %v", ErrUnknownIndex, err)
+	}
+	if err := db.CreateIndex(IndexSpec{Name: "email", Path: "$.email"}); err == nil {
+		t.Fatalf("expected error creating duplicate index")
+	}
+	if err := db.CreateIndex(IndexSpec{Name: "invalid", Path: "email"}); err == nil {
+		t.Fatalf("expected error creating index with invalid path")
+	}
+}
@@ -0,0 +1,153 @@
+package db
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/gerlacdt/db-key-value-store/pb"
+)
+
+// LeasePrefix is the key prefix of the records which persist the leases. They
+// are not listed, exported, imported or counted by quotas.
+const LeasePrefix = "lease/"
+
+var (
+	// ErrLeaseHeld is returned when acquiring a lease held by another holder.
+	ErrLeaseHeld = errors.New("lease held by another holder")
+	// ErrLeaseNotHeld is returned when renewing or releasing a lease which
+	// expired or was acquired with another fencing token.
+	ErrLeaseNotHeld = errors.New("lease not held")
+)
+
+// Lease is a named lock held until it expires or is released. The fencing
+// token is the sequence number of the record which acquired the lease, so it
+// increases with every acquisition and stays the same while the lease is
+// renewed.
+type Lease struct {
+	Name    string    `json:"name"`
+	Holder  string    `json:"holder"`
+	Token   uint64    `json:"token"`
+	Expires time.Time `json:"expires"`
+}
+
+func isLease(key string) bool {
+	return strings.HasPrefix(key, LeasePrefix)
+}
+
+func (l *Lease) expired(now time.Time) bool {
+	return !now.Before(l.Expires)
+}
+
+// Lease returns the named lease or nil if it is not held.
+func (db *DB) Lease(name string) (*Lease, error) {
+	db.lock.RLock()
+	defer db.lock.RUnlock()
+
+	if db.closed {
+		return nil, ErrClosed
+	}
+	return db.lease(name, time.Now())
+}
+
+// lease reads the persisted lease, expired leases are nil. The caller must hold
+// the lock.
+func (db *DB) lease(name string, now time.Time) (*Lease, error) {
+	e, ok := db.index[LeasePrefix+name]
+	if !ok || e.tombstone {
+		return nil, nil
+	}
+	entity, err := db.readAt(e.offset)
+	if err != nil {
+		return nil, err
+	}
+	l := &Lease{}
+	if err := json.Unmarshal(entity.Value, l); err != nil {
+		return nil, fmt.Errorf("invalid lease %s: %v", name, err)
+	}
+	if l.expired(now) {
+		return nil, nil
+	}
+	return l, nil
+}
+
+// commitLease persists the lease. The caller must hold the write lock.
+func (db *DB) commitLease(l *Lease) error {
+	value, err := json.Marshal(l)
+	if err != nil {
+		return fmt.Errorf("json encode error %v", err)
+	}
+	return db.commit(&pb.Entity{Key: LeasePrefix + l.Name, Value: value})
+}
+
+// Acquire acquires the named lease for the holder with the given time to live.
+// Acquiring a lease again by its current holder extends it and keeps the
+// fencing token. ErrLeaseHeld is returned if another holder has the lease.
+func (db *DB) Acquire(name, holder string, ttl time.Duration) (*Lease, error) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	if db.closed {
+		return nil, ErrClosed
+	}
+	now := time.Now()
+	l, err := db.lease(name, now)
+	if err != nil {
+		return nil, err
+	}
+	if l != nil && l.Holder != holder {
+		return nil, ErrLeaseHeld
+	}
+	if l == nil {
+		// the token is the sequence number of the acquiring record
+		l = &Lease{Name: name, Holder: holder, Token: db.seq + 1}
+	}
+	l.Expires = now.Add(ttl)
+	if err := db.commitLease(l); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// Renew extends the lease acquired with the fencing token by ttl from now.
+func (db *DB) Renew(name string, token uint64, ttl time.Duration) (*Lease, error) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	if db.closed {
+		return nil, ErrClosed
+	}
+	now := time.Now()
+	l, err := db.lease(name, now)
+	if err != nil {
+		return nil, err
+	}
+	if l == nil || l.Token != token {
+		return nil, ErrLeaseNotHeld
+	}
+	l.Expires = now.Add(ttl)
+	if err := db.commitLease(l); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// Release releases the lease acquired with the fencing token.
+func (db *DB) Release(name string, token uint64) error {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	if db.closed {
+		return ErrClosed
+	}
+	l, err := db.lease(name, time.Now())
+	if err != nil {
+		return err
+	}
+	if l == nil || l.Token != token {
+		return ErrLeaseNotHeld
+	}
+	return db.commit(&pb.Entity{Tombstone: true, Key: LeasePrefix + name})
+}
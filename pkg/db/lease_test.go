@@ -0,0 +1,122 @@
+package db
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gerlacdt/db-key-value-store/pb"
+	"github.com/mattetti/filebuffer"
+)
+
+func TestLeaseAcquireRenewRelease(t *testing.T) {
+	db := setup(t)
+
+	l, err := db.Acquire("jobs", "worker-1", time.Minute)
+	if err != nil {
+		t.Fatalf("error acquiring lease %v", err)
+	}
+	if _, err := db.Acquire("jobs", "worker-2", time.Minute); err != ErrLeaseHeld {
+		t.Fatalf("expected %v, got %v", ErrLeaseHeld, err)
+	}
+	renewed, err := db.Renew("jobs", l.Token, 2*time.Minute)
+	if err != nil {
+		t.Fatalf("error renewing lease %v", err)
+	}
+	if renewed.Token != l.Token || !renewed.Expires.After(l.Expires) {
+		t.Fatalf("expected renewed lease with token %d, got %+v", l.Token, renewed)
+	}
+	if _, err := db.Renew("jobs", l.Token+1, time.Minute); err != ErrLeaseNotHeld {
+		t.Fatalf("expected %v, got %v", ErrLeaseNotHeld, err)
+	}
+	if err := db.Release("jobs", l.Token); err != nil {
+		t.Fatalf("error releasing lease %v", err)
+	}
+	current, err := db.Lease("jobs")
+	if err != nil || current != nil {
+		t.Fatalf("expected released lease, got %v %v", current, err)
+	}
+
+	next, err := db.Acquire("jobs", "worker-2", time.Minute)
+	if err != nil {
+		t.Fatalf("error acquiring lease %v", err)
+	}
+	if next.Token <= l.Token {
+		t.Fatalf("expected fencing token > %d, got %d", l.Token, next.Token)
+	}
+}
+
+func TestLeaseExpiry(t *testing.T) {
+	db := setup(t)
+
+	l, err := db.Acquire("jobs", "worker-1", time.Millisecond)
+	if err != nil {
+		t.Fatalf("error acquiring lease %v", err)
+	}
+	time.Sleep(5 * time.Millisecond)
+	if _, err := db.Renew("jobs", l.Token, time.Minute); err != ErrLeaseNotHeld {
+		t.Fatalf("expected %v, got %v", ErrLeaseNotHeld, err)
+	}
+	next, err := db.Acquire("jobs", "worker-2", time.Minute)
+	if err != nil {
+		t.Fatalf("error acquiring expired lease %v", err)
+	}
+	if next.Token <= l.Token {
+		t.Fatalf("expected fencing token > %d, got %d", l.Token, next.Token)
+	}
+}
+
+func TestLeaseRecover(t *testing.T) {
+	t.Parallel()
+	f := filebuffer.New(nil)
+	l, err := New(f).Acquire("jobs", "worker-1", time.Minute)
+	if err != nil {
+		t.Fatalf("error acquiring lease %v", err)
+	}
+
+	db := New(f)
+	if err := db.Recover(); err != nil {
+		t.Fatalf("error recovering %v", err)
+	}
+	if _, err := db.Acquire("jobs", "worker-2", time.Minute); err != ErrLeaseHeld {
+		t.Fatalf("expected %v, got %v", ErrLeaseHeld, err)
+	}
+	if _, err := db.Renew("jobs", l.Token, time.Minute); err != nil {
+		t.Fatalf("error renewing recovered lease %v", err)
+	}
+}
+
+func TestLeaseHidden(t *testing.T) {
+	db := setup(t)
+	db.SetQuotas([]Quota{{MaxKeys: 1}})
+	if _, err := db.Acquire("jobs", "worker-1", time.Minute); err != nil {
+		t.Fatalf("error acquiring lease %v", err)
+	}
+	if err := db.Set(&pb.Entity{Key: "foo", Value: []byte("bar")}); err != nil {
+		t.Fatalf("error setting key with a lease held %v", err)
+	}
+	if keys := db.Keys("", 0); len(keys) != 1 || keys[0] != "foo" {
+		t.Fatalf("expected keys [foo], got %v", keys)
+	}
+	if usage := db.QuotaUsage(); usage[0].Keys != 1 {
+		t.Fatalf("expected quota usage of 1 key, got %d", usage[0].Keys)
+	}
+
+	var buf bytes.Buffer
+	if err := db.Export(&buf, JSONLines); err != nil {
+		t.Fatalf("error exporting %v", err)
+	}
+	if strings.Contains(buf.String(), LeasePrefix) {
+		t.Fatalf("expected export without leases, got %s", buf.String())
+	}
+	buf.WriteString(`{"key":"lease/jobs","value":"e30="}` + "\n")
+	dst := New(filebuffer.New(nil))
+	n, err := dst.Import(&buf, JSONLines)
+	if err != nil || n != 1 {
+		t.Fatalf("expected 1 imported entity, got %d %v", n, err)
+	}
+	if l, err := dst.Lease("jobs"); err != nil || l != nil {
+		t.Fatalf("expected no imported lease, got %v %v", l, err)
+	}
+}
@@ -0,0 +1,128 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/gerlacdt/db-key-value-store/pb"
+)
+
+// ErrInvalidKey is returned by writes of keys which violate the limits.
+var ErrInvalidKey = errors.New("invalid key")
+
+// Defaults of the limits which are not set.
+const (
+	DefaultMaxKeyLength    = 1024
+	DefaultMaxMetadataSize = 4096
+)
+
+// Limits restrict the keys and values of Set and Merge. Keys must not be
+// empty, must be valid UTF-8 and must not contain control characters. Deletes
+// are not checked, so keys written before stricter limits can be deleted.
+type Limits struct {
+	MaxKeyLength int            // in bytes, DefaultMaxKeyLength if zero
+	MaxValueSize int            // in bytes, values are only limited by MaxRecordSize if zero
+	KeyPattern   *regexp.Regexp // keys must match the pattern if set
+
+	// MaxMetadataSize limits the names and values of the metadata, the content
+	// type and the cache control in bytes, DefaultMaxMetadataSize if zero.
+	MaxMetadataSize int
+}
+
+// ParseKeyPattern compiles a key pattern, which is anchored at both ends.
+func ParseKeyPattern(s string) (*regexp.Regexp, error) {
+	re, err := regexp.Compile(`^(?:` + s + `)$`)
+	if err != nil {
+		return nil, fmt.Errorf("invalid key pattern %q: %v", s, err)
+	}
+	return re, nil
+}
+
+// CheckKey returns an error matching ErrInvalidKey if the key violates the
+// limits.
+func (l Limits) CheckKey(key string) error {
+	max := l.MaxKeyLength
+	if max <= 0 {
+		max = DefaultMaxKeyLength
+	}
+	switch {
+	case key == "":
+		return fmt.Errorf("%w: key is empty", ErrInvalidKey)
+	case len(key) > max:
+		return fmt.Errorf("%w: key of %d bytes is longer than %d bytes", ErrInvalidKey, len(key), max)
+	case !utf8.ValidString(key):
+		return fmt.Errorf("%w: key is not valid UTF-8", ErrInvalidKey)
+	}
+	for _, r := range key {
+		if unicode.IsControl(r) {
+			return fmt.Errorf("%w: key contains the control character %U", ErrInvalidKey, r)
+		}
+	}
+	if l.KeyPattern != nil && !l.KeyPattern.MatchString(key) {
+		return fmt.Errorf("%w: key does not match %s", ErrInvalidKey, l.KeyPattern)
+	}
+	return nil
+}
+
+// MaxValue returns the maximum size of values in bytes.
+func (l Limits) MaxValue() int {
+	if l.MaxValueSize <= 0 || l.MaxValueSize > MaxRecordSize {
+		return MaxRecordSize
+	}
+	return l.MaxValueSize
+}
+
+// CheckValue returns an error matching ErrTooLarge if the value is larger than
+// the limits.
+func (l Limits) CheckValue(value []byte) error {
+	if max := l.MaxValue(); len(value) > max {
+		return fmt.Errorf("%w: value of %d bytes is larger than %d bytes", ErrTooLarge, len(value), max)
+	}
+	return nil
+}
+
+// CheckMetadata returns an error matching ErrTooLarge if the content type,
+// the cache control and the metadata of the entity are larger than the
+// limits.
+func (l Limits) CheckMetadata(entity *pb.Entity) error {
+	max := l.MaxMetadataSize
+	if max <= 0 {
+		max = DefaultMaxMetadataSize
+	}
+	size := len(entity.ContentType) + len(entity.CacheControl)
+	for name, value := range entity.Metadata {
+		size += len(name) + len(value)
+	}
+	if size > max {
+		return fmt.Errorf("%w: metadata of %d bytes is larger than %d bytes", ErrTooLarge, size, max)
+	}
+	return nil
+}
+
+// check checks the key, the value and the metadata of a write.
+func (l Limits) check(entity *pb.Entity) error {
+	if err := l.CheckKey(entity.Key); err != nil {
+		return err
+	}
+	if err := l.CheckValue(entity.Value); err != nil {
+		return err
+	}
+	return l.CheckMetadata(entity)
+}
+
+// SetLimits replaces the limits of the database.
+func (db *DB) SetLimits(limits Limits) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+	db.limits = limits
+}
+
+// Limits returns the limits of the database.
+func (db *DB) Limits() Limits {
+	db.lock.RLock()
+	defer db.lock.RUnlock()
+	return db.limits
+}
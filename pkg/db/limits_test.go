@@ -0,0 +1,78 @@
+package db
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/gerlacdt/db-key-value-store/pb"
+	"github.com/mattetti/filebuffer"
+)
+
+func TestCheckKey(t *testing.T) {
+	pattern, err := ParseKeyPattern(`[a-z0-9-]+`)
+	if err != nil {
+		t.Fatalf("error parsing pattern %v", err)
+	}
+	tests := []struct {
+		key    string
+		limits Limits
+		valid  bool
+	}{
+		{"user-1", Limits{}, true},
+		{"", Limits{}, false},
+		{strings.Repeat("k", DefaultMaxKeyLength), Limits{}, true},
+		{strings.Repeat("k", DefaultMaxKeyLength+1), Limits{}, false},
+		{"user-1", Limits{MaxKeyLength: 5}, false},
+		{"user\n1", Limits{}, false},
+		{"user-\xff", Limits{}, false},
+		{"Grüße", Limits{}, true},
+		{"Grüße", Limits{KeyPattern: pattern}, false},
+		{"user-1x", Limits{KeyPattern: pattern}, true},
+	}
+	for _, tt := range tests {
+		err := tt.limits.CheckKey(tt.key)
+		if tt.valid != (err == nil) || (err != nil && !errors.Is(err, ErrInvalidKey)) {
+			t.Fatalf("key %q: expected valid %v, got %v", tt.key, tt.valid, err)
+		}
+	}
+	if _, err := ParseKeyPattern("[a-"); err == nil {
+		t.Fatalf("expected error for invalid pattern")
+	}
+}
+
+func TestLimits(t *testing.T) {
+	t.Parallel()
+	db := New(filebuffer.New(nil))
+	if err := db.Set(&pb.Entity{Key: "", Value: []byte("value")}); !errors.Is(err, ErrInvalidKey) {
+		t.Fatalf("expected %v, got %v", ErrInvalidKey, err)
+	}
+	if err := db.Set(&pb.Entity{Key: "long-key", Value: []byte("value")}); err != nil {
+		t.Fatalf("error SET %v", err)
+	}
+
+	db.SetLimits(Limits{MaxKeyLength: 5, MaxValueSize: 4})
+	if err := db.Set(&pb.Entity{Key: "key", Value: []byte("value")}); !errors.Is(err, ErrTooLarge) {
+		t.Fatalf("expected %v, got %v", ErrTooLarge, err)
+	}
+	if err := db.Set(&pb.Entity{Key: "long-key", Value: []byte("v")}); !errors.Is(err, ErrInvalidKey) {
+		t.Fatalf("expected %v, got %v", ErrInvalidKey, err)
+	}
+	if err := db.Set(&pb.Entity{Key: "key", Value: []byte("abc")}); err != nil {
+		t.Fatalf("error SET %v", err)
+	}
+	if _, err := db.Merge("key", Append([]byte("de"))); !errors.Is(err, ErrTooLarge) {
+		t.Fatalf("expected %v, got %v", ErrTooLarge, err)
+	}
+	if _, err := db.Merge("long-key", Incr(1)); !errors.Is(err, ErrInvalidKey) {
+		t.Fatalf("expected %v, got %v", ErrInvalidKey, err)
+	}
+
+	// keys written before stricter limits can be read and deleted
+	if e, err := db.Get("long-key"); err != nil || e == nil {
+		t.Fatalf("expected long-key, got %v %v", e, err)
+	}
+	if err := db.Delete("long-key"); err != nil {
+		t.Fatalf("error DELETE %v", err)
+	}
+}
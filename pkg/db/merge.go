@@ -0,0 +1,124 @@
+package db
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+
+	"github.com/gerlacdt/db-key-value-store/pb"
+	"github.com/golang/protobuf/proto"
+)
+
+// ErrInvalidMerge is returned if a merge operator can not be applied to the
+// current value.
+var ErrInvalidMerge = errors.New("merge not applicable")
+
+// MergeOp computes the new value of a key from its current value, which is nil
+// if the key does not exist.
+type MergeOp func(current []byte) ([]byte, error)
+
+// Incr adds by to a counter stored as decimal integer. Missing keys count as 0.
+func Incr(by int64) MergeOp {
+	return func(current []byte) ([]byte, error) {
+		n := int64(0)
+		if current != nil {
+			var err error
+			n, err = strconv.ParseInt(string(current), 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("%w: value is not an integer", ErrInvalidMerge)
+			}
+		}
+		if (by > 0 && n > math.MaxInt64-by) || (by < 0 && n < math.MinInt64-by) {
+			return nil, fmt.Errorf("%w: integer overflow", ErrInvalidMerge)
+		}
+		return []byte(strconv.FormatInt(n+by, 10)), nil
+	}
+}
+
+// Append appends data to the current value.
+func Append(data []byte) MergeOp {
+	return func(current []byte) ([]byte, error) {
+		value := make([]byte, 0, len(current)+len(data))
+		value = append(value, current...)
+		return append(value, data...), nil
+	}
+}
+
+// MergePatch applies a JSON merge patch (RFC 7396) to the current JSON value.
+func MergePatch(patch []byte) MergeOp {
+	return func(current []byte) ([]byte, error) {
+		var p interface{}
+		if err := json.Unmarshal(patch, &p); err != nil {
+			return nil, fmt.Errorf("%w: patch is not JSON: %v", ErrInvalidMerge, err)
+		}
+		var target interface{}
+		if current != nil {
+			if err := json.Unmarshal(current, &target); err != nil {
+				return nil, fmt.Errorf("%w: value is not JSON: %v", ErrInvalidMerge, err)
+			}
+		}
+		value, err := json.Marshal(mergePatch(target, p))
+		if err != nil {
+			return nil, fmt.Errorf("json encode error %v", err)
+		}
+		return value, nil
+	}
+}
+
+func mergePatch(target, patch interface{}) interface{} {
+	p, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+	t, ok := target.(map[string]interface{})
+	if !ok {
+		t = make(map[string]interface{})
+	}
+	for k, v := range p {
+		if v == nil {
+			delete(t, k)
+			continue
+		}
+		t[k] = mergePatch(t[k], v)
+	}
+	return t
+}
+
+// Merge atomically replaces the value of the key with the result of the merge
+// operator applied to its current value and returns the committed entity.
+func (db *DB) Merge(key string, op MergeOp) (*pb.Entity, error) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	if err := db.limits.CheckKey(key); err != nil {
+		return nil, err
+	}
+	entity := &pb.Entity{Key: key}
+	var current []byte
+	if e, ok := db.index[key]; ok && !e.tombstone {
+		old, err := db.readAt(e.offset)
+		if err != nil {
+			return nil, err
+		}
+		// keep everything but the value of the current entity
+		entity = proto.Clone(old).(*pb.Entity)
+		current = old.Value
+		if current == nil {
+			current = []byte{}
+		}
+	}
+	value, err := op(current)
+	if err != nil {
+		return nil, err
+	}
+	entity.Value = value
+	if err := db.limits.CheckValue(value); err != nil {
+		return nil, err
+	}
+	if err := db.commit(entity); err != nil {
+		return nil, err
+	}
+	return entity, nil
+}
@@ -0,0 +1,80 @@
+package db
+
+import (
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/gerlacdt/db-key-value-store/pb"
+)
+
+func TestMergeIncr(t *testing.T) {
+	db := setup(t)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				if _, err := db.Merge("counter", Incr(2)); err != nil {
+					t.Errorf("error merging %v", err)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	entity, err := db.Merge("counter", Incr(-8))
+	if err != nil {
+		t.Fatalf("error merging %v", err)
+	}
+	if string(entity.Value) != "792" {
+		t.Fatalf("value expected %s, got %s", "792", entity.Value)
+	}
+
+	err = db.Set(&pb.Entity{Key: "foo-key", Value: []byte("foo-value")})
+	if err != nil {
+		t.Fatalf("error SET %v", err)
+	}
+	if _, err := db.Merge("foo-key", Incr(1)); !errors.Is(err, ErrInvalidMerge) {
+		t.Fatalf("expected %v, got %v", ErrInvalidMerge, err)
+	}
+}
+
+func TestMergeAppend(t *testing.T) {
+	db := setup(t)
+	for _, data := range []string{"a", "b", "c"} {
+		if _, err := db.Merge("list", Append([]byte(data))); err != nil {
+			t.Fatalf("error merging %v", err)
+		}
+	}
+	entity, err := db.Get("list")
+	if err != nil {
+		t.Fatalf("error getting entity %v", err)
+	}
+	if string(entity.Value) != "abc" {
+		t.Fatalf("value expected %s, got %s", "abc", entity.Value)
+	}
+}
+
+func TestMergePatch(t *testing.T) {
+	db := setup(t)
+	err := db.Set(&pb.Entity{Key: "doc", Value: []byte(`{"a": "b", "c": {"d": "e", "f": "g"}}`)})
+	if err != nil {
+		t.Fatalf("error SET %v", err)
+	}
+
+	entity, err := db.Merge("doc", MergePatch([]byte(`{"a": "z", "c": {"f": null}}`)))
+	if err != nil {
+		t.Fatalf("error merging %v", err)
+	}
+	expected := `{"a":"z","c":{"d":"e"}}`
+	if string(entity.Value) != expected {
+		t.Fatalf("value expected %s, got %s", expected, entity.Value)
+	}
+
+	if _, err := db.Merge("doc", MergePatch([]byte(`not json`))); !errors.Is(err, ErrInvalidMerge) {
+		t.Fatalf("expected %v, got %v", ErrInvalidMerge, err)
+	}
+}
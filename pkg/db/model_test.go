@@ -0,0 +1,221 @@
+package db
+
+import (
+	"flag"
+	"fmt"
+	"math/rand"
+	"strings"
+	"testing"
+
+	"github.com/gerlacdt/db-key-value-store/pb"
+)
+
+var (
+	modelSeed  = flag.Int64("model.seed", 0, "run the model test only with this seed")
+	modelSeeds = flag.Int("model.seeds", 50, "number of seeds of the model test")
+	modelSteps = flag.Int("model.steps", 500, "operations per seed of the model test")
+)
+
+// modelKeys is the keyspace of the model test, it is small so operations hit
+// the same keys often.
+const modelKeys = 8
+
+type modelOpKind int
+
+const (
+	opSet modelOpKind = iota
+	opDelete
+	opGet
+	opRecover
+	opReopen // close the database and open the log again
+)
+
+type modelOp struct {
+	kind  modelOpKind
+	key   string
+	value string
+}
+
+func (op modelOp) String() string {
+	switch op.kind {
+	case opSet:
+		return fmt.Sprintf("Set(%q, %q)", op.key, op.value)
+	case opDelete:
+		return fmt.Sprintf("Delete(%q)", op.key)
+	case opGet:
+		return fmt.Sprintf("Get(%q)", op.key)
+	case opRecover:
+		return "Recover()"
+	default:
+		return "Reopen()"
+	}
+}
+
+func generateOps(r *rand.Rand, n int) []modelOp {
+	ops := make([]modelOp, n)
+	for i := range ops {
+		key := fmt.Sprintf("key-%d", r.Intn(modelKeys))
+		switch p := r.Intn(100); {
+		case p < 45:
+			// empty values are valid values
+			ops[i] = modelOp{kind: opSet, key: key, value: strings.Repeat("v", r.Intn(20))}
+		case p < 65:
+			ops[i] = modelOp{kind: opDelete, key: key}
+		case p < 95:
+			ops[i] = modelOp{kind: opGet, key: key}
+		case p < 98:
+			ops[i] = modelOp{kind: opRecover}
+		default:
+			ops[i] = modelOp{kind: opReopen}
+		}
+	}
+	return ops
+}
+
+// runModel runs the operations against a database and a map and returns an
+// error describing the first step where they differ.
+func runModel(ops []modelOp) error {
+	fs := NewMemFS()
+	db, err := Open(fs, "db")
+	if err != nil {
+		return fmt.Errorf("open: %v", err)
+	}
+	defer func() { db.Close() }()
+	model := make(map[string]string)
+
+	compare := func(key string) error {
+		entity, err := db.Get(key)
+		if err != nil {
+			return fmt.Errorf("Get(%q): %v", key, err)
+		}
+		value, ok := model[key]
+		switch {
+		case !ok && entity != nil:
+			return fmt.Errorf("Get(%q) = %q, expected not found", key, entity.Value)
+		case ok && entity == nil:
+			return fmt.Errorf("Get(%q) = not found, expected %q", key, value)
+		case ok && string(entity.Value) != value:
+			return fmt.Errorf("Get(%q) = %q, expected %q", key, entity.Value, value)
+		}
+		return nil
+	}
+
+	for i, op := range ops {
+		switch op.kind {
+		case opSet:
+			err = db.Set(&pb.Entity{Key: op.key, Value: []byte(op.value)})
+			model[op.key] = op.value
+		case opDelete:
+			err = db.Delete(op.key)
+			if _, ok := model[op.key]; !ok {
+				if err != ErrNotFound {
+					return fmt.Errorf("step %d %v: expected %v, got %v", i, op, ErrNotFound, err)
+				}
+				err = nil
+			}
+			delete(model, op.key)
+		case opGet:
+		case opRecover:
+			err = db.Recover()
+		case opReopen:
+			db.Close()
+			db, err = Open(fs, "db")
+		}
+		if err != nil {
+			return fmt.Errorf("step %d %v: %v", i, op, err)
+		}
+
+		keys := []string{op.key}
+		if op.kind == opRecover || op.kind == opReopen {
+			keys = keys[:0]
+			for k := 0; k < modelKeys; k++ {
+				keys = append(keys, fmt.Sprintf("key-%d", k))
+			}
+		}
+		for _, key := range keys {
+			if err := compare(key); err != nil {
+				return fmt.Errorf("step %d %v: %v", i, op, err)
+			}
+		}
+		if db.Stats().Keys != len(model) {
+			return fmt.Errorf("step %d %v: Stats().Keys = %d, expected %d", i, op, db.Stats().Keys, len(model))
+		}
+	}
+	return nil
+}
+
+// shrink removes operations from a failing sequence as long as it still fails,
+// first in chunks and then one by one.
+func shrink(ops []modelOp, fails func([]modelOp) bool) []modelOp {
+	for chunk := len(ops) / 2; chunk >= 1; chunk /= 2 {
+		for i := 0; i+chunk <= len(ops); {
+			candidate := append(append([]modelOp(nil), ops[:i]...), ops[i+chunk:]...)
+			if fails(candidate) {
+				ops = candidate
+				continue
+			}
+			i += chunk
+		}
+	}
+	return ops
+}
+
+func formatOps(ops []modelOp) string {
+	lines := make([]string, len(ops))
+	for i, op := range ops {
+		lines[i] = fmt.Sprintf("  %d: %v", i, op)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// TestModel compares the database with a map for random operation sequences.
+// A failing seed is reproduced with go test -run TestModel -model.seed=<seed>.
+func TestModel(t *testing.T) {
+	t.Parallel()
+	seeds := make([]int64, 0, *modelSeeds)
+	if *modelSeed != 0 {
+		seeds = append(seeds, *modelSeed)
+	} else {
+		for seed := int64(1); seed <= int64(*modelSeeds); seed++ {
+			seeds = append(seeds, seed)
+		}
+	}
+
+	for _, seed := range seeds {
+		ops := generateOps(rand.New(rand.NewSource(seed)), *modelSteps)
+		err := runModel(ops)
+		if err == nil {
+			continue
+		}
+		minimal := shrink(ops, func(ops []modelOp) bool { return runModel(ops) != nil })
+		t.Fatalf("seed %d: %v\nminimal reproducer (%v):\n%s", seed, err, runModel(minimal), formatOps(minimal))
+	}
+}
+
+func TestModelShrink(t *testing.T) {
+	t.Parallel()
+	ops := generateOps(rand.New(rand.NewSource(1)), 200)
+	ops = append(ops, modelOp{kind: opSet, key: "key-1", value: "bad"})
+	ops = append(ops, generateOps(rand.New(rand.NewSource(2)), 200)...)
+	// fails if the value bad is followed by a Recover
+	fails := func(ops []modelOp) bool {
+		bad := false
+		for _, op := range ops {
+			if op.kind == opSet && op.value == "bad" {
+				bad = true
+			}
+			if bad && op.kind == opRecover {
+				return true
+			}
+		}
+		return false
+	}
+	if !fails(ops) {
+		t.Fatalf("expected failing sequence")
+	}
+
+	minimal := shrink(ops, fails)
+	if len(minimal) != 2 || minimal[0].value != "bad" || minimal[1].kind != opRecover {
+		t.Fatalf("expected Set and Recover, got\n%s", formatOps(minimal))
+	}
+}
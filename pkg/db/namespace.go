@@ -0,0 +1,200 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// namespaceExt is the file extension of namespace logs.
+const namespaceExt = ".db"
+
+var validNamespace = regexp.MustCompile(`^[a-zA-Z0-9_-]{1,64}$`)
+
+var (
+	// ErrNamespaceNotFound is returned for namespaces which do not exist.
+	ErrNamespaceNotFound = errors.New("namespace does not exist")
+	// ErrNamespaceExists is returned when creating an existing namespace.
+	ErrNamespaceExists = errors.New("namespace already exists")
+)
+
+// Namespaces manages named databases with isolated keyspaces. Every namespace
+// has its own segmented log in a directory, with its own index and stats, which
+// is sealed and compacted on its own. Dropping a namespace removes its log, so
+// its space is reclaimed without rewriting the logs of the other namespaces.
+type Namespaces struct {
+	lock   sync.RWMutex
+	dir    string
+	dbs    map[string]*DB
+	quotas map[string][]Quota
+	limits Limits
+	sync   bool
+}
+
+// OpenNamespaces opens and recovers all namespaces in dir.
+func OpenNamespaces(dir string) (*Namespaces, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("could not create namespace directory %s: %v", dir, err)
+	}
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("could not read namespace directory %s: %v", dir, err)
+	}
+
+	n := &Namespaces{dir: dir, dbs: make(map[string]*DB), quotas: make(map[string][]Quota)}
+	for _, file := range files {
+		// a compacted log has no first segment, only its manifest
+		name := strings.TrimSuffix(file.Name(), manifestName(namespaceExt))
+		if name == file.Name() {
+			name = strings.TrimSuffix(file.Name(), namespaceExt)
+		}
+		if file.IsDir() || name == file.Name() || !validNamespace.MatchString(name) || n.dbs[name] != nil {
+			continue
+		}
+		db, err := n.open(name)
+		if err != nil {
+			n.Close()
+			return nil, err
+		}
+		n.dbs[name] = db
+	}
+	return n, nil
+}
+
+// filename returns the name of the log of the namespace.
+func (n *Namespaces) filename(name string) string {
+	return filepath.Join(n.dir, name+namespaceExt)
+}
+
+func (n *Namespaces) open(name string) (*DB, error) {
+	db, err := Open(OSFS{}, n.filename(name))
+	if err != nil {
+		return nil, fmt.Errorf("could not open namespace %s: %v", name, err)
+	}
+	db.SetQuotas(n.quotas[name])
+	db.SetLimits(n.limits)
+	db.SetSyncWrites(n.sync)
+	return db, nil
+}
+
+// SetQuotas sets the quotas of the named namespace, also if it is created later.
+func (n *Namespaces) SetQuotas(name string, quotas []Quota) {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+
+	n.quotas[name] = quotas
+	if db, ok := n.dbs[name]; ok {
+		db.SetQuotas(quotas)
+	}
+}
+
+// SetLimits sets the limits of all namespaces, also of those created later.
+func (n *Namespaces) SetLimits(limits Limits) {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+
+	n.limits = limits
+	for _, db := range n.dbs {
+		db.SetLimits(limits)
+	}
+}
+
+// SetSyncWrites sets the sync writes of all namespaces, also of those created
+// later, see DB.SetSyncWrites.
+func (n *Namespaces) SetSyncWrites(sync bool) {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+
+	n.sync = sync
+	for _, db := range n.dbs {
+		db.SetSyncWrites(sync)
+	}
+}
+
+// Namespace returns the database of the named namespace.
+func (n *Namespaces) Namespace(name string) (*DB, error) {
+	n.lock.RLock()
+	defer n.lock.RUnlock()
+
+	db, ok := n.dbs[name]
+	if !ok {
+		return nil, ErrNamespaceNotFound
+	}
+	return db, nil
+}
+
+// Create creates an empty namespace.
+func (n *Namespaces) Create(name string) (*DB, error) {
+	if !validNamespace.MatchString(name) {
+		return nil, fmt.Errorf("namespace %q must consist of 1 to 64 letters, digits, - or _", name)
+	}
+
+	n.lock.Lock()
+	defer n.lock.Unlock()
+
+	if _, ok := n.dbs[name]; ok {
+		return nil, ErrNamespaceExists
+	}
+	for _, filename := range []string{n.filename(name), manifestName(n.filename(name))} {
+		if _, err := os.Stat(filename); !errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("%w: file %s exists", ErrNamespaceExists, filename)
+		}
+	}
+	db, err := n.open(name)
+	if err != nil {
+		return nil, err
+	}
+	n.dbs[name] = db
+	return db, nil
+}
+
+// Drop closes the namespace and removes its log.
+func (n *Namespaces) Drop(name string) error {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+
+	db, ok := n.dbs[name]
+	if !ok {
+		return ErrNamespaceNotFound
+	}
+	delete(n.dbs, name)
+	db.Close()
+	if err := RemoveLog(OSFS{}, n.filename(name)); err != nil {
+		return fmt.Errorf("could not remove namespace %s: %v", name, err)
+	}
+	return nil
+}
+
+// List returns the sorted names of all namespaces.
+func (n *Namespaces) List() []string {
+	n.lock.RLock()
+	defer n.lock.RUnlock()
+
+	names := make([]string, 0, len(n.dbs))
+	for name := range n.dbs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Close closes all namespaces.
+func (n *Namespaces) Close() error {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+
+	var firstErr error
+	for name, db := range n.dbs {
+		if err := db.Close(); err != nil && firstErr == nil {
+			firstErr = fmt.Errorf("could not close namespace %s: %v", name, err)
+		}
+	}
+	n.dbs = make(map[string]*DB)
+	return firstErr
+}
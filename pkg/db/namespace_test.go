@@ -0,0 +1,174 @@
+package db
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/gerlacdt/db-key-value-store/pb"
+)
+
+func setupNamespaces(t *testing.T) (*Namespaces, string) {
+	t.Parallel()
+	dir, err := ioutil.TempDir("", "namespaces")
+	if err != nil {
+		t.Fatalf("error creating temp dir %v", err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	n, err := OpenNamespaces(dir)
+	if err != nil {
+		t.Fatalf("error opening namespaces %v", err)
+	}
+	return n, dir
+}
+
+func TestNamespacesIsolated(t *testing.T) {
+	n, _ := setupNamespaces(t)
+	defer n.Close()
+	teamA, err := n.Create("team-a")
+	if err != nil {
+		t.Fatalf("error creating namespace %v", err)
+	}
+	teamB, err := n.Create("team-b")
+	if err != nil {
+		t.Fatalf("error creating namespace %v", err)
+	}
+
+	err = teamA.Set(&pb.Entity{Key: "foo-key", Value: []byte("foo-value")})
+	if err != nil {
+		t.Fatalf("error SET %v", err)
+	}
+	entity, err := teamB.Get("foo-key")
+	if entity != nil || err != nil {
+		t.Fatalf("entity expected nil, got %v", entity)
+	}
+	if teamA.Stats().Keys != 1 || teamB.Stats().Keys != 0 {
+		t.Fatalf("keys expected 1 and 0, got %d and %d", teamA.Stats().Keys, teamB.Stats().Keys)
+	}
+	if _, err := n.Create("team-a"); err != ErrNamespaceExists {
+		t.Fatalf("expected %v, got %v", ErrNamespaceExists, err)
+	}
+	if _, err := n.Create("../etc"); err == nil {
+		t.Fatalf("expected error creating invalid namespace")
+	}
+}
+
+func TestNamespacesReopen(t *testing.T) {
+	n, dir := setupNamespaces(t)
+	teamA, err := n.Create("team-a")
+	if err != nil {
+		t.Fatalf("error creating namespace %v", err)
+	}
+	err = teamA.Set(&pb.Entity{Key: "foo-key", Value: []byte("foo-value")})
+	if err != nil {
+		t.Fatalf("error SET %v", err)
+	}
+	if err := n.Close(); err != nil {
+		t.Fatalf("error closing namespaces %v", err)
+	}
+
+	n, err = OpenNamespaces(dir)
+	if err != nil {
+		t.Fatalf("error opening namespaces %v", err)
+	}
+	defer n.Close()
+	if !reflect.DeepEqual([]string{"team-a"}, n.List()) {
+		t.Fatalf("expected %v, got %v", []string{"team-a"}, n.List())
+	}
+	teamA, err = n.Namespace("team-a")
+	if err != nil {
+		t.Fatalf("error getting namespace %v", err)
+	}
+	entity, err := teamA.Get("foo-key")
+	if err != nil || entity == nil || string(entity.Value) != "foo-value" {
+		t.Fatalf("expected foo-value, got %v %v", entity, err)
+	}
+}
+
+func TestNamespacesDrop(t *testing.T) {
+	n, dir := setupNamespaces(t)
+	defer n.Close()
+	teamA, err := n.Create("team-a")
+	if err != nil {
+		t.Fatalf("error creating namespace %v", err)
+	}
+	teamB, err := n.Create("team-b")
+	if err != nil {
+		t.Fatalf("error creating namespace %v", err)
+	}
+	err = teamB.Set(&pb.Entity{Key: "foo-key", Value: []byte("foo-value")})
+	if err != nil {
+		t.Fatalf("error SET %v", err)
+	}
+
+	if err := n.Drop("team-a"); err != nil {
+		t.Fatalf("error dropping namespace %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "team-a.db")); !os.IsNotExist(err) {
+		t.Fatalf("expected removed namespace file, got %v", err)
+	}
+	if _, err := n.Namespace("team-a"); err != ErrNamespaceNotFound {
+		t.Fatalf("expected %v, got %v", ErrNamespaceNotFound, err)
+	}
+	if err := teamA.Set(&pb.Entity{Key: "foo-key"}); err != ErrClosed {
+		t.Fatalf("expected %v, got %v", ErrClosed, err)
+	}
+	entity, err := teamB.Get("foo-key")
+	if err != nil || entity == nil {
+		t.Fatalf("expected foo-key in team-b, got %v %v", entity, err)
+	}
+}
+
+func TestNamespacesSegments(t *testing.T) {
+	n, dir := setupNamespaces(t)
+	n.SetSyncWrites(true)
+	teamA, err := n.Create("team-a")
+	if err != nil {
+		t.Fatalf("error creating namespace %v", err)
+	}
+	if !teamA.sync {
+		t.Fatalf("sync writes expected for a namespace created later")
+	}
+	for _, value := range []string{"v1", "v2"} {
+		if err := teamA.Set(&pb.Entity{Key: "foo-key", Value: []byte(value)}); err != nil {
+			t.Fatalf("error SET %v", err)
+		}
+	}
+	if _, err := teamA.Compact(); err != nil {
+		t.Fatalf("error compacting %v", err)
+	}
+	if err := n.Close(); err != nil {
+		t.Fatalf("error closing namespaces %v", err)
+	}
+
+	// the compacted log has no first segment
+	if _, err := os.Stat(filepath.Join(dir, "team-a.db")); !os.IsNotExist(err) {
+		t.Fatalf("expected compacted first segment, got %v", err)
+	}
+	n, err = OpenNamespaces(dir)
+	if err != nil {
+		t.Fatalf("error opening namespaces %v", err)
+	}
+	defer n.Close()
+	if !reflect.DeepEqual([]string{"team-a"}, n.List()) {
+		t.Fatalf("expected %v, got %v", []string{"team-a"}, n.List())
+	}
+	teamA, err = n.Namespace("team-a")
+	if err != nil {
+		t.Fatalf("error getting namespace %v", err)
+	}
+	entity, err := teamA.Get("foo-key")
+	if err != nil || entity == nil || string(entity.Value) != "v2" {
+		t.Fatalf("expected v2, got %v %v", entity, err)
+	}
+
+	if err := n.Drop("team-a"); err != nil {
+		t.Fatalf("error dropping namespace %v", err)
+	}
+	files, err := ioutil.ReadDir(dir)
+	if err != nil || len(files) != 0 {
+		t.Fatalf("expected empty directory, got %v %v", files, err)
+	}
+}
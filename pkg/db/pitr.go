@@ -0,0 +1,84 @@
+package db
+
+import (
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/gerlacdt/db-key-value-store/pb"
+)
+
+// Point in the history of the log. Zero fields are not used as a limit. A time
+// limits the history to the records before the first record committed later,
+// commit times are assumed to grow with the sequence numbers, so a clock which
+// goes backwards ends the history early.
+type Point struct {
+	Seq  uint64    // last sequence number to include
+	Time time.Time // last commit time to include
+}
+
+// before reports if the point is before the point q, a limit of the point is
+// earlier than the limit of q.
+func (p Point) before(q Point) bool {
+	return (p.Seq != 0 && p.Seq < q.Seq) || (!p.Time.IsZero() && p.Time.Before(q.Time))
+}
+
+// includes reports if the entity was committed at or before the point.
+func (p Point) includes(entity *pb.Entity) bool {
+	if p.Seq != 0 && entity.Seq > p.Seq {
+		return false
+	}
+	if !p.Time.IsZero() && entity.Timestamp > p.Time.UnixNano() {
+		return false
+	}
+	return true
+}
+
+// RecoverTo rebuilds the database as it was at the given point. All records
+// committed at or before the point are copied into the empty file dst, the
+// log of db is left untouched. The returned database is backed by dst. Points
+// before the last compaction fail with ErrHistoryCompacted.
+func (db *DB) RecoverTo(dst io.ReadWriteSeeker, p Point) (*DB, error) {
+	if p.Seq == 0 && p.Time.IsZero() {
+		return nil, fmt.Errorf("point in time recovery needs a sequence number or a time")
+	}
+	db.lock.RLock()
+	compacted := db.compacted
+	db.lock.RUnlock()
+	if p.before(compacted) {
+		return nil, fmt.Errorf("%w: compacted up to sequence number %d at %s", ErrHistoryCompacted,
+			compacted.Seq, compacted.Time.UTC().Format(time.RFC3339Nano))
+	}
+	snap, err := db.Snapshot()
+	if err != nil {
+		return nil, err
+	}
+
+	// records are appended in commit order, so the state at the point is a log prefix
+	cut := int64(0)
+	for cut < snap.Size {
+		db.lock.RLock()
+		entity, n, err := db.readRecord(cut)
+		if db.compactions != snap.compactions {
+			err = ErrCompacted
+		}
+		db.lock.RUnlock()
+		if err != nil {
+			return nil, fmt.Errorf("read record at offset %d: %v", cut, err)
+		}
+		if !p.includes(entity) {
+			break
+		}
+		cut += n
+	}
+
+	snap.Size = cut
+	if _, err := snap.WriteTo(dst); err != nil {
+		return nil, err
+	}
+	recovered := New(dst)
+	if err := recovered.Recover(); err != nil {
+		return nil, fmt.Errorf("could not recover database: %v", err)
+	}
+	return recovered, nil
+}
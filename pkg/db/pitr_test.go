@@ -0,0 +1,150 @@
+package db
+
+import (
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/gerlacdt/db-key-value-store/pb"
+	"github.com/mattetti/filebuffer"
+)
+
+func TestSeqAndTimestamp(t *testing.T) {
+	db := setup(t)
+	before := time.Now().UnixNano()
+	entity := &pb.Entity{Key: "foo-key", Value: []byte("foo-value")}
+	if err := db.Set(entity); err != nil {
+		t.Fatalf("error SET %v", err)
+	}
+	if err := db.Delete("foo-key"); err != nil {
+		t.Fatalf("error DELETE %v", err)
+	}
+
+	if entity.Seq != 1 {
+		t.Fatalf("seq expected %d, got %d", 1, entity.Seq)
+	}
+	if entity.Timestamp < before {
+		t.Fatalf("timestamp expected after %d, got %d", before, entity.Timestamp)
+	}
+	if db.Seq() != 2 {
+		t.Fatalf("seq expected %d, got %d", 2, db.Seq())
+	}
+
+	// sequence numbers continue after recovering
+	recovered := New(db.f)
+	if err := recovered.Recover(); err != nil {
+		t.Fatalf("error recovering %v", err)
+	}
+	if recovered.Seq() != 2 {
+		t.Fatalf("seq expected %d, got %d", 2, recovered.Seq())
+	}
+}
+
+func TestRecoverToSeq(t *testing.T) {
+	db := setup(t)
+	for i := 1; i <= 10; i++ {
+		err := db.Set(&pb.Entity{Key: "foo-key", Value: []byte("foo-value-" + strconv.Itoa(i))})
+		if err != nil {
+			t.Fatalf("error SET %v", err)
+		}
+	}
+	if err := db.Delete("foo-key"); err != nil {
+		t.Fatalf("error DELETE %v", err)
+	}
+
+	recovered, err := db.RecoverTo(filebuffer.New(nil), Point{Seq: 5})
+	if err != nil {
+		t.Fatalf("error recovering to seq %v", err)
+	}
+	entity, err := recovered.Get("foo-key")
+	if err != nil {
+		t.Fatalf("error getting entity %v", err)
+	}
+	if entity == nil || string(entity.Value) != "foo-value-5" {
+		t.Fatalf("value expected %s, got %v", "foo-value-5", entity)
+	}
+	if recovered.Seq() != 5 {
+		t.Fatalf("seq expected %d, got %d", 5, recovered.Seq())
+	}
+
+	// the original database is untouched
+	entity, err = db.Get("foo-key")
+	if entity != nil || err != nil {
+		t.Fatalf("entity expected nil, got %v", entity)
+	}
+}
+
+func TestRecoverToTime(t *testing.T) {
+	db := setup(t)
+	first := &pb.Entity{Key: "foo-key", Value: []byte("foo-value")}
+	if err := db.Set(first); err != nil {
+		t.Fatalf("error SET %v", err)
+	}
+	second := &pb.Entity{Key: "bar-key", Value: []byte("bar-value")}
+	if err := db.Set(second); err != nil {
+		t.Fatalf("error SET %v", err)
+	}
+
+	recovered, err := db.RecoverTo(filebuffer.New(nil), Point{Time: time.Unix(0, first.Timestamp)})
+	if err != nil {
+		t.Fatalf("error recovering to time %v", err)
+	}
+	entity, err := recovered.Get("foo-key")
+	if err != nil || entity == nil {
+		t.Fatalf("entity expected %v, got %v", first, entity)
+	}
+	if second.Timestamp > first.Timestamp {
+		entity, err = recovered.Get("bar-key")
+		if entity != nil || err != nil {
+			t.Fatalf("entity expected nil, got %v", entity)
+		}
+	}
+}
+
+func TestRecoverToAfterCompaction(t *testing.T) {
+	t.Parallel()
+	mem := NewMemFS()
+	db, err := Open(mem, "db")
+	if err != nil {
+		t.Fatalf("error opening %v", err)
+	}
+	for _, kv := range [][2]string{{"a", "1"}, {"a", "2"}, {"b", "1"}} {
+		if err := db.Set(&pb.Entity{Key: kv[0], Value: []byte(kv[1])}); err != nil {
+			t.Fatalf("error SET %v", err)
+		}
+	}
+	before := time.Now()
+	if _, err := db.Compact(); err != nil {
+		t.Fatalf("error compacting %v", err)
+	}
+	if err := db.Set(&pb.Entity{Key: "c", Value: []byte("1")}); err != nil {
+		t.Fatalf("error SET %v", err)
+	}
+
+	// the overwritten a=1 is compacted, also after a reopen
+	for i := 0; i < 2; i++ {
+		for _, p := range []Point{{Seq: 1}, {Time: before}, {Seq: 4, Time: before}} {
+			if _, err := db.RecoverTo(filebuffer.New(nil), p); !errors.Is(err, ErrHistoryCompacted) {
+				t.Fatalf("point %+v: expected %v, got %v", p, ErrHistoryCompacted, err)
+			}
+		}
+		recovered, err := db.RecoverTo(filebuffer.New(nil), Point{Seq: 3})
+		if err != nil {
+			t.Fatalf("error recovering %v", err)
+		}
+		for key, value := range map[string]string{"a": "2", "b": "1", "c": ""} {
+			entity, err := recovered.Get(key)
+			if err != nil || (value == "") != (entity == nil) || (entity != nil && string(entity.Value) != value) {
+				t.Fatalf("key %s: expected %q, got %v %v", key, value, entity, err)
+			}
+		}
+
+		db.Close()
+		db, err = Open(mem, "db")
+		if err != nil {
+			t.Fatalf("error opening %v", err)
+		}
+	}
+	db.Close()
+}
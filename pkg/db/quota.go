@@ -0,0 +1,183 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/gerlacdt/db-key-value-store/pb"
+	"github.com/golang/protobuf/proto"
+)
+
+var (
+	// ErrQuotaExceeded is returned for writes exceeding a storage limit.
+	ErrQuotaExceeded = errors.New("quota exceeded")
+	// ErrRateLimited is returned for writes exceeding the write rate limit.
+	ErrRateLimited = errors.New("write rate limit exceeded")
+)
+
+// QuotaError describes the violated limit of a quota.
+type QuotaError struct {
+	Prefix string
+	Limit  string
+	Err    error // ErrQuotaExceeded or ErrRateLimited
+}
+
+func (e *QuotaError) Error() string {
+	return fmt.Sprintf("%v for prefix %q: %s", e.Err, e.Prefix, e.Limit)
+}
+
+// Unwrap returns ErrQuotaExceeded or ErrRateLimited.
+func (e *QuotaError) Unwrap() error { return e.Err }
+
+// Quota limits the keys under a prefix, an empty prefix limits the whole
+// database. Zero limits are not enforced.
+type Quota struct {
+	Prefix          string  `json:"prefix"`
+	MaxKeys         int     `json:"maxKeys,omitempty"`
+	MaxLiveBytes    int64   `json:"maxLiveBytes,omitempty"`
+	MaxValueSize    int     `json:"maxValueSize,omitempty"`
+	WritesPerSecond float64 `json:"writesPerSecond,omitempty"` // Set and Delete operations
+}
+
+// QuotaUsage is the current usage of a quota.
+type QuotaUsage struct {
+	Quota
+	Keys      int   `json:"keys"`
+	LiveBytes int64 `json:"liveBytes"`
+}
+
+// quotaState tracks the usage of a quota and the token bucket of its rate
+// limit.
+type quotaState struct {
+	QuotaUsage
+	tokens float64
+	last   time.Time
+}
+
+// SetQuotas replaces the quotas of the database. The usage is computed from
+// the live keyspace.
+func (db *DB) SetQuotas(quotas []Quota) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	now := time.Now()
+	db.quotas = make([]*quotaState, 0, len(quotas))
+	for _, q := range quotas {
+		qs := &quotaState{QuotaUsage: QuotaUsage{Quota: q}, last: now}
+		qs.tokens = qs.burst()
+		for key, e := range db.index {
+			if strings.HasPrefix(key, q.Prefix) && !isLease(key) {
+				qs.add(e)
+			}
+		}
+		db.quotas = append(db.quotas, qs)
+	}
+}
+
+// QuotaUsage returns the quotas of the database with their current usage.
+func (db *DB) QuotaUsage() []QuotaUsage {
+	db.lock.RLock()
+	defer db.lock.RUnlock()
+
+	usage := make([]QuotaUsage, 0, len(db.quotas))
+	for _, qs := range db.quotas {
+		usage = append(usage, qs.QuotaUsage)
+	}
+	return usage
+}
+
+// burst is the size of the token bucket, bursts of up to one second of writes
+// are accepted.
+func (qs *quotaState) burst() float64 {
+	if qs.WritesPerSecond < 1 {
+		return 1
+	}
+	return qs.WritesPerSecond
+}
+
+func (qs *quotaState) add(e entry) {
+	if !e.tombstone {
+		qs.Keys++
+		qs.LiveBytes += e.size
+	}
+}
+
+func (qs *quotaState) remove(e entry) {
+	if !e.tombstone {
+		qs.Keys--
+		qs.LiveBytes -= e.size
+	}
+}
+
+// updateQuotas maintains the usage of the quotas matching the key. The caller
+// must hold the write lock.
+func (db *DB) updateQuotas(key string, old entry, existed bool, e entry) {
+	if isLease(key) {
+		return
+	}
+	for _, qs := range db.quotas {
+		if !strings.HasPrefix(key, qs.Prefix) {
+			continue
+		}
+		if existed {
+			qs.remove(old)
+		}
+		qs.add(e)
+	}
+}
+
+// checkQuotas returns a QuotaError if committing the entity violates a quota
+// and takes a token from the rate limits otherwise. Writes which do not
+// increase the usage are always accepted by the storage limits. The caller
+// must hold the write lock.
+func (db *DB) checkQuotas(entity *pb.Entity) error {
+	if len(db.quotas) == 0 || isLease(entity.Key) {
+		return nil
+	}
+	size := int64(proto.Size(entity)) + 8
+	old, existed := db.index[entity.Key]
+	oldLive := existed && !old.tombstone
+
+	var limited []*quotaState
+	for _, qs := range db.quotas {
+		if !strings.HasPrefix(entity.Key, qs.Prefix) {
+			continue
+		}
+		if !entity.Tombstone {
+			if qs.MaxValueSize > 0 && len(entity.Value) > qs.MaxValueSize {
+				return &QuotaError{qs.Prefix, fmt.Sprintf("value size %d > %d", len(entity.Value), qs.MaxValueSize), ErrQuotaExceeded}
+			}
+			if qs.MaxKeys > 0 && !oldLive && qs.Keys+1 > qs.MaxKeys {
+				return &QuotaError{qs.Prefix, fmt.Sprintf("max keys %d", qs.MaxKeys), ErrQuotaExceeded}
+			}
+			liveBytes := qs.LiveBytes + size
+			if oldLive {
+				liveBytes -= old.size
+			}
+			if qs.MaxLiveBytes > 0 && liveBytes > qs.MaxLiveBytes && liveBytes > qs.LiveBytes {
+				return &QuotaError{qs.Prefix, fmt.Sprintf("max live bytes %d", qs.MaxLiveBytes), ErrQuotaExceeded}
+			}
+		}
+		if qs.WritesPerSecond > 0 {
+			limited = append(limited, qs)
+		}
+	}
+
+	now := time.Now()
+	for _, qs := range limited {
+		qs.tokens += now.Sub(qs.last).Seconds() * qs.WritesPerSecond
+		qs.last = now
+		if burst := qs.burst(); qs.tokens > burst {
+			qs.tokens = burst
+		}
+		if qs.tokens < 1 {
+			return &QuotaError{qs.Prefix, fmt.Sprintf("%g writes per second", qs.WritesPerSecond), ErrRateLimited}
+		}
+	}
+	for _, qs := range limited {
+		qs.tokens--
+	}
+	return nil
+}
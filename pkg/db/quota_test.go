@@ -0,0 +1,98 @@
+package db
+
+import (
+	"errors"
+	"strconv"
+	"testing"
+
+	"github.com/gerlacdt/db-key-value-store/pb"
+)
+
+func TestQuotaMaxKeys(t *testing.T) {
+	db := setup(t)
+	db.SetQuotas([]Quota{{Prefix: "user-", MaxKeys: 2}})
+	for i := 0; i < 2; i++ {
+		err := db.Set(&pb.Entity{Key: "user-" + strconv.Itoa(i), Value: []byte("value")})
+		if err != nil {
+			t.Fatalf("error SET %v", err)
+		}
+	}
+
+	rejected := &pb.Entity{Key: "user-2", Value: []byte("value")}
+	err := db.Set(rejected)
+	if !errors.Is(err, ErrQuotaExceeded) {
+		t.Fatalf("expected %v, got %v", ErrQuotaExceeded, err)
+	}
+	if rejected.Seq != 0 || rejected.Timestamp != 0 {
+		t.Fatalf("rejected entity has seq %d and timestamp %d", rejected.Seq, rejected.Timestamp)
+	}
+	// overwrites and other prefixes are accepted
+	if err := db.Set(&pb.Entity{Key: "user-1", Value: []byte("new-value")}); err != nil {
+		t.Fatalf("error SET %v", err)
+	}
+	if err := db.Set(&pb.Entity{Key: "other-1", Value: []byte("value")}); err != nil {
+		t.Fatalf("error SET %v", err)
+	}
+	// deletes free the quota
+	if err := db.Delete("user-0"); err != nil {
+		t.Fatalf("error DELETE %v", err)
+	}
+	if err := db.Set(&pb.Entity{Key: "user-2", Value: []byte("value")}); err != nil {
+		t.Fatalf("error SET %v", err)
+	}
+
+	usage := db.QuotaUsage()
+	if len(usage) != 1 || usage[0].Keys != 2 {
+		t.Fatalf("unexpected usage %+v", usage)
+	}
+}
+
+func TestQuotaBytes(t *testing.T) {
+	db := setup(t)
+	db.SetQuotas([]Quota{{MaxValueSize: 10, MaxLiveBytes: 100}})
+
+	err := db.Set(&pb.Entity{Key: "foo-key", Value: []byte("value larger than 10 bytes")})
+	var qerr *QuotaError
+	if !errors.As(err, &qerr) || qerr.Err != ErrQuotaExceeded {
+		t.Fatalf("expected quota error, got %v", err)
+	}
+
+	var i int
+	for i = 0; i < 100; i++ {
+		err = db.Set(&pb.Entity{Key: "foo-key-" + strconv.Itoa(i), Value: []byte("value")})
+		if err != nil {
+			break
+		}
+	}
+	if !errors.Is(err, ErrQuotaExceeded) {
+		t.Fatalf("expected %v, got %v", ErrQuotaExceeded, err)
+	}
+	usage := db.QuotaUsage()
+	if usage[0].LiveBytes > 100 || usage[0].Keys != i {
+		t.Fatalf("unexpected usage %+v after %d keys", usage, i)
+	}
+
+	// usage is rebuilt by recover
+	if err := db.Recover(); err != nil {
+		t.Fatalf("error recovering %v", err)
+	}
+	if db.QuotaUsage()[0] != usage[0] {
+		t.Fatalf("expected %+v, got %+v", usage[0], db.QuotaUsage()[0])
+	}
+}
+
+func TestQuotaRateLimit(t *testing.T) {
+	db := setup(t)
+	db.SetQuotas([]Quota{{Prefix: "foo-", WritesPerSecond: 5}})
+
+	var err error
+	for i := 0; i < 10 && err == nil; i++ {
+		err = db.Set(&pb.Entity{Key: "foo-key", Value: []byte("value")})
+	}
+	if !errors.Is(err, ErrRateLimited) {
+		t.Fatalf("expected %v, got %v", ErrRateLimited, err)
+	}
+	if err := db.Set(&pb.Entity{Key: "bar-key", Value: []byte("value")}); err != nil {
+		t.Fatalf("error SET %v", err)
+	}
+}
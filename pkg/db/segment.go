@@ -0,0 +1,513 @@
+package db
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"time"
+
+	"github.com/gerlacdt/db-key-value-store/pb"
+	"github.com/golang/protobuf/proto"
+)
+
+var (
+	// ErrNoSegments is returned by Seal and Compact for databases which were
+	// not opened with Open.
+	ErrNoSegments = errors.New("segments require a database opened with Open")
+	// ErrCompactionPaused is returned by Compact while compaction is paused.
+	ErrCompactionPaused = errors.New("compaction is paused")
+	// ErrCompactionRunning is returned by Compact while another compaction runs.
+	ErrCompactionRunning = errors.New("compaction is already running")
+	// ErrCompacted is returned by reads of a snapshot which was taken before a
+	// compaction, the read can be retried.
+	ErrCompacted = errors.New("log compacted during the read")
+	// ErrHistoryCompacted is returned by RecoverTo for points before the last
+	// compaction, whose history is lost.
+	ErrHistoryCompacted = errors.New("history before the point was compacted")
+)
+
+// segment is a sealed part of the log. Sealed segments are never written
+// again, so they are read without holding the lock.
+type segment struct {
+	id   int
+	f    File
+	base int64 // offset of the segment in the log
+	size int64
+}
+
+// segmentName returns the file name of a segment, the first segment of a log
+// is the named file itself.
+func segmentName(name string, id int) string {
+	if id == 0 {
+		return name
+	}
+	return fmt.Sprintf("%s.%06d", name, id)
+}
+
+// manifestName is the file which lists the segments of a log in order, the
+// last one is the active segment. A log without manifest has only the active
+// segment named like the log.
+func manifestName(name string) string {
+	return name + ".segments"
+}
+
+// manifest lists the segments of a log. Seq is the sequence number of the
+// last commit when the manifest was written, compaction drops tombstones and
+// overwritten records, which may have had the highest sequence numbers.
+// CompactedSeq and CompactedAt (in unix nanoseconds) are the last commit and
+// the time of the last compaction, the history before is lost.
+type manifest struct {
+	Segments     []int  `json:"segments"`
+	Seq          uint64 `json:"seq,omitempty"`
+	CompactedSeq uint64 `json:"compactedSeq,omitempty"`
+	CompactedAt  int64  `json:"compactedAt,omitempty"`
+}
+
+// manifest returns the manifest of the segments. The caller must hold the
+// write lock.
+func (db *DB) manifest(ids []int) manifest {
+	m := manifest{Segments: ids, Seq: db.seq, CompactedSeq: db.compacted.Seq}
+	if !db.compacted.Time.IsZero() {
+		m.CompactedAt = db.compacted.Time.UnixNano()
+	}
+	return m
+}
+
+func readManifest(fs FS, name string) (manifest, error) {
+	f, err := fs.OpenFile(manifestName(name), os.O_RDONLY, 0)
+	if errors.Is(err, os.ErrNotExist) {
+		return manifest{Segments: []int{0}}, nil
+	}
+	if err != nil {
+		return manifest{}, fmt.Errorf("could not open manifest: %v", err)
+	}
+	defer f.Close()
+	data, err := ioutil.ReadAll(f)
+	if err != nil {
+		return manifest{}, fmt.Errorf("could not read manifest: %v", err)
+	}
+	var m manifest
+	if err := json.Unmarshal(data, &m); err != nil || len(m.Segments) == 0 {
+		return manifest{}, fmt.Errorf("%w: invalid manifest %s", ErrCorrupt, manifestName(name))
+	}
+	return m, nil
+}
+
+// writeManifest replaces the manifest atomically, the rename is the commit
+// point of Seal and Compact.
+func writeManifest(fs FS, name string, m manifest) error {
+	data, err := json.Marshal(m)
+	if err != nil {
+		return fmt.Errorf("could not encode manifest: %v", err)
+	}
+	tmp := manifestName(name) + ".tmp"
+	f, err := fs.OpenFile(tmp, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return fmt.Errorf("could not create manifest: %v", err)
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return fmt.Errorf("could not write manifest: %v", err)
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return fmt.Errorf("could not sync manifest: %v", err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("could not close manifest: %v", err)
+	}
+	if err := fs.Rename(tmp, manifestName(name)); err != nil {
+		return fmt.Errorf("could not rename manifest: %v", err)
+	}
+	return nil
+}
+
+// RemoveLog removes all segments and the manifest of the named log.
+func RemoveLog(fs FS, name string) error {
+	m, err := readManifest(fs, name)
+	if err != nil {
+		return err
+	}
+	for _, id := range m.Segments {
+		if err := fs.Remove(segmentName(name, id)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("could not remove segment: %v", err)
+		}
+	}
+	if err := fs.Remove(manifestName(name)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("could not remove manifest: %v", err)
+	}
+	return nil
+}
+
+// closeSegments closes the files of the sealed segments.
+func (db *DB) closeSegments() {
+	for _, s := range db.segments {
+		s.f.Close()
+	}
+}
+
+// segmentAt returns the sealed segment which contains the offset. The caller
+// must hold the lock.
+func (db *DB) segmentAt(offset int64) *segment {
+	i, j := 0, len(db.segments)
+	for i+1 < j {
+		h := (i + j) / 2
+		if db.segments[h].base <= offset {
+			i = h
+		} else {
+			j = h
+		}
+	}
+	return db.segments[i]
+}
+
+// end returns the end of the log including records which were written
+// without a commit, e.g. by a failed write. The caller must hold the lock.
+func (db *DB) end() (int64, error) {
+	size, err := db.f.Seek(0, io.SeekEnd)
+	if err != nil {
+		return 0, fmt.Errorf("file seek error %v", err)
+	}
+	return db.base + size, nil
+}
+
+// Seal closes the active segment for writes and continues the log in a new
+// segment. Sealed segments are immutable and can be compacted. An empty active
+// segment is not sealed.
+func (db *DB) Seal() error {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	if db.closed {
+		return ErrClosed
+	}
+	if db.fs == nil {
+		return ErrNoSegments
+	}
+	return db.seal()
+}
+
+// seal is Seal, the caller must hold the write lock.
+func (db *DB) seal() error {
+	if db.readOnlyFS {
+		return fmt.Errorf("%w, opened read-only", ErrReadOnly)
+	}
+	if db.err != nil {
+		return fmt.Errorf("%w, recover required after a failed write: %v", ErrReadOnly, db.err)
+	}
+	if db.size == db.base {
+		return nil
+	}
+	if err := db.file.Sync(); err != nil {
+		return fmt.Errorf("could not sync segment: %v", err)
+	}
+	id := db.nextID
+	name := segmentName(db.name, id)
+	f, err := db.fs.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return fmt.Errorf("could not create segment: %v", err)
+	}
+	ids := make([]int, 0, len(db.segments)+2)
+	for _, s := range db.segments {
+		ids = append(ids, s.id)
+	}
+	if err := writeManifest(db.fs, db.name, db.manifest(append(ids, db.activeID, id))); err != nil {
+		f.Close()
+		db.fs.Remove(name)
+		return err
+	}
+	db.segments = append(db.segments, &segment{id: db.activeID, f: db.file, base: db.base, size: db.size - db.base})
+	db.f, db.file = f, f
+	db.activeID, db.nextID = id, id+1
+	db.base = db.size
+	return nil
+}
+
+// compactionState is guarded by the lock of the database.
+type compactionState struct {
+	running   bool
+	paused    bool
+	last      time.Time
+	duration  time.Duration
+	reclaimed int64 // bytes reclaimed by the last compaction
+}
+
+// CompactionResult describes a completed compaction.
+type CompactionResult struct {
+	Segments       int   `json:"segments"` // compacted segments
+	Records        int   `json:"records"`  // copied live records
+	ReclaimedBytes int64 `json:"reclaimedBytes"`
+}
+
+// Compact seals the active segment and rewrites all sealed segments into a
+// single segment with only the latest records of live keys. Overwritten
+// records and tombstones are dropped, so the history before the compaction is
+// lost for subscriptions and point in time recovery. The database keeps
+// accepting reads and writes while the segments are copied.
+func (db *DB) Compact() (CompactionResult, error) {
+	start := time.Now()
+	db.lock.Lock()
+	var err error
+	switch {
+	case db.closed:
+		err = ErrClosed
+	case db.fs == nil:
+		err = ErrNoSegments
+	case db.compaction.paused:
+		err = ErrCompactionPaused
+	case db.compaction.running:
+		err = ErrCompactionRunning
+	default:
+		// all records written so far are compacted
+		err = db.seal()
+	}
+	if err != nil || len(db.segments) == 0 {
+		db.lock.Unlock()
+		return CompactionResult{}, err
+	}
+	segs := make([]segment, len(db.segments))
+	for i, s := range db.segments {
+		segs[i] = *s
+	}
+	id := db.nextID
+	db.nextID++
+	db.compaction.running = true
+	db.lock.Unlock()
+
+	out, size, moved, err := db.copyLive(segs, id)
+
+	db.lock.Lock()
+	defer db.lock.Unlock()
+	db.compaction.running = false
+	if err == nil && db.closed {
+		err = ErrClosed
+	}
+	if err == nil {
+		err = db.replaceSegments(len(segs), &segment{id: id, f: out, size: size}, moved)
+	}
+	if err != nil {
+		if out != nil {
+			out.Close()
+			db.fs.Remove(segmentName(db.name, id))
+		}
+		return CompactionResult{}, err
+	}
+
+	last := segs[len(segs)-1]
+	result := CompactionResult{Segments: len(segs), Records: len(moved), ReclaimedBytes: last.base + last.size - size}
+	db.compactions++
+	db.compaction.last = start
+	db.compaction.duration = time.Since(start)
+	db.compaction.reclaimed = result.ReclaimedBytes
+	return result, nil
+}
+
+// copyLive copies the live records of the sealed segments into the new
+// segment id. It returns the new segment with its size and the new offsets of
+// the copied records by their old offset.
+func (db *DB) copyLive(segs []segment, id int) (File, int64, map[int64]int64, error) {
+	out, err := db.fs.OpenFile(segmentName(db.name, id), os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return nil, 0, nil, fmt.Errorf("could not create segment: %v", err)
+	}
+	w := bufio.NewWriter(out)
+	moved := make(map[int64]int64)
+	written := int64(0)
+	for _, s := range segs {
+		r := bufio.NewReader(io.NewSectionReader(s.f, 0, s.size))
+		for local := int64(0); local < s.size; {
+			record, entity, err := readRawRecord(r)
+			if err != nil {
+				return out, 0, nil, fmt.Errorf("segment %s at offset %d: %w", segmentName(db.name, s.id), local, err)
+			}
+			offset := s.base + local
+			local += int64(len(record))
+
+			db.lock.RLock()
+			e, ok := db.index[entity.Key]
+			paused, closed := db.compaction.paused, db.closed
+			db.lock.RUnlock()
+			switch {
+			case closed:
+				return out, 0, nil, ErrClosed
+			case paused:
+				return out, 0, nil, ErrCompactionPaused
+			case !ok || e.offset != offset || entity.Tombstone:
+				// overwritten or deleted
+				continue
+			}
+			if _, err := w.Write(record); err != nil {
+				return out, 0, nil, fmt.Errorf("could not write segment: %v", err)
+			}
+			moved[offset] = written
+			written += int64(len(record))
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return out, 0, nil, fmt.Errorf("could not write segment: %v", err)
+	}
+	if err := out.Sync(); err != nil {
+		return out, 0, nil, fmt.Errorf("could not sync segment: %v", err)
+	}
+	return out, written, moved, nil
+}
+
+// readRawRecord reads the next record and returns it as written in the log.
+func readRawRecord(r io.Reader) ([]byte, *pb.Entity, error) {
+	header := make([]byte, 8)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, nil, fmt.Errorf("read size error, %v", err)
+	}
+	size := binary.LittleEndian.Uint64(header)
+	if size > MaxRecordSize {
+		return nil, nil, fmt.Errorf("%w: record size %d", ErrCorrupt, size)
+	}
+	record := make([]byte, 8+size)
+	copy(record, header)
+	if _, err := io.ReadFull(r, record[8:]); err != nil {
+		return nil, nil, fmt.Errorf("key readData error, %v", err)
+	}
+	entity := &pb.Entity{}
+	if err := proto.Unmarshal(record[8:], entity); err != nil {
+		return nil, nil, fmt.Errorf("%w: proto unmarshal error %v", ErrCorrupt, err)
+	}
+	return record, entity, nil
+}
+
+// replaceSegments replaces the first n sealed segments by the compacted
+// segment and moves the index to the new offsets. The caller must hold the
+// write lock.
+func (db *DB) replaceSegments(n int, compacted *segment, moved map[int64]int64) error {
+	ids := []int{compacted.id}
+	for _, s := range db.segments[n:] {
+		ids = append(ids, s.id)
+	}
+	previous := db.compacted
+	db.compacted = Point{Seq: db.seq, Time: time.Now()}
+	if err := writeManifest(db.fs, db.name, db.manifest(append(ids, db.activeID))); err != nil {
+		db.compacted = previous
+		return err
+	}
+
+	old := db.segments[:n]
+	end := old[n-1].base + old[n-1].size
+	delta := compacted.size - end
+	segments := []*segment{compacted}
+	for _, s := range db.segments[n:] {
+		s.base += delta
+		segments = append(segments, s)
+	}
+	db.segments = segments
+	db.base += delta
+	db.size += delta
+	for k, e := range db.index {
+		if e.offset >= end {
+			e.offset += delta
+			db.index[k] = e
+			continue
+		}
+		if offset, ok := moved[e.offset]; ok {
+			e.offset = offset
+			db.index[k] = e
+			continue
+		}
+		// a tombstone, all older records of the key were compacted too
+		db.counters.remove(e)
+		delete(db.index, k)
+	}
+
+	for _, s := range old {
+		s.f.Close()
+		if err := db.fs.Remove(segmentName(db.name, s.id)); err != nil {
+			log.Printf("could not remove compacted segment: %v", err)
+		}
+	}
+	return nil
+}
+
+// SetCompactionPaused pauses or resumes compaction. Pausing aborts a running
+// compaction, Compact fails with ErrCompactionPaused until it is resumed.
+func (db *DB) SetCompactionPaused(paused bool) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+	db.compaction.paused = paused
+}
+
+// SetReadOnly switches the database into or out of the read-only maintenance
+// mode, in which all writes fail with ErrReadOnly.
+func (db *DB) SetReadOnly(readOnly bool) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+	db.readOnly = readOnly
+}
+
+// SegmentInfo describes a segment of the log.
+type SegmentInfo struct {
+	Name   string `json:"name"`
+	Offset int64  `json:"offset"` // offset in the log
+	Size   int64  `json:"size"`
+	Sealed bool   `json:"sealed"`
+}
+
+// CompactionStatus describes the state of compaction.
+type CompactionStatus struct {
+	Running        bool      `json:"running"`
+	Paused         bool      `json:"paused"`
+	Count          uint64    `json:"count"`
+	Last           time.Time `json:"last"`
+	LastDurationMs float64   `json:"lastDurationMs"`
+	ReclaimedBytes int64     `json:"reclaimedBytes"` // by the last compaction
+}
+
+// RecoveryStatus describes the last recovery.
+type RecoveryStatus struct {
+	Time       time.Time `json:"time"`
+	DurationMs float64   `json:"durationMs"`
+}
+
+// Status is the detailed state of a database for operators.
+type Status struct {
+	Stats
+	ReadOnly     bool             `json:"readOnly"` // maintenance mode
+	Segments     []SegmentInfo    `json:"segments"`
+	LastRecovery RecoveryStatus   `json:"lastRecovery"`
+	Compaction   CompactionStatus `json:"compaction"`
+}
+
+// Status returns the stats with the segments, the last recovery and the
+// state of compaction.
+func (db *DB) Status() Status {
+	stats := db.Stats()
+	db.lock.RLock()
+	defer db.lock.RUnlock()
+
+	segments := make([]SegmentInfo, 0, len(db.segments)+1)
+	for _, s := range db.segments {
+		segments = append(segments, SegmentInfo{Name: segmentName(db.name, s.id), Offset: s.base, Size: s.size, Sealed: true})
+	}
+	segments = append(segments, SegmentInfo{Name: segmentName(db.name, db.activeID), Offset: db.base, Size: db.size - db.base})
+	return Status{
+		Stats:        stats,
+		ReadOnly:     db.readOnly,
+		Segments:     segments,
+		LastRecovery: RecoveryStatus{Time: db.recoveredAt, DurationMs: milliseconds(db.recoverDuration)},
+		Compaction: CompactionStatus{
+			Running:        db.compaction.running,
+			Paused:         db.compaction.paused,
+			Count:          db.compactions,
+			Last:           db.compaction.last,
+			LastDurationMs: milliseconds(db.compaction.duration),
+			ReclaimedBytes: db.compaction.reclaimed,
+		},
+	}
+}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
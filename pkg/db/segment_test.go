@@ -0,0 +1,322 @@
+package db
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gerlacdt/db-key-value-store/pb"
+	"github.com/mattetti/filebuffer"
+)
+
+// openSegmented opens a database with the crash workload spread over three
+// segments.
+func openSegmented(t *testing.T, fs FS) (*DB, map[string]string) {
+	t.Helper()
+	db, err := Open(fs, "db")
+	if err != nil {
+		t.Fatalf("error opening %v", err)
+	}
+	acked := make(map[string]string)
+	for i, op := range crashWorkload {
+		if err := op.apply(db); err != nil && err != ErrNotFound {
+			t.Fatalf("error applying %v: %v", op, err)
+		}
+		if op.value == "" {
+			delete(acked, op.key)
+		} else {
+			acked[op.key] = op.value
+		}
+		if i%10 == 9 {
+			if err := db.Seal(); err != nil {
+				t.Fatalf("error sealing %v", err)
+			}
+		}
+	}
+	return db, acked
+}
+
+func TestSeal(t *testing.T) {
+	t.Parallel()
+	mem := NewMemFS()
+	db, acked := openSegmented(t, mem)
+	assertState(t, db, acked)
+
+	status := db.Status()
+	if len(status.Segments) != 4 {
+		t.Fatalf("segments expected 4, got %v", status.Segments)
+	}
+	offset := int64(0)
+	for i, s := range status.Segments {
+		if s.Offset != offset || s.Sealed != (i < 3) {
+			t.Fatalf("segment %d unexpected %+v", i, s)
+		}
+		offset += s.Size
+	}
+	if offset != status.LogSize {
+		t.Fatalf("segments expected to span the log of %d bytes, got %d", status.LogSize, offset)
+	}
+	// sealing an empty active segment does nothing
+	if err := db.Seal(); err != nil {
+		t.Fatalf("error sealing %v", err)
+	}
+	if n := len(db.Status().Segments); n != 4 {
+		t.Fatalf("segments expected 4, got %d", n)
+	}
+
+	db.Close()
+	db, err := Open(mem, "db")
+	if err != nil {
+		t.Fatalf("error opening %v", err)
+	}
+	assertState(t, db, acked)
+	if err := db.Set(&pb.Entity{Key: "key-0", Value: []byte("after-open")}); err != nil {
+		t.Fatalf("error SET %v", err)
+	}
+	acked["key-0"] = "after-open"
+	assertState(t, db, acked)
+
+	// a backup is the concatenation of the segments
+	var backup bytes.Buffer
+	if _, err := db.Backup(&backup); err != nil {
+		t.Fatalf("error backup %v", err)
+	}
+	restored, err := Restore(filebuffer.New(nil), &backup)
+	if err != nil {
+		t.Fatalf("error restoring %v", err)
+	}
+	assertState(t, restored, acked)
+
+	if _, err := New(filebuffer.New(nil)).Compact(); err != ErrNoSegments {
+		t.Fatalf("expected %v, got %v", ErrNoSegments, err)
+	}
+}
+
+func TestCompact(t *testing.T) {
+	t.Parallel()
+	mem := NewMemFS()
+	db, acked := openSegmented(t, mem)
+	before := db.Stats()
+	snap, err := db.Snapshot()
+	if err != nil {
+		t.Fatalf("error snapshot %v", err)
+	}
+
+	result, err := db.Compact()
+	if err != nil {
+		t.Fatalf("error compacting %v", err)
+	}
+	if result.Segments != 3 || result.Records != len(acked) || result.ReclaimedBytes <= 0 {
+		t.Fatalf("unexpected result %+v", result)
+	}
+	assertState(t, db, acked)
+	stats := db.Stats()
+	if stats.LogSize != before.LogSize-result.ReclaimedBytes || stats.DeadBytes != 0 || stats.Tombstones != 0 {
+		t.Fatalf("unexpected stats after compaction %+v", stats)
+	}
+	if status := db.Status(); len(status.Segments) != 2 || status.Compaction.Count != 1 {
+		t.Fatalf("unexpected status %+v", status)
+	}
+	if _, err := snap.WriteTo(&bytes.Buffer{}); !errors.Is(err, ErrCompacted) {
+		t.Fatalf("expected %v, got %v", ErrCompacted, err)
+	}
+
+	// writes continue in the active segment and survive a reopen
+	records := len(acked) + 1
+	if err := db.Set(&pb.Entity{Key: "key-1", Value: []byte("after-compaction")}); err != nil {
+		t.Fatalf("error SET %v", err)
+	}
+	acked["key-1"] = "after-compaction"
+	db.Close()
+	if mem.Bytes(segmentName("db", 1)) != nil {
+		t.Fatalf("compacted segment expected to be removed")
+	}
+	db, err = Open(mem, "db")
+	if err != nil {
+		t.Fatalf("error opening %v", err)
+	}
+	assertState(t, db, acked)
+
+	// subscriptions replay the compacted log
+	sub := db.Subscribe(0)
+	defer sub.Close()
+	for i := 0; i < records; i++ {
+		if e := <-sub.Events(); e == nil || e.Tombstone {
+			t.Fatalf("expected live record, got %v", e)
+		}
+	}
+}
+
+func TestCompactConcurrentWrites(t *testing.T) {
+	t.Parallel()
+	db, acked := openSegmented(t, NewMemFS())
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for _, op := range crashWorkload {
+			if err := op.apply(db); err != nil && err != ErrNotFound {
+				t.Errorf("error applying %v: %v", op, err)
+				return
+			}
+		}
+	}()
+	for i := 0; i < 3; i++ {
+		if _, err := db.Compact(); err != nil {
+			t.Fatalf("error compacting %v", err)
+		}
+	}
+	wg.Wait()
+	// the workload ends in the same state
+	assertState(t, db, acked)
+	if err := db.Recover(); err != nil {
+		t.Fatalf("error recovering %v", err)
+	}
+	assertState(t, db, acked)
+}
+
+func TestCrashDuringCompaction(t *testing.T) {
+	t.Parallel()
+	fs := NewFaultFS(NewMemFS())
+	db, _ := openSegmented(t, fs)
+	written := fs.Written()
+	if _, err := db.Compact(); err != nil {
+		t.Fatalf("error compacting %v", err)
+	}
+	total := fs.Written() - written
+
+	for n := int64(0); n <= total; n++ {
+		mem := NewMemFS()
+		fs := NewFaultFS(mem)
+		db, acked := openSegmented(t, fs)
+		fs.FailAfter(n)
+		db.Compact()
+
+		recovered, err := Open(mem, "db")
+		if err != nil {
+			t.Fatalf("crash after %d bytes: error recovering %v", n, err)
+		}
+		assertState(t, recovered, acked)
+		recovered.Close()
+	}
+}
+
+func TestMaintenance(t *testing.T) {
+	t.Parallel()
+	db, acked := openSegmented(t, NewMemFS())
+
+	db.SetReadOnly(true)
+	if err := db.Set(&pb.Entity{Key: "key-0", Value: []byte("value")}); !errors.Is(err, ErrReadOnly) {
+		t.Fatalf("expected %v, got %v", ErrReadOnly, err)
+	}
+	if !db.Status().ReadOnly {
+		t.Fatalf("expected read-only status")
+	}
+	assertState(t, db, acked)
+	db.SetReadOnly(false)
+	if err := db.Set(&pb.Entity{Key: "key-0", Value: []byte("value")}); err != nil {
+		t.Fatalf("error SET %v", err)
+	}
+
+	db.SetCompactionPaused(true)
+	if _, err := db.Compact(); err != ErrCompactionPaused {
+		t.Fatalf("expected %v, got %v", ErrCompactionPaused, err)
+	}
+	db.SetCompactionPaused(false)
+	if _, err := db.Compact(); err != nil {
+		t.Fatalf("error compacting %v", err)
+	}
+}
+
+func TestCompactKeepsSeq(t *testing.T) {
+	t.Parallel()
+	mem := NewMemFS()
+	db, err := Open(mem, "db")
+	if err != nil {
+		t.Fatalf("error opening %v", err)
+	}
+	if err := db.Set(&pb.Entity{Key: "key", Value: []byte("value")}); err != nil {
+		t.Fatalf("error SET %v", err)
+	}
+	lease, err := db.Acquire("lock", "a", time.Minute)
+	if err != nil {
+		t.Fatalf("error acquire %v", err)
+	}
+	if err := db.Release("lock", lease.Token); err != nil {
+		t.Fatalf("error release %v", err)
+	}
+	seq := db.Seq()
+	if err := db.Seal(); err != nil {
+		t.Fatalf("error sealing %v", err)
+	}
+	// the lease and its release, which have the highest sequence numbers, are dropped
+	if _, err := db.Compact(); err != nil {
+		t.Fatalf("error compacting %v", err)
+	}
+	db.Close()
+
+	db, err = Open(mem, "db")
+	if err != nil {
+		t.Fatalf("error opening %v", err)
+	}
+	defer db.Close()
+	if db.Seq() != seq {
+		t.Fatalf("seq expected %d, got %d", seq, db.Seq())
+	}
+	next, err := db.Acquire("lock", "b", time.Minute)
+	if err != nil {
+		t.Fatalf("error acquire %v", err)
+	}
+	if next.Token <= lease.Token {
+		t.Fatalf("fencing token expected to be larger than %d, got %d", lease.Token, next.Token)
+	}
+}
+
+func TestOpenReadOnly(t *testing.T) {
+	t.Parallel()
+	mem := NewMemFS()
+	db, acked := openSegmented(t, mem)
+	active := segmentName("db", db.activeID)
+
+	// a record which is still written by the owner of the log
+	f, err := mem.OpenFile(active, os.O_RDWR|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatalf("error opening %v", err)
+	}
+	if _, err := f.Write([]byte{1, 2, 3}); err != nil {
+		t.Fatalf("error writing %v", err)
+	}
+	f.Close()
+	before := mem.Bytes(active)
+
+	ro, err := OpenReadOnly(mem, "db")
+	if err != nil {
+		t.Fatalf("error opening read-only %v", err)
+	}
+	defer ro.Close()
+	assertState(t, ro, acked)
+	if !bytes.Equal(mem.Bytes(active), before) {
+		t.Fatalf("torn record expected to be kept")
+	}
+	if err := ro.Set(&pb.Entity{Key: "key-0", Value: []byte("value")}); !errors.Is(err, ErrReadOnly) {
+		t.Fatalf("expected %v, got %v", ErrReadOnly, err)
+	}
+	if err := ro.Seal(); !errors.Is(err, ErrReadOnly) {
+		t.Fatalf("expected %v, got %v", ErrReadOnly, err)
+	}
+	if _, err := ro.Compact(); !errors.Is(err, ErrReadOnly) {
+		t.Fatalf("expected %v, got %v", ErrReadOnly, err)
+	}
+
+	// a missing log is not created
+	if _, err := OpenReadOnly(mem, "missing"); err == nil {
+		t.Fatalf("expected error opening a missing log")
+	}
+	if mem.Bytes("missing") != nil {
+		t.Fatalf("missing log expected not to be created")
+	}
+}
@@ -0,0 +1,91 @@
+package db
+
+import (
+	"time"
+
+	"github.com/gerlacdt/db-key-value-store/pkg/metrics"
+)
+
+// Stats describes the keyspace and the log of a database.
+type Stats struct {
+	Keys       int    `json:"keys"`
+	Tombstones int    `json:"tombstones"`
+	LiveBytes  int64  `json:"liveBytes"`
+	DeadBytes  int64  `json:"deadBytes"` // overwritten records and tombstones
+	LogSize    int64  `json:"logSize"`
+	Seq        uint64 `json:"seq"`
+}
+
+// counters are maintained with the index.
+type counters struct {
+	keys       int
+	tombstones int
+	liveBytes  int64
+}
+
+// setEntry points the key to its latest record and updates the counters and
+// the quota usage. The caller must hold the write lock.
+func (db *DB) setEntry(key string, e entry) {
+	old, existed := db.index[key]
+	if existed {
+		db.counters.remove(old)
+	}
+	db.index[key] = e
+	db.counters.add(e)
+	db.updateQuotas(key, old, existed, e)
+}
+
+func (c *counters) add(e entry) {
+	if e.tombstone {
+		c.tombstones++
+		return
+	}
+	c.keys++
+	c.liveBytes += e.size
+}
+
+func (c *counters) remove(e entry) {
+	if e.tombstone {
+		c.tombstones--
+		return
+	}
+	c.keys--
+	c.liveBytes -= e.size
+}
+
+// Stats returns the current statistics of the database.
+func (db *DB) Stats() Stats {
+	db.lock.RLock()
+	defer db.lock.RUnlock()
+
+	return Stats{
+		Keys:       db.counters.keys,
+		Tombstones: db.counters.tombstones,
+		LiveBytes:  db.counters.liveBytes,
+		DeadBytes:  db.size - db.counters.liveBytes,
+		LogSize:    db.size,
+		Seq:        db.seq,
+	}
+}
+
+// Metrics are the operational metrics of a database.
+type Metrics struct {
+	Stats
+	RecoverDuration time.Duration      // duration of the last Recover
+	Compactions     uint64             // completed compactions
+	SyncLatency     *metrics.Histogram // seconds per fsync of the log
+}
+
+// Metrics returns the stats and the operational metrics.
+func (db *DB) Metrics() Metrics {
+	stats := db.Stats()
+	db.lock.RLock()
+	defer db.lock.RUnlock()
+
+	return Metrics{
+		Stats:           stats,
+		RecoverDuration: db.recoverDuration,
+		Compactions:     db.compactions,
+		SyncLatency:     db.syncLatency,
+	}
+}
@@ -0,0 +1,58 @@
+package db
+
+import (
+	"testing"
+
+	"github.com/gerlacdt/db-key-value-store/pb"
+)
+
+func TestStats(t *testing.T) {
+	db := setup(t)
+	for _, key := range []string{"foo-key", "bar-key", "foo-key"} {
+		err := db.Set(&pb.Entity{Key: key, Value: []byte("value")})
+		if err != nil {
+			t.Fatalf("error SET %v", err)
+		}
+	}
+	if err := db.Delete("bar-key"); err != nil {
+		t.Fatalf("error DELETE %v", err)
+	}
+
+	stats := db.Stats()
+	size, err := db.Size()
+	if err != nil {
+		t.Fatalf("error getting size %v", err)
+	}
+	if stats.Keys != 1 || stats.Tombstones != 1 || stats.Seq != 4 || stats.LogSize != size {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+	if stats.LiveBytes <= 0 || stats.LiveBytes+stats.DeadBytes != size {
+		t.Fatalf("unexpected live and dead bytes %+v", stats)
+	}
+
+	// recovering results in the same stats
+	err = db.Recover()
+	if err != nil {
+		t.Fatalf("error recovering %v", err)
+	}
+	if db.Stats() != stats {
+		t.Fatalf("expected %+v, got %+v", stats, db.Stats())
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	t.Parallel()
+	db, err := Open(NewMemFS(), "db")
+	if err != nil {
+		t.Fatalf("error opening %v", err)
+	}
+	db.SetSyncWrites(true)
+	if err := db.Set(&pb.Entity{Key: "foo-key", Value: []byte("foo-value")}); err != nil {
+		t.Fatalf("error SET %v", err)
+	}
+
+	m := db.Metrics()
+	if m.Keys != 1 || m.RecoverDuration <= 0 || m.SyncLatency.Count() != 1 {
+		t.Fatalf("unexpected metrics %+v, %d fsyncs", m, m.SyncLatency.Count())
+	}
+}
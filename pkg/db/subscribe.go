@@ -0,0 +1,189 @@
+package db
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/gerlacdt/db-key-value-store/pb"
+)
+
+// subscriptionBuffer is the number of committed writes buffered for a
+// subscriber before it is considered lagging.
+const subscriptionBuffer = 1024
+
+// ErrLagged ends a subscription whose consumer could not keep up with the
+// committed writes. The consumer can resume from the last received sequence
+// number.
+var ErrLagged = errors.New("subscriber lagged behind")
+
+// Subscription delivers committed writes in commit order.
+type Subscription struct {
+	db         *DB
+	from       uint64
+	events     chan *pb.Entity
+	done       chan struct{}
+	closeOnce  sync.Once
+	registered bool // receives live writes, guarded by db.lock
+	err        error
+
+	// replay position, the log is read again from the start after a compaction
+	compactions uint64
+	last        uint64 // sequence number of the last delivered write
+}
+
+// Subscribe returns a subscription to all committed Set and Delete operations
+// with a sequence number of at least from. Older writes are read from the log
+// before the subscription switches to live writes, a subscription of future
+// writes only receives live writes.
+func (db *DB) Subscribe(from uint64) *Subscription {
+	s := &Subscription{
+		db:     db,
+		from:   from,
+		events: make(chan *pb.Entity, subscriptionBuffer),
+		done:   make(chan struct{}),
+	}
+	db.lock.Lock()
+	switch {
+	case db.closed:
+		s.err = ErrClosed
+		close(s.events)
+	case from > db.seq:
+		s.registered = true
+		db.subs[s] = struct{}{}
+	default:
+		db.lock.Unlock()
+		go s.replay()
+		return s
+	}
+	db.lock.Unlock()
+	return s
+}
+
+// Events returns the channel of committed writes. It is closed when the
+// subscription ends.
+func (s *Subscription) Events() <-chan *pb.Entity { return s.events }
+
+// Err returns the reason why the subscription ended, it is only valid after
+// the events channel is closed. It is nil if the subscription was closed.
+func (s *Subscription) Err() error { return s.err }
+
+// Close ends the subscription.
+func (s *Subscription) Close() {
+	s.closeOnce.Do(func() {
+		close(s.done)
+
+		s.db.lock.Lock()
+		defer s.db.lock.Unlock()
+		if s.registered {
+			s.db.unsubscribe(s, nil)
+		}
+	})
+}
+
+// replay delivers the writes already in the log and registers the
+// subscription for live writes when it reaches the end of the log.
+func (s *Subscription) replay() {
+	offset := int64(0)
+	for {
+		entity, next, live, err := s.next(offset)
+		if live {
+			return
+		}
+		if err != nil {
+			s.err = err
+			close(s.events)
+			return
+		}
+		if entity == nil {
+			// closed before reaching the end of the log
+			close(s.events)
+			return
+		}
+		offset = next
+		if entity.Seq < s.from {
+			continue
+		}
+		select {
+		case s.events <- entity:
+			s.last = entity.Seq
+		case <-s.done:
+			close(s.events)
+			return
+		}
+	}
+}
+
+// next reads the record at offset and returns it with the offset of the next
+// record or registers the subscription if the end of the log is reached. It
+// returns a nil entity if the subscription is closed. Records are read with
+// the read lock, so a replay does not block writers.
+func (s *Subscription) next(offset int64) (*pb.Entity, int64, bool, error) {
+	s.db.lock.RLock()
+	entity, next, closed, err := s.read(offset)
+	s.db.lock.RUnlock()
+	if entity != nil || closed || err != nil {
+		return entity, next, false, err
+	}
+
+	// the end of the log, writes may have been committed in the meantime
+	s.db.lock.Lock()
+	defer s.db.lock.Unlock()
+	entity, next, closed, err = s.read(next)
+	if entity != nil || closed || err != nil {
+		return entity, next, false, err
+	}
+	s.registered = true
+	s.db.subs[s] = struct{}{}
+	return nil, 0, true, nil
+}
+
+// read reads the record at offset and returns it with the offset of the next
+// record. At the end of the log it returns a nil entity and the end. closed
+// reports a closed subscription. The caller must hold the lock.
+func (s *Subscription) read(offset int64) (*pb.Entity, int64, bool, error) {
+	select {
+	case <-s.done:
+		return nil, 0, true, nil
+	default:
+	}
+	if s.db.closed {
+		return nil, 0, false, ErrClosed
+	}
+	if s.compactions != s.db.compactions {
+		// offsets moved, compacted writes which were not delivered yet are lost
+		s.compactions = s.db.compactions
+		offset = 0
+		if s.last >= s.from {
+			s.from = s.last + 1
+		}
+	}
+	if offset >= s.db.size {
+		return nil, offset, false, nil
+	}
+	entity, n, err := s.db.readRecord(offset)
+	return entity, offset + n, false, err
+}
+
+// publish delivers a committed write to all subscribers. The caller must hold
+// the write lock.
+func (db *DB) publish(entity *pb.Entity) {
+	for s := range db.subs {
+		if entity.Seq < s.from {
+			continue
+		}
+		select {
+		case s.events <- entity:
+		default:
+			db.unsubscribe(s, ErrLagged)
+		}
+	}
+}
+
+// unsubscribe ends a registered subscription. The caller must hold the write
+// lock.
+func (db *DB) unsubscribe(s *Subscription, err error) {
+	delete(db.subs, s)
+	s.registered = false
+	s.err = err
+	close(s.events)
+}
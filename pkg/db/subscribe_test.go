@@ -0,0 +1,134 @@
+package db
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/gerlacdt/db-key-value-store/pb"
+)
+
+func nextEvent(t *testing.T, sub *Subscription) *pb.Entity {
+	select {
+	case entity, ok := <-sub.Events():
+		if !ok {
+			t.Fatalf("subscription ended: %v", sub.Err())
+		}
+		return entity
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timeout waiting for event")
+	}
+	return nil
+}
+
+func TestSubscribeReplayAndLive(t *testing.T) {
+	db := setup(t)
+	for i := 1; i <= 3; i++ {
+		err := db.Set(&pb.Entity{Key: "foo-key-" + strconv.Itoa(i), Value: []byte("foo-value")})
+		if err != nil {
+			t.Fatalf("error SET %v", err)
+		}
+	}
+
+	// resume after the first write
+	sub := db.Subscribe(2)
+	defer sub.Close()
+	if err := db.Delete("foo-key-1"); err != nil {
+		t.Fatalf("error DELETE %v", err)
+	}
+
+	expected := []struct {
+		key       string
+		seq       uint64
+		tombstone bool
+	}{
+		{"foo-key-2", 2, false},
+		{"foo-key-3", 3, false},
+		{"foo-key-1", 4, true},
+	}
+	for _, e := range expected {
+		entity := nextEvent(t, sub)
+		if entity.Key != e.key || entity.Seq != e.seq || entity.Tombstone != e.tombstone {
+			t.Fatalf("expected %v, got %v", e, entity)
+		}
+	}
+}
+
+func TestSubscribeClose(t *testing.T) {
+	db := setup(t)
+	sub := db.Subscribe(1)
+	err := db.Set(&pb.Entity{Key: "foo-key", Value: []byte("foo-value")})
+	if err != nil {
+		t.Fatalf("error SET %v", err)
+	}
+	nextEvent(t, sub)
+
+	sub.Close()
+	sub.Close()
+	if _, ok := <-sub.Events(); ok {
+		t.Fatalf("expected closed events channel")
+	}
+	if sub.Err() != nil {
+		t.Fatalf("expected no error, got %v", sub.Err())
+	}
+	err = db.Set(&pb.Entity{Key: "foo-key", Value: []byte("foo-value")})
+	if err != nil {
+		t.Fatalf("error SET %v", err)
+	}
+}
+
+func TestSubscribeLagged(t *testing.T) {
+	db := setup(t)
+	sub := db.Subscribe(1)
+	defer sub.Close()
+	// wait until the subscription receives live writes
+	for {
+		db.lock.RLock()
+		live := sub.registered
+		db.lock.RUnlock()
+		if live {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	for i := 0; i <= subscriptionBuffer; i++ {
+		err := db.Set(&pb.Entity{Key: "foo-key", Value: []byte("foo-value")})
+		if err != nil {
+			t.Fatalf("error SET %v", err)
+		}
+	}
+	for range sub.Events() {
+	}
+	if sub.Err() != ErrLagged {
+		t.Fatalf("expected %v, got %v", ErrLagged, sub.Err())
+	}
+}
+
+func TestSubscribeLive(t *testing.T) {
+	db := setup(t)
+	if err := db.Set(&pb.Entity{Key: "foo-key", Value: []byte("foo-value")}); err != nil {
+		t.Fatalf("error SET %v", err)
+	}
+
+	// a subscription of future writes is registered without replaying the log
+	sub := db.Subscribe(db.Seq() + 1)
+	defer sub.Close()
+	db.lock.RLock()
+	registered := sub.registered
+	db.lock.RUnlock()
+	if !registered {
+		t.Fatalf("subscription expected to be registered")
+	}
+	if err := db.Set(&pb.Entity{Key: "bar-key", Value: []byte("bar-value")}); err != nil {
+		t.Fatalf("error SET %v", err)
+	}
+	if e := nextEvent(t, sub); e.Key != "bar-key" || e.Seq != 2 {
+		t.Fatalf("expected bar-key with seq 2, got %v", e)
+	}
+
+	db.Close()
+	if sub := db.Subscribe(db.Seq() + 1); sub.Err() != ErrClosed {
+		t.Fatalf("expected %v, got %v", ErrClosed, sub.Err())
+	}
+}
@@ -0,0 +1,115 @@
+package db
+
+import (
+	"context"
+	"strings"
+
+	"github.com/gerlacdt/db-key-value-store/pb"
+)
+
+// watcher waits for the next write to a key or to any key under a prefix.
+type watcher struct {
+	key    string
+	prefix bool
+	ch     chan *pb.Entity
+}
+
+func (w *watcher) matches(key string) bool {
+	if w.prefix {
+		return strings.HasPrefix(key, w.key)
+	}
+	return key == w.key
+}
+
+// Watch blocks until the key, or with prefix any key starting with key, is
+// written with a sequence number greater than since. It returns the written
+// entity, a tombstone for a delete. If such a write was already committed,
+// Watch returns the current entity immediately, for a prefix the one with the
+// lowest sequence number greater than since.
+func (db *DB) Watch(ctx context.Context, key string, prefix bool, since uint64) (*pb.Entity, error) {
+	w := &watcher{key: key, prefix: prefix, ch: make(chan *pb.Entity, 1)}
+
+	db.lock.RLock()
+	if db.closed {
+		db.lock.RUnlock()
+		return nil, ErrClosed
+	}
+	entity, err := db.changedSince(w, since)
+	seq := db.seq
+	db.lock.RUnlock()
+	if err != nil || entity != nil {
+		return entity, err
+	}
+
+	db.lock.Lock()
+	if db.closed {
+		db.lock.Unlock()
+		return nil, ErrClosed
+	}
+	if db.seq != seq {
+		// written in the meantime
+		entity, err := db.changedSince(w, since)
+		if err != nil || entity != nil {
+			db.lock.Unlock()
+			return entity, err
+		}
+	}
+	db.watchers[w] = struct{}{}
+	db.lock.Unlock()
+
+	select {
+	case entity, ok := <-w.ch:
+		if !ok {
+			return nil, ErrClosed
+		}
+		return entity, nil
+	case <-ctx.Done():
+		db.lock.Lock()
+		delete(db.watchers, w)
+		db.lock.Unlock()
+		// a write may have raced with the cancellation
+		select {
+		case entity, ok := <-w.ch:
+			if !ok {
+				return nil, ErrClosed
+			}
+			return entity, nil
+		default:
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// changedSince returns the current entity matched by the watcher with the
+// lowest sequence number greater than since. Only the returned entity is read
+// from the log. The caller must hold the lock.
+func (db *DB) changedSince(w *watcher, since uint64) (*pb.Entity, error) {
+	var changed *entry
+	if !w.prefix {
+		if e, ok := db.index[w.key]; ok && e.seq > since {
+			changed = &e
+		}
+	} else {
+		for k, e := range db.index {
+			if w.matches(k) && e.seq > since && (changed == nil || e.seq < changed.seq) {
+				e := e
+				changed = &e
+			}
+		}
+	}
+	if changed == nil {
+		return nil, nil
+	}
+	return db.readAt(changed.offset)
+}
+
+// notify wakes up all watchers of the committed entity. The caller must hold
+// the write lock.
+func (db *DB) notify(entity *pb.Entity) {
+	for w := range db.watchers {
+		if w.matches(entity.Key) {
+			w.ch <- entity
+			delete(db.watchers, w)
+		}
+	}
+}
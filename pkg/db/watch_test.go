@@ -0,0 +1,118 @@
+package db
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/gerlacdt/db-key-value-store/pb"
+)
+
+func TestWatchKey(t *testing.T) {
+	db := setup(t)
+	err := db.Set(&pb.Entity{Key: "foo-key", Value: []byte("foo-value")})
+	if err != nil {
+		t.Fatalf("error SET %v", err)
+	}
+
+	result := make(chan *pb.Entity, 1)
+	go func() {
+		entity, err := db.Watch(context.Background(), "foo-key", false, db.Seq())
+		if err != nil {
+			t.Errorf("error watching %v", err)
+		}
+		result <- entity
+	}()
+
+	// writes to other keys do not wake up the watcher
+	err = db.Set(&pb.Entity{Key: "bar-key", Value: []byte("bar-value")})
+	if err != nil {
+		t.Fatalf("error SET %v", err)
+	}
+	select {
+	case entity := <-result:
+		t.Fatalf("unexpected watch result %v", entity)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	if err := db.Delete("foo-key"); err != nil {
+		t.Fatalf("error DELETE %v", err)
+	}
+	select {
+	case entity := <-result:
+		if entity == nil || !entity.Tombstone || entity.Key != "foo-key" {
+			t.Fatalf("expected tombstone for foo-key, got %v", entity)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timeout waiting for watch result")
+	}
+}
+
+func TestWatchPrefixSince(t *testing.T) {
+	db := setup(t)
+	for _, key := range []string{"foo-1", "bar-1", "foo-2"} {
+		err := db.Set(&pb.Entity{Key: key, Value: []byte("value")})
+		if err != nil {
+			t.Fatalf("error SET %v", err)
+		}
+	}
+
+	// changes after since are returned immediately, the oldest first
+	entity, err := db.Watch(context.Background(), "foo-", true, 1)
+	if err != nil {
+		t.Fatalf("error watching %v", err)
+	}
+	if entity == nil || entity.Key != "foo-2" || entity.Seq != 3 {
+		t.Fatalf("expected foo-2 with seq 3, got %v", entity)
+	}
+}
+
+func TestWatchTimeout(t *testing.T) {
+	db := setup(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	entity, err := db.Watch(ctx, "foo-key", false, 0)
+	if err != context.DeadlineExceeded {
+		t.Fatalf("expected %v, got %v", context.DeadlineExceeded, err)
+	}
+	if entity != nil {
+		t.Fatalf("entity expected nil, got %v", entity)
+	}
+	if len(db.watchers) != 0 {
+		t.Fatalf("watchers expected %d, got %d", 0, len(db.watchers))
+	}
+}
+
+func TestWatchSinceAfterCompaction(t *testing.T) {
+	t.Parallel()
+	mem := NewMemFS()
+	db, err := Open(mem, "db")
+	if err != nil {
+		t.Fatalf("error opening %v", err)
+	}
+	for _, key := range []string{"foo-1", "foo-2", "foo-1"} {
+		if err := db.Set(&pb.Entity{Key: key, Value: []byte(key)}); err != nil {
+			t.Fatalf("error SET %v", err)
+		}
+	}
+	if _, err := db.Compact(); err != nil {
+		t.Fatalf("error compacting %v", err)
+	}
+	db.Close()
+	// the sequence numbers of the index are rebuilt from the log
+	db, err = Open(mem, "db")
+	if err != nil {
+		t.Fatalf("error opening %v", err)
+	}
+	defer db.Close()
+
+	entity, err := db.Watch(context.Background(), "foo-", true, 1)
+	if err != nil || entity == nil || entity.Key != "foo-2" || entity.Seq != 2 {
+		t.Fatalf("expected foo-2 with seq 2, got %v %v", entity, err)
+	}
+	entity, err = db.Watch(context.Background(), "foo-1", false, 2)
+	if err != nil || entity == nil || entity.Seq != 3 {
+		t.Fatalf("expected foo-1 with seq 3, got %v %v", entity, err)
+	}
+}
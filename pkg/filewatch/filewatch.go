@@ -0,0 +1,68 @@
+// Package filewatch reloads configuration files, e.g. certificates and
+// tokens, after they changed. Changes are detected by polling the modification
+// time and the size of the files.
+package filewatch
+
+import (
+	"context"
+	"log"
+	"os"
+	"time"
+)
+
+// Version identifies the content of files without reading them.
+type Version struct {
+	names []string
+	files []file
+}
+
+type file struct {
+	modTime time.Time
+	size    int64
+}
+
+// Stat returns the version of the files, a missing file has the zero version.
+func Stat(names ...string) Version {
+	v := Version{names: names, files: make([]file, len(names))}
+	for i, name := range names {
+		if fi, err := os.Stat(name); err == nil {
+			v.files[i] = file{modTime: fi.ModTime(), size: fi.Size()}
+		}
+	}
+	return v
+}
+
+// Changed reports whether a file changed since the version was taken.
+func (v Version) Changed() bool {
+	current := Stat(v.names...)
+	for i, f := range current.files {
+		if !f.modTime.Equal(v.files[i].modTime) || f.size != v.files[i].size {
+			return true
+		}
+	}
+	return false
+}
+
+// Watch checks the files every interval and calls reload after changes until
+// the context is cancelled. version returns the version of the last
+// successful reload. Errors are logged and retried with the next check, what
+// describes the files in the log.
+func Watch(ctx context.Context, interval time.Duration, version func() Version, reload func() error, what string) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if !version().Changed() {
+			continue
+		}
+		if err := reload(); err != nil {
+			log.Printf("could not reload %s: %v", what, err)
+			continue
+		}
+		log.Printf("reloaded %s", what)
+	}
+}
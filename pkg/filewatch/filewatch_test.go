@@ -0,0 +1,85 @@
+package filewatch
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestChanged(t *testing.T) {
+	t.Parallel()
+	dir := t.TempDir()
+	name := filepath.Join(dir, "file")
+	missing := filepath.Join(dir, "missing")
+
+	v := Stat(name, missing)
+	if v.Changed() {
+		t.Fatalf("missing files expected to be unchanged")
+	}
+	if err := ioutil.WriteFile(name, []byte("a"), 0644); err != nil {
+		t.Fatalf("error writing %v", err)
+	}
+	if !v.Changed() {
+		t.Fatalf("created file expected to be changed")
+	}
+	v = Stat(name, missing)
+	if v.Changed() {
+		t.Fatalf("file expected to be unchanged")
+	}
+	if err := ioutil.WriteFile(name, []byte("ab"), 0644); err != nil {
+		t.Fatalf("error writing %v", err)
+	}
+	if !v.Changed() {
+		t.Fatalf("written file expected to be changed")
+	}
+	if err := os.Remove(name); err != nil {
+		t.Fatalf("error removing %v", err)
+	}
+	if !v.Changed() {
+		t.Fatalf("removed file expected to be changed")
+	}
+}
+
+func TestWatch(t *testing.T) {
+	t.Parallel()
+	name := filepath.Join(t.TempDir(), "file")
+	var version atomic.Value
+	version.Store(Stat(name))
+	var reloads int32
+	reload := func() error {
+		version.Store(Stat(name))
+		atomic.AddInt32(&reloads, 1)
+		return nil
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		Watch(ctx, time.Millisecond, func() Version { return version.Load().(Version) }, reload, "file")
+		close(done)
+	}()
+	time.Sleep(10 * time.Millisecond)
+	if n := atomic.LoadInt32(&reloads); n != 0 {
+		t.Fatalf("reloads of unchanged file expected 0, got %d", n)
+	}
+	if err := ioutil.WriteFile(name, []byte("a"), 0644); err != nil {
+		t.Fatalf("error writing %v", err)
+	}
+	deadline := time.Now().Add(time.Second)
+	for atomic.LoadInt32(&reloads) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("changed file expected to be reloaded")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	time.Sleep(10 * time.Millisecond)
+	if n := atomic.LoadInt32(&reloads); n != 1 {
+		t.Fatalf("reloads expected 1, got %d", n)
+	}
+	cancel()
+	<-done
+}
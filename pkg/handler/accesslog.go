@@ -0,0 +1,201 @@
+package handler
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	mathrand "math/rand"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// LogLevel is the minimum level of access log lines. Requests are logged
+// with LevelInfo, client errors with LevelWarn and server errors with
+// LevelError.
+type LogLevel int
+
+// Log levels
+const (
+	LevelInfo LogLevel = iota
+	LevelWarn
+	LevelError
+	LevelOff
+)
+
+var logLevels = []string{"info", "warn", "error", "off"}
+
+// ParseLogLevel returns the level for info, warn, error or off.
+func ParseLogLevel(s string) (LogLevel, error) {
+	for i, name := range logLevels {
+		if strings.EqualFold(s, name) {
+			return LogLevel(i), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown log level %q, info, warn, error and off are supported", s)
+}
+
+func (l LogLevel) String() string {
+	return logLevels[l]
+}
+
+// requestIDHeader is propagated from requests or generated.
+const requestIDHeader = "X-Request-ID"
+
+// requestInfo is shared by the middlewares of a request.
+type requestInfo struct {
+	id    string
+	token string // name of the bearer token, set by authorize
+	err   error  // returned by the handler, set by errorMiddleware
+}
+
+type requestInfoKey struct{}
+
+func getRequestInfo(ctx context.Context) *requestInfo {
+	info, _ := ctx.Value(requestInfoKey{}).(*requestInfo)
+	return info
+}
+
+// requestID returns the ID of the request or an empty string.
+func requestID(ctx context.Context) string {
+	if info := getRequestInfo(ctx); info != nil {
+		return info.id
+	}
+	return ""
+}
+
+func newRequestID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return fmt.Sprintf("%x", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(b)
+}
+
+// accessLog writes one JSON line per request.
+type accessLog struct {
+	lock   sync.Mutex
+	out    io.Writer
+	level  LogLevel
+	sample float64 // fraction of info lines which are written
+}
+
+// WithAccessLog writes a JSON line for every request with at least the level
+// to out. Only the sample fraction, between 0 and 1, of the info lines is
+// written, warnings and errors are always written.
+func WithAccessLog(out io.Writer, level LogLevel, sample float64) Option {
+	return func(h *handler) { h.accessLog = &accessLog{out: out, level: level, sample: sample} }
+}
+
+// accessLogLine is a line of the access log.
+type accessLogLine struct {
+	Time      string  `json:"time"`
+	Level     string  `json:"level"`
+	Method    string  `json:"method"`
+	Path      string  `json:"path"`
+	Key       string  `json:"key,omitempty"`
+	Status    int     `json:"status"`
+	BytesIn   int64   `json:"bytesIn"`
+	BytesOut  int64   `json:"bytesOut"`
+	LatencyMs float64 `json:"latencyMs"`
+	Remote    string  `json:"remote"`
+	RequestID string  `json:"requestId"`
+	Token     string  `json:"token,omitempty"`
+	Error     string  `json:"error,omitempty"`
+	Cause     string  `json:"cause,omitempty"`
+}
+
+// countingReader counts the bytes read from the request body.
+type countingReader struct {
+	io.ReadCloser
+	n int64
+}
+
+func (r *countingReader) Read(b []byte) (int, error) {
+	n, err := r.ReadCloser.Read(b)
+	r.n += int64(n)
+	return n, err
+}
+
+// requestMiddleware assigns the request ID, which is taken from the
+// X-Request-ID header or generated, and writes the access log.
+func (h *handler) requestMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		info := &requestInfo{id: r.Header.Get(requestIDHeader)}
+		if info.id == "" || len(info.id) > 128 {
+			info.id = newRequestID()
+		}
+		w.Header().Set(requestIDHeader, info.id)
+		r = r.WithContext(context.WithValue(r.Context(), requestInfoKey{}, info))
+
+		if h.accessLog == nil || h.accessLog.level == LevelOff {
+			next.ServeHTTP(w, r)
+			return
+		}
+		body := &countingReader{ReadCloser: r.Body}
+		r.Body = body
+		sw := &statusWriter{ResponseWriter: w}
+		next.ServeHTTP(sw, r)
+		if sw.status == 0 {
+			sw.status = http.StatusOK
+		}
+		h.accessLog.write(r, sw, body.n, time.Since(start), info)
+	})
+}
+
+func (l *accessLog) write(r *http.Request, sw *statusWriter, bytesIn int64, latency time.Duration, info *requestInfo) {
+	level := LevelInfo
+	switch {
+	case sw.status >= 500:
+		level = LevelError
+	case sw.status >= 400:
+		level = LevelWarn
+	}
+	if level < l.level || (level == LevelInfo && l.sample < 1 && mathrand.Float64() >= l.sample) {
+		return
+	}
+
+	line := accessLogLine{
+		Time:      time.Now().UTC().Format(time.RFC3339Nano),
+		Level:     level.String(),
+		Method:    r.Method,
+		Path:      r.URL.Path,
+		Key:       requestKey(r.URL.Path),
+		Status:    sw.status,
+		BytesIn:   bytesIn,
+		BytesOut:  sw.bytes,
+		LatencyMs: float64(latency) / float64(time.Millisecond),
+		Remote:    r.RemoteAddr,
+		RequestID: info.id,
+		Token:     info.token,
+	}
+	if herr, ok := info.err.(*httpError); ok {
+		line.Error = herr.msg
+		if herr.err != nil && herr.err.Error() != "" {
+			line.Cause = herr.err.Error()
+		}
+	} else if info.err != nil {
+		line.Error = info.err.Error()
+	}
+
+	b, err := json.Marshal(line)
+	if err != nil {
+		return
+	}
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.out.Write(append(b, '\n'))
+}
+
+// requestKey returns the key of /db/<key> and /ns/<name>/db/<key> requests.
+func requestKey(path string) string {
+	if i := strings.Index(path, "/db/"); i >= 0 && (i == 0 || strings.HasPrefix(path, "/ns/")) {
+		return path[i+len("/db/"):]
+	}
+	return ""
+}
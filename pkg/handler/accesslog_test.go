@@ -0,0 +1,112 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/gerlacdt/db-key-value-store/pkg/db"
+	"github.com/mattetti/filebuffer"
+)
+
+// syncBuffer is a buffer for the access log written by the server goroutines.
+type syncBuffer struct {
+	lock sync.Mutex
+	buf  bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) lines(t *testing.T) []accessLogLine {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	var lines []accessLogLine
+	for _, s := range strings.Split(strings.TrimSpace(b.buf.String()), "\n") {
+		if s == "" {
+			continue
+		}
+		var line accessLogLine
+		if err := json.Unmarshal([]byte(s), &line); err != nil {
+			t.Fatalf("invalid log line %s: %v", s, err)
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+func setupAccessLog(t *testing.T, level LogLevel, sample float64) (*httptest.Server, *syncBuffer) {
+	t.Parallel()
+	out := &syncBuffer{}
+	h, err := New(db.New(filebuffer.New(nil)), WithAccessLog(out, level, sample))
+	if err != nil {
+		t.Fatalf("could not create handler: %v", err)
+	}
+	srv := httptest.NewServer(h)
+	t.Cleanup(srv.Close)
+	return srv, out
+}
+
+func TestAccessLog(t *testing.T) {
+	srv, out := setupAccessLog(t, LevelInfo, 1)
+
+	req, err := http.NewRequest("POST", fmt.Sprintf("%s/db/foo", srv.URL), strings.NewReader("bar"))
+	if err != nil {
+		t.Fatalf("error creating request %v", err)
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	req.Header.Set("X-Request-ID", "req-1")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("error http POST %v", err)
+	}
+	resp.Body.Close()
+	if resp.Header.Get("X-Request-ID") != "req-1" {
+		t.Fatalf("request id expected %s, got %s", "req-1", resp.Header.Get("X-Request-ID"))
+	}
+	resp = doRequest(t, "GET", fmt.Sprintf("%s/db/missing", srv.URL), nil)
+	resp.Body.Close()
+	generated := resp.Header.Get("X-Request-ID")
+	if generated == "" {
+		t.Fatalf("expected generated request id")
+	}
+
+	lines := out.lines(t)
+	if len(lines) != 2 {
+		t.Fatalf("expected 2 log lines, got %+v", lines)
+	}
+	set, get := lines[0], lines[1]
+	if set.Level != "info" || set.Method != "POST" || set.Key != "foo" || set.Status != http.StatusCreated ||
+		set.BytesIn != 3 || set.RequestID != "req-1" || set.Remote == "" {
+		t.Fatalf("unexpected log line %+v", set)
+	}
+	if get.Level != "warn" || get.Status != http.StatusNotFound || get.RequestID != generated || get.Error == "" {
+		t.Fatalf("unexpected log line %+v", get)
+	}
+}
+
+func TestAccessLogLevelAndSampling(t *testing.T) {
+	srv, out := setupAccessLog(t, LevelInfo, 0)
+
+	doRequest(t, "POST", fmt.Sprintf("%s/db/foo", srv.URL), []byte("bar")).Body.Close()
+	doRequest(t, "GET", fmt.Sprintf("%s/db/missing", srv.URL), nil).Body.Close()
+	lines := out.lines(t)
+	if len(lines) != 1 || lines[0].Status != http.StatusNotFound {
+		t.Fatalf("expected only the warning, got %+v", lines)
+	}
+
+	if _, err := ParseLogLevel("verbose"); err == nil {
+		t.Fatalf("expected error for unknown level")
+	}
+	if level, err := ParseLogLevel("ERROR"); err != nil || level != LevelError {
+		t.Fatalf("expected %v, got %v %v", LevelError, level, err)
+	}
+}
@@ -0,0 +1,138 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gerlacdt/db-key-value-store/pkg/db"
+)
+
+// exportContentType maps export formats to http content types.
+var exportContentType = map[db.Format]string{
+	db.JSONLines: "application/x-ndjson",
+	db.Protobuf:  "application/octet-stream",
+}
+
+func (h *handler) exportHandler(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != http.MethodGet {
+		return errorf(fmt.Errorf(""), http.StatusMethodNotAllowed, r.Method+": method not allowed")
+	}
+	format, err := db.ParseFormat(r.URL.Query().Get("format"))
+	if err != nil {
+		return errorf(err, http.StatusBadRequest, "export format not supported")
+	}
+
+	// the body is streamed, errors after the first write can not change the status code anymore
+	w.Header().Set("Content-Type", exportContentType[format])
+	return h.db.Export(w, format)
+}
+
+func (h *handler) importHandler(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != http.MethodPost {
+		return errorf(fmt.Errorf(""), http.StatusMethodNotAllowed, r.Method+": method not allowed")
+	}
+	format, err := db.ParseFormat(r.URL.Query().Get("format"))
+	if err != nil {
+		return errorf(err, http.StatusBadRequest, "import format not supported")
+	}
+
+	n, err := h.db.Import(r.Body, format)
+	if err != nil {
+		return errorf(err, http.StatusBadRequest, fmt.Sprintf("import failed after %d entities", n))
+	}
+	return writeJSON(w, http.StatusOK, struct {
+		Imported int `json:"imported"`
+	}{n})
+}
+
+func (h *handler) backupHandler(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != http.MethodGet {
+		return errorf(fmt.Errorf(""), http.StatusMethodNotAllowed, r.Method+": method not allowed")
+	}
+	snap, err := h.db.Snapshot()
+	if err != nil {
+		return errorf(err, http.StatusInternalServerError, "could not create snapshot")
+	}
+
+	// the content length lets clients detect truncated downloads
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Length", strconv.FormatInt(snap.Size, 10))
+	_, err = snap.WriteTo(w)
+	return err
+}
+
+func (h *handler) statsHandler(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != http.MethodGet {
+		return errorf(fmt.Errorf(""), http.StatusMethodNotAllowed, r.Method+": method not allowed")
+	}
+	return writeJSON(w, http.StatusOK, h.db.Status())
+}
+
+func (h *handler) quotasHandler(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != http.MethodGet {
+		return errorf(fmt.Errorf(""), http.StatusMethodNotAllowed, r.Method+": method not allowed")
+	}
+	return writeJSON(w, http.StatusOK, h.db.QuotaUsage())
+}
+
+// compactionHandler shows the state of compaction (GET), runs a compaction
+// (POST) or pauses and resumes compaction (PUT {"paused": true}).
+func (h *handler) compactionHandler(w http.ResponseWriter, r *http.Request) error {
+	switch r.Method {
+	case http.MethodGet:
+		return writeJSON(w, http.StatusOK, h.db.Status().Compaction)
+	case http.MethodPost:
+		result, err := h.db.Compact()
+		if err != nil {
+			return dbError(w, err)
+		}
+		return writeJSON(w, http.StatusOK, result)
+	case http.MethodPut:
+		var body struct {
+			Paused *bool `json:"paused"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.Paused == nil {
+			return errorf(err, http.StatusBadRequest, `expected {"paused": true|false}`)
+		}
+		h.db.SetCompactionPaused(*body.Paused)
+		return writeJSON(w, http.StatusOK, h.db.Status().Compaction)
+	default:
+		return errorf(fmt.Errorf(""), http.StatusMethodNotAllowed, r.Method+": method not allowed")
+	}
+}
+
+// sealHandler seals the active segment and returns the segments.
+func (h *handler) sealHandler(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != http.MethodPost {
+		return errorf(fmt.Errorf(""), http.StatusMethodNotAllowed, r.Method+": method not allowed")
+	}
+	if err := h.db.Seal(); err != nil {
+		return dbError(w, err)
+	}
+	return writeJSON(w, http.StatusOK, h.db.Status().Segments)
+}
+
+// readOnly is the body of /admin/readonly.
+type readOnly struct {
+	ReadOnly *bool `json:"readOnly"`
+}
+
+// readOnlyHandler shows (GET) or switches (PUT {"readOnly": true}) the
+// read-only maintenance mode.
+func (h *handler) readOnlyHandler(w http.ResponseWriter, r *http.Request) error {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPut:
+		var body readOnly
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.ReadOnly == nil {
+			return errorf(err, http.StatusBadRequest, `expected {"readOnly": true|false}`)
+		}
+		h.db.SetReadOnly(*body.ReadOnly)
+	default:
+		return errorf(fmt.Errorf(""), http.StatusMethodNotAllowed, r.Method+": method not allowed")
+	}
+	status := h.db.Status().ReadOnly
+	return writeJSON(w, http.StatusOK, readOnly{&status})
+}
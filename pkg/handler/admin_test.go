@@ -0,0 +1,206 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gerlacdt/db-key-value-store/pkg/db"
+	"github.com/mattetti/filebuffer"
+)
+
+func TestHttpExportImport(t *testing.T) {
+	r := setup(t)
+	srv := httptest.NewServer(r)
+	defer srv.Close()
+
+	value := []byte("bar")
+	resp, err := http.Post(fmt.Sprintf("%s/db/foo", srv.URL), "application/octet-stream", bytes.NewReader(value))
+	if err != nil {
+		t.Fatalf("error http SET %v", err)
+	}
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("statusCode expected %d, got %d", http.StatusCreated, resp.StatusCode)
+	}
+
+	// act
+	resp, err = http.Get(fmt.Sprintf("%s/admin/export?format=pb", srv.URL))
+	if err != nil {
+		t.Fatalf("error http export %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("statusCode expected %d, got %d", http.StatusOK, resp.StatusCode)
+	}
+	dump, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatalf("error reading export %v", err)
+	}
+
+	target := httptest.NewServer(newHandler(t))
+	defer target.Close()
+	resp, err = http.Post(fmt.Sprintf("%s/admin/import?format=pb", target.URL), "application/octet-stream", bytes.NewReader(dump))
+	if err != nil {
+		t.Fatalf("error http import %v", err)
+	}
+	var result struct {
+		Imported int `json:"imported"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&result)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatalf("error decoding import result %v", err)
+	}
+
+	// assert
+	if result.Imported != 1 {
+		t.Fatalf("imported expected %d, got %d", 1, result.Imported)
+	}
+	resp, err = http.Get(fmt.Sprintf("%s/db/foo", target.URL))
+	if err != nil {
+		t.Fatalf("error http GET %v", err)
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(body) != string(value) {
+		t.Fatalf("body expected %s, got %s", value, body)
+	}
+}
+
+func TestHttpExportUnknownFormat(t *testing.T) {
+	r := setup(t)
+	srv := httptest.NewServer(r)
+	defer srv.Close()
+
+	resp, err := http.Get(fmt.Sprintf("%s/admin/export?format=xml", srv.URL))
+	if err != nil {
+		t.Fatalf("error http export %v", err)
+	}
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("statusCode expected %d, got %d", http.StatusBadRequest, resp.StatusCode)
+	}
+}
+
+func TestHttpBackup(t *testing.T) {
+	r := setup(t)
+	srv := httptest.NewServer(r)
+	defer srv.Close()
+
+	value := []byte("bar")
+	resp, err := http.Post(fmt.Sprintf("%s/db/foo", srv.URL), "application/octet-stream", bytes.NewReader(value))
+	if err != nil {
+		t.Fatalf("error http SET %v", err)
+	}
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("statusCode expected %d, got %d", http.StatusCreated, resp.StatusCode)
+	}
+
+	// act
+	resp, err = http.Get(fmt.Sprintf("%s/admin/backup", srv.URL))
+	if err != nil {
+		t.Fatalf("error http backup %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("statusCode expected %d, got %d", http.StatusOK, resp.StatusCode)
+	}
+	restored, err := db.Restore(filebuffer.New(nil), resp.Body)
+	if err != nil {
+		t.Fatalf("error restoring backup %v", err)
+	}
+
+	// assert
+	entity, err := restored.Get("foo")
+	if err != nil {
+		t.Fatalf("error getting entity %v", err)
+	}
+	if entity == nil || string(entity.Value) != string(value) {
+		t.Fatalf("value expected %s, got %v", value, entity)
+	}
+}
+
+func TestHttpAdminMaintenance(t *testing.T) {
+	t.Parallel()
+	d, err := db.Open(db.NewMemFS(), "db")
+	if err != nil {
+		t.Fatalf("error opening %v", err)
+	}
+	h, err := New(d, WithSeparateAdmin())
+	if err != nil {
+		t.Fatalf("could not create handler: %v", err)
+	}
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+	admin := httptest.NewServer(NewAdmin(d))
+	defer admin.Close()
+
+	// the admin group is only served by the admin listener
+	if resp := doRequest(t, "GET", srv.URL+"/admin/stats", nil); resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("statusCode expected %d, got %d", http.StatusNotFound, resp.StatusCode)
+	}
+	for i := 0; i < 3; i++ {
+		if resp := doRequest(t, "POST", srv.URL+"/db/foo", []byte(fmt.Sprintf("bar-%d", i))); resp.StatusCode != http.StatusCreated {
+			t.Fatalf("statusCode expected %d, got %d", http.StatusCreated, resp.StatusCode)
+		}
+	}
+
+	resp := doRequest(t, "POST", admin.URL+"/admin/seal", nil)
+	var segments []db.SegmentInfo
+	decodeAdmin(t, resp, &segments)
+	if len(segments) != 2 || !segments[0].Sealed {
+		t.Fatalf("expected a sealed and an active segment, got %+v", segments)
+	}
+
+	resp = doRequest(t, "PUT", admin.URL+"/admin/compaction", []byte(`{"paused": true}`))
+	var compaction db.CompactionStatus
+	decodeAdmin(t, resp, &compaction)
+	if !compaction.Paused {
+		t.Fatalf("expected paused compaction, got %+v", compaction)
+	}
+	resp = doRequest(t, "POST", admin.URL+"/admin/compaction", nil)
+	if resp.StatusCode != http.StatusConflict {
+		t.Fatalf("statusCode expected %d, got %d", http.StatusConflict, resp.StatusCode)
+	}
+	doRequest(t, "PUT", admin.URL+"/admin/compaction", []byte(`{"paused": false}`))
+	resp = doRequest(t, "POST", admin.URL+"/admin/compaction", nil)
+	var result db.CompactionResult
+	decodeAdmin(t, resp, &result)
+	if result.Records != 1 || result.ReclaimedBytes <= 0 {
+		t.Fatalf("unexpected compaction result %+v", result)
+	}
+
+	resp = doRequest(t, "PUT", admin.URL+"/admin/readonly", []byte(`{"readOnly": true}`))
+	decodeAdmin(t, resp, &struct{}{})
+	resp = doRequest(t, "POST", srv.URL+"/db/foo", []byte("baz"))
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("statusCode expected %d, got %d", http.StatusServiceUnavailable, resp.StatusCode)
+	}
+	if body := decodeError(t, resp); body.Code != CodeReadOnly {
+		t.Fatalf("code expected %s, got %s", CodeReadOnly, body.Code)
+	}
+
+	resp = doRequest(t, "GET", admin.URL+"/admin/stats", nil)
+	var status db.Status
+	decodeAdmin(t, resp, &status)
+	if !status.ReadOnly || status.Keys != 1 || status.DeadBytes != 0 || status.Compaction.Count != 1 || len(status.Segments) != 2 {
+		t.Fatalf("unexpected status %+v", status)
+	}
+	if status.LastRecovery.Time.IsZero() {
+		t.Fatalf("expected time of the last recovery")
+	}
+}
+
+func decodeAdmin(t *testing.T, resp *http.Response, v interface{}) {
+	t.Helper()
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("statusCode expected %d, got %d", http.StatusOK, resp.StatusCode)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		t.Fatalf("error decoding response %v", err)
+	}
+}
@@ -0,0 +1,239 @@
+package handler
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gerlacdt/db-key-value-store/pkg/db"
+	"github.com/gerlacdt/db-key-value-store/pkg/filewatch"
+	"github.com/gerlacdt/db-key-value-store/pkg/metrics"
+)
+
+// Operations of grants.
+const (
+	OpRead   = "read"
+	OpWrite  = "write"
+	OpDelete = "delete"
+	OpAdmin  = "admin"
+)
+
+// AnyNamespace in a grant matches the default keyspace and all namespaces.
+const AnyNamespace = "*"
+
+// Grant allows operations on the keys with the prefix in the namespace, the
+// default keyspace has the empty name. Admin grants with an empty prefix allow
+// the /admin group of the namespace.
+type Grant struct {
+	Namespace string   `json:"namespace"`
+	Prefix    string   `json:"prefix"`
+	Ops       []string `json:"ops"`
+}
+
+// Token is a bearer token with its grants. The name identifies the token in
+// logs, the token itself is never logged.
+type Token struct {
+	Name   string  `json:"name"`
+	Token  string  `json:"token"`
+	Grants []Grant `json:"grants"`
+}
+
+// allows reports whether a grant allows the operation on the keys with the
+// prefix in the namespace.
+func (t *Token) allows(op, namespace, prefix string) bool {
+	for _, g := range t.Grants {
+		if g.Namespace != namespace && g.Namespace != AnyNamespace {
+			continue
+		}
+		if !strings.HasPrefix(prefix, g.Prefix) {
+			continue
+		}
+		for _, o := range g.Ops {
+			if o == op {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Tokens are the bearer tokens of a JSON file with a list of tokens. The file
+// is reloaded by Reload and Watch. It is safe for concurrent use.
+type Tokens struct {
+	file string
+
+	lock    sync.RWMutex
+	tokens  map[[sha256.Size]byte]*Token // by hash, so lookups do not leak the token by timing
+	version filewatch.Version
+
+	denied *metrics.HistogramVec // only counts, by op and reason
+}
+
+// LoadTokens reads the token file.
+func LoadTokens(file string) (*Tokens, error) {
+	t := &Tokens{file: file, denied: metrics.NewHistogramVec(nil, "op", "reason")}
+	if err := t.Reload(); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// Reload reads the token file again. On errors the previous tokens stay in
+// use.
+func (t *Tokens) Reload() error {
+	version := filewatch.Stat(t.file)
+	data, err := ioutil.ReadFile(t.file)
+	if err != nil {
+		return fmt.Errorf("could not read token file %s: %v", t.file, err)
+	}
+	var list []*Token
+	if err := json.Unmarshal(data, &list); err != nil {
+		return fmt.Errorf("could not parse token file %s: %v", t.file, err)
+	}
+	tokens := make(map[[sha256.Size]byte]*Token, len(list))
+	for i, token := range list {
+		if token.Name == "" || token.Token == "" {
+			return fmt.Errorf("token %d of %s needs a name and a token", i, t.file)
+		}
+		hash := sha256.Sum256([]byte(token.Token))
+		if _, ok := tokens[hash]; ok {
+			return fmt.Errorf("token %s of %s is not unique", token.Name, t.file)
+		}
+		for _, g := range token.Grants {
+			for _, op := range g.Ops {
+				if op != OpRead && op != OpWrite && op != OpDelete && op != OpAdmin {
+					return fmt.Errorf("token %s of %s has unknown op %q, read, write, delete and admin are supported", token.Name, t.file, op)
+				}
+			}
+		}
+		tokens[hash] = token
+	}
+
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.tokens, t.version = tokens, version
+	return nil
+}
+
+// Watch reloads the token file after changes until the context is cancelled,
+// see filewatch.Watch.
+func (t *Tokens) Watch(ctx context.Context, interval time.Duration) {
+	filewatch.Watch(ctx, interval, t.loaded, t.Reload, "token file "+t.file)
+}
+
+// loaded returns the version of the token file of the last successful reload.
+func (t *Tokens) loaded() filewatch.Version {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+	return t.version
+}
+
+// lookup returns the token of an Authorization header or nil.
+func (t *Tokens) lookup(header string) *Token {
+	const scheme = "Bearer "
+	if len(header) <= len(scheme) || !strings.EqualFold(header[:len(scheme)], scheme) {
+		return nil
+	}
+	hash := sha256.Sum256([]byte(strings.TrimSpace(header[len(scheme):])))
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+	return t.tokens[hash]
+}
+
+// WithTokens requires a bearer token with a grant for all requests except the
+// health, version and metrics endpoints. Denials are counted in
+// kv_http_auth_denied_total.
+func WithTokens(tokens *Tokens) Option {
+	return func(h *handler) { h.tokens = tokens }
+}
+
+// access is the operation of a request on the keys with the prefix in the
+// namespace.
+type access struct {
+	op, namespace, prefix string
+}
+
+// requestAccess returns the access of the request, false for public
+// endpoints.
+func requestAccess(r *http.Request) (access, bool) {
+	path := r.URL.Path
+	switch {
+	case path == "/healthz" || path == "/readyz" || path == "/version" || path == "/metrics":
+		return access{}, false
+	case strings.HasPrefix(path, "/admin/ns/"):
+		namespace := strings.TrimPrefix(path, "/admin/ns/")
+		if i := strings.Index(namespace, "/"); i >= 0 {
+			namespace = namespace[:i]
+		}
+		return access{op: OpAdmin, namespace: namespace}, true
+	case strings.HasPrefix(path, "/admin/"):
+		return access{op: OpAdmin}, true
+	case strings.HasPrefix(path, "/leases/"):
+		a := access{op: OpWrite, prefix: db.LeasePrefix + strings.TrimPrefix(path, "/leases/")}
+		if r.Method == http.MethodGet {
+			a.op = OpRead
+		}
+		return a, true
+	case strings.HasPrefix(path, "/db/"):
+		return keyAccess(r, "", strings.TrimPrefix(path, "/db/")), true
+	case strings.HasPrefix(path, "/ns/"):
+		path = strings.TrimPrefix(path, "/ns/")
+		if i := strings.Index(path, "/db/"); i >= 0 {
+			return keyAccess(r, path[:i], path[i+len("/db/"):]), true
+		}
+		return access{op: OpRead, namespace: path}, true
+	}
+	// streams and queries of all keys, e.g. /changes and /indexes/
+	return access{op: OpRead}, true
+}
+
+// keyAccess returns the access of a request of the key, a list request is an
+// access of its prefix.
+func keyAccess(r *http.Request, namespace, key string) access {
+	a := access{namespace: namespace, prefix: key}
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		a.op = OpRead
+		if key == "" && r.URL.Query().Get("watch") != "true" {
+			a.prefix = r.URL.Query().Get("prefix")
+		}
+	case http.MethodDelete:
+		a.op = OpDelete
+	default:
+		a.op = OpWrite
+	}
+	return a
+}
+
+// authorize checks the bearer token of the request against the grants.
+func (h *handler) authorize(next http.Handler) http.Handler {
+	return errorMiddleware(func(w http.ResponseWriter, r *http.Request) error {
+		a, ok := requestAccess(r)
+		if !ok {
+			next.ServeHTTP(w, r)
+			return nil
+		}
+		token := h.tokens.lookup(r.Header.Get("Authorization"))
+		if token == nil {
+			h.tokens.denied.With(a.op, "unauthenticated").Observe(0)
+			w.Header().Set("WWW-Authenticate", `Bearer realm="kv"`)
+			return errorf(fmt.Errorf("missing or unknown bearer token"), http.StatusUnauthorized, "valid bearer token required")
+		}
+		if info := getRequestInfo(r.Context()); info != nil {
+			info.token = token.Name
+		}
+		if !token.allows(a.op, a.namespace, a.prefix) {
+			h.tokens.denied.With(a.op, "forbidden").Observe(0)
+			return errorf(fmt.Errorf("token %s has no %s grant for %q in namespace %q", token.Name, a.op, a.prefix, a.namespace),
+				http.StatusForbidden, "permission denied")
+		}
+		next.ServeHTTP(w, r)
+		return nil
+	})
+}
@@ -0,0 +1,191 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gerlacdt/db-key-value-store/pkg/db"
+	"github.com/mattetti/filebuffer"
+)
+
+var testTokens = []Token{
+	{Name: "reader", Token: "reader-secret", Grants: []Grant{{Prefix: "user-", Ops: []string{OpRead}}}},
+	{Name: "writer", Token: "writer-secret", Grants: []Grant{{Prefix: "user-", Ops: []string{OpRead, OpWrite}}}},
+	{Name: "ops", Token: "ops-secret", Grants: []Grant{{Namespace: AnyNamespace, Ops: []string{OpRead, OpWrite, OpDelete, OpAdmin}}}},
+}
+
+func writeTokens(t *testing.T, file string, tokens []Token) {
+	t.Helper()
+	data, err := json.Marshal(tokens)
+	if err != nil {
+		t.Fatalf("error encoding tokens %v", err)
+	}
+	if err := ioutil.WriteFile(file, data, 0600); err != nil {
+		t.Fatalf("error writing tokens %v", err)
+	}
+}
+
+// authRequest sends a request with the bearer token and returns the status
+// code and the error code of the response.
+func authRequest(t *testing.T, method, url, token string) (int, string) {
+	t.Helper()
+	var body []byte
+	if method == http.MethodPost {
+		body = []byte("value")
+	}
+	req, err := http.NewRequest(method, url, bytes.NewReader(body))
+	if err != nil {
+		t.Fatalf("error creating request %v", err)
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("error http %s %v", method, err)
+	}
+	if resp.StatusCode < 400 {
+		resp.Body.Close()
+		return resp.StatusCode, ""
+	}
+	if resp.StatusCode == http.StatusUnauthorized && resp.Header.Get("WWW-Authenticate") == "" {
+		t.Fatalf("WWW-Authenticate header expected")
+	}
+	return resp.StatusCode, decodeError(t, resp).Code
+}
+
+func TestHttpAuth(t *testing.T) {
+	t.Parallel()
+	file := filepath.Join(t.TempDir(), "tokens.json")
+	writeTokens(t, file, testTokens)
+	tokens, err := LoadTokens(file)
+	if err != nil {
+		t.Fatalf("error loading tokens %v", err)
+	}
+	var accessLog bytes.Buffer
+	h, err := New(db.New(filebuffer.New(nil)), WithTokens(tokens), WithAccessLog(&accessLog, LevelWarn, 1))
+	if err != nil {
+		t.Fatalf("could not create handler: %v", err)
+	}
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+
+	tests := []struct {
+		method, path, token string
+		status              int
+		code                string
+	}{
+		{"GET", "/healthz", "", http.StatusOK, ""},
+		{"GET", "/db/user-1", "", http.StatusUnauthorized, CodeUnauthenticated},
+		{"GET", "/db/user-1", "unknown", http.StatusUnauthorized, CodeUnauthenticated},
+		{"POST", "/db/user-1", "reader-secret", http.StatusForbidden, CodePermissionDenied},
+		{"POST", "/db/user-1", "writer-secret", http.StatusCreated, ""},
+		{"POST", "/db/order-1", "writer-secret", http.StatusForbidden, CodePermissionDenied},
+		{"GET", "/db/user-1", "reader-secret", http.StatusOK, ""},
+		{"GET", "/db/order-1", "reader-secret", http.StatusForbidden, CodePermissionDenied},
+		{"GET", "/db/?prefix=user-", "reader-secret", http.StatusOK, ""},
+		{"GET", "/db/?prefix=", "reader-secret", http.StatusForbidden, CodePermissionDenied},
+		{"GET", "/changes", "reader-secret", http.StatusForbidden, CodePermissionDenied},
+		{"DELETE", "/db/user-1", "writer-secret", http.StatusForbidden, CodePermissionDenied},
+		{"GET", "/admin/stats", "writer-secret", http.StatusForbidden, CodePermissionDenied},
+		{"GET", "/admin/stats", "ops-secret", http.StatusOK, ""},
+		{"DELETE", "/db/user-1", "ops-secret", http.StatusOK, ""},
+	}
+	for _, tt := range tests {
+		status, code := authRequest(t, tt.method, srv.URL+tt.path, tt.token)
+		if status != tt.status || code != tt.code {
+			t.Fatalf("%s %s with %q: expected %d %s, got %d %s", tt.method, tt.path, tt.token, tt.status, tt.code, status, code)
+		}
+	}
+
+	// denials are logged with the name of the token and counted
+	if !strings.Contains(accessLog.String(), `"token":"reader"`) || strings.Contains(accessLog.String(), "reader-secret") {
+		t.Fatalf("expected denials logged with the token name, got %s", accessLog.String())
+	}
+	resp, err := http.Get(srv.URL + "/metrics")
+	if err != nil {
+		t.Fatalf("error http GET %v", err)
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	for _, sample := range []string{
+		`kv_http_auth_denied_total{op="read",reason="unauthenticated"} 2`,
+		`kv_http_auth_denied_total{op="write",reason="forbidden"} 2`,
+	} {
+		if !strings.Contains(string(body), sample) {
+			t.Fatalf("expected %s in metrics\n%s", sample, body)
+		}
+	}
+}
+
+func TestTokensReload(t *testing.T) {
+	t.Parallel()
+	file := filepath.Join(t.TempDir(), "tokens.json")
+	writeTokens(t, file, testTokens[:1])
+	tokens, err := LoadTokens(file)
+	if err != nil {
+		t.Fatalf("error loading tokens %v", err)
+	}
+	h, err := New(db.New(filebuffer.New(nil)), WithTokens(tokens))
+	if err != nil {
+		t.Fatalf("could not create handler: %v", err)
+	}
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+
+	if status, _ := authRequest(t, "POST", srv.URL+"/db/user-1", "writer-secret"); status != http.StatusUnauthorized {
+		t.Fatalf("statusCode expected %d, got %d", http.StatusUnauthorized, status)
+	}
+	writeTokens(t, file, testTokens)
+	if err := tokens.Reload(); err != nil {
+		t.Fatalf("error reloading tokens %v", err)
+	}
+	if status, _ := authRequest(t, "POST", srv.URL+"/db/user-1", "writer-secret"); status != http.StatusCreated {
+		t.Fatalf("statusCode expected %d, got %d", http.StatusCreated, status)
+	}
+
+	// an invalid file keeps the previous tokens
+	invalid := []Token{{Name: "bad", Token: "bad-secret", Grants: []Grant{{Ops: []string{"execute"}}}}}
+	writeTokens(t, file, invalid)
+	if err := tokens.Reload(); err == nil {
+		t.Fatalf("expected error for unknown op")
+	}
+	if status, _ := authRequest(t, "GET", srv.URL+"/db/user-1", "reader-secret"); status != http.StatusOK {
+		t.Fatalf("statusCode expected %d, got %d", http.StatusOK, status)
+	}
+}
+
+func TestRequestAccess(t *testing.T) {
+	tests := []struct {
+		method, path string
+		public       bool
+		expected     access
+	}{
+		{"GET", "/metrics", true, access{}},
+		{"GET", "/ns/team-a/db/user-1", false, access{OpRead, "team-a", "user-1"}},
+		{"POST", "/ns/team-a/db/user-1?op=incr", false, access{OpWrite, "team-a", "user-1"}},
+		{"GET", "/db/user-?watch=true&prefix=true", false, access{OpRead, "", "user-"}},
+		{"DELETE", "/leases/leader", false, access{OpWrite, "", db.LeasePrefix + "leader"}},
+		{"PUT", "/admin/ns/team-a", false, access{OpAdmin, "team-a", ""}},
+		{"POST", "/admin/ns/team-a/compaction", false, access{OpAdmin, "team-a", ""}},
+		{"GET", "/indexes/email?eq=a", false, access{OpRead, "", ""}},
+	}
+	for _, tt := range tests {
+		u, err := url.Parse(tt.path)
+		if err != nil {
+			t.Fatalf("error parsing %s: %v", tt.path, err)
+		}
+		a, ok := requestAccess(&http.Request{Method: tt.method, URL: u})
+		if ok == tt.public || a != tt.expected {
+			t.Fatalf("%s %s: expected %+v, got %+v", tt.method, tt.path, tt.expected, a)
+		}
+	}
+}
@@ -0,0 +1,54 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gerlacdt/db-key-value-store/pkg/db"
+)
+
+// changesHandler streams committed writes as chunked NDJSON. Without the since
+// parameter only new writes are streamed, otherwise all writes with a greater
+// sequence number.
+func (h *handler) changesHandler(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != http.MethodGet {
+		return errorf(fmt.Errorf(""), http.StatusMethodNotAllowed, r.Method+": method not allowed")
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		return errorf(fmt.Errorf(""), http.StatusInternalServerError, "streaming not supported")
+	}
+	since := h.db.Seq()
+	if s := r.URL.Query().Get("since"); s != "" {
+		var err error
+		since, err = strconv.ParseUint(s, 10, 64)
+		if err != nil {
+			return errorf(err, http.StatusBadRequest, "since must be a sequence number")
+		}
+	}
+
+	sub := h.db.Subscribe(since + 1)
+	defer sub.Close()
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+	enc := json.NewEncoder(w)
+	for {
+		select {
+		case entity, ok := <-sub.Events():
+			if !ok {
+				// the status is already sent, a lagging client reconnects with its last sequence number
+				return nil
+			}
+			if err := enc.Encode(db.NewRecord(entity)); err != nil {
+				return fmt.Errorf("error writing to http response: %v", err)
+			}
+			flusher.Flush()
+		case <-r.Context().Done():
+			return nil
+		}
+	}
+}
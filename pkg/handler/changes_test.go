@@ -0,0 +1,65 @@
+package handler
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gerlacdt/db-key-value-store/pkg/db"
+)
+
+func TestHttpChanges(t *testing.T) {
+	r := setup(t)
+	srv := httptest.NewServer(r)
+	defer srv.Close()
+
+	value := []byte("bar")
+	resp, err := http.Post(fmt.Sprintf("%s/db/foo", srv.URL), "application/octet-stream", bytes.NewReader(value))
+	if err != nil {
+		t.Fatalf("error http SET %v", err)
+	}
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("statusCode expected %d, got %d", http.StatusCreated, resp.StatusCode)
+	}
+
+	// act
+	resp, err = http.Get(fmt.Sprintf("%s/changes?since=0", srv.URL))
+	if err != nil {
+		t.Fatalf("error http changes %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("statusCode expected %d, got %d", http.StatusOK, resp.StatusCode)
+	}
+	req, err := http.NewRequest("DELETE", fmt.Sprintf("%s/db/foo", srv.URL), nil)
+	if err != nil {
+		t.Fatalf("error creating DELETE request %v", err)
+	}
+	if _, err = http.DefaultClient.Do(req); err != nil {
+		t.Fatalf("error DELETE %v", err)
+	}
+
+	// assert
+	scanner := bufio.NewScanner(resp.Body)
+	var records []db.Record
+	for len(records) < 2 && scanner.Scan() {
+		var rec db.Record
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			t.Fatalf("error decoding change %v", err)
+		}
+		records = append(records, rec)
+	}
+	if len(records) != 2 {
+		t.Fatalf("changes expected %d, got %d", 2, len(records))
+	}
+	if records[0].Key != "foo" || string(records[0].Value) != string(value) || records[0].Seq != 1 {
+		t.Fatalf("unexpected first change %+v", records[0])
+	}
+	if records[1].Key != "foo" || !records[1].Tombstone || records[1].Seq != 2 {
+		t.Fatalf("unexpected second change %+v", records[1])
+	}
+}
@@ -0,0 +1,143 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/gerlacdt/db-key-value-store/pkg/db"
+)
+
+// Error codes of error responses. They are stable, clients should match the
+// code instead of the message.
+const (
+	CodeInvalidArgument      = "invalid_argument"
+	CodeInvalidKey           = "invalid_key"
+	CodeUnauthenticated      = "unauthenticated"
+	CodePermissionDenied     = "permission_denied"
+	CodeNotFound             = "not_found"
+	CodeMethodNotAllowed     = "method_not_allowed"
+	CodeConflict             = "conflict"
+	CodeTooLarge             = "too_large"
+	CodeQuotaExceeded        = "quota_exceeded"
+	CodeUnsupportedMediaType = "unsupported_media_type"
+	CodeRateLimited          = "rate_limited"
+	CodeCorrupt              = "corrupt"
+	CodeInternal             = "internal"
+	CodeReadOnly             = "read_only"
+	CodeUnavailable          = "unavailable"
+)
+
+// statusCodes are the default error codes of http status codes.
+var statusCodes = map[int]string{
+	http.StatusBadRequest:            CodeInvalidArgument,
+	http.StatusUnauthorized:          CodeUnauthenticated,
+	http.StatusForbidden:             CodePermissionDenied,
+	http.StatusNotFound:              CodeNotFound,
+	http.StatusMethodNotAllowed:      CodeMethodNotAllowed,
+	http.StatusConflict:              CodeConflict,
+	http.StatusRequestEntityTooLarge: CodeTooLarge,
+	http.StatusUnsupportedMediaType:  CodeUnsupportedMediaType,
+	http.StatusTooManyRequests:       CodeRateLimited,
+	http.StatusServiceUnavailable:    CodeUnavailable,
+}
+
+// ErrorResponse is the body of all error responses.
+type ErrorResponse struct {
+	Code      string `json:"code"`
+	Message   string `json:"message"`
+	RequestID string `json:"requestId,omitempty"`
+}
+
+// httpError contains http status code
+type httpError struct {
+	err     error
+	code    int
+	errCode string
+	msg     string
+}
+
+// errorf constructor, the error code is the default code of the status.
+func errorf(err error, code int, msg string) error {
+	errCode, ok := statusCodes[code]
+	if !ok {
+		errCode = CodeInternal
+	}
+	return &httpError{err: err, code: code, errCode: errCode, msg: msg}
+}
+
+// errorCodef returns an error with an explicit error code.
+func errorCodef(err error, code int, errCode, msg string) error {
+	return &httpError{err: err, code: code, errCode: errCode, msg: msg}
+}
+
+func (e *httpError) Error() string { return fmt.Sprintf("%v: %v [%d]", e.err, e.msg, e.code) }
+
+func (e *httpError) Unwrap() error { return e.err }
+
+// dbError maps the errors of the database to status and error codes. Unknown
+// errors are internal errors.
+func dbError(w http.ResponseWriter, err error) error {
+	switch {
+	case errors.Is(err, db.ErrNotFound):
+		return errorCodef(err, http.StatusNotFound, CodeNotFound, "key does not exist")
+	case errors.Is(err, db.ErrNamespaceNotFound):
+		return errorCodef(err, http.StatusNotFound, CodeNotFound, "namespace does not exist")
+	case errors.Is(err, db.ErrUnknownIndex):
+		return errorCodef(err, http.StatusNotFound, CodeNotFound, "index does not exist")
+	case errors.Is(err, db.ErrInvalidKey):
+		return errorCodef(err, http.StatusBadRequest, CodeInvalidKey, err.Error())
+	case errors.Is(err, db.ErrNamespaceExists):
+		return errorCodef(err, http.StatusConflict, CodeConflict, "namespace already exists")
+	case errors.Is(err, db.ErrLeaseHeld), errors.Is(err, db.ErrLeaseNotHeld), errors.Is(err, db.ErrInvalidMerge),
+		errors.Is(err, db.ErrCompactionPaused), errors.Is(err, db.ErrCompactionRunning), errors.Is(err, db.ErrNoSegments):
+		return errorCodef(err, http.StatusConflict, CodeConflict, err.Error())
+	case errors.Is(err, db.ErrCompacted):
+		return errorCodef(err, http.StatusServiceUnavailable, CodeUnavailable, "log compacted during the read, retry")
+	case errors.Is(err, db.ErrTooLarge):
+		return errorCodef(err, http.StatusRequestEntityTooLarge, CodeTooLarge, err.Error())
+	case errors.Is(err, db.ErrQuotaExceeded):
+		return errorCodef(err, http.StatusRequestEntityTooLarge, CodeQuotaExceeded, err.Error())
+	case errors.Is(err, db.ErrRateLimited):
+		w.Header().Set("Retry-After", "1")
+		return errorCodef(err, http.StatusTooManyRequests, CodeRateLimited, err.Error())
+	case errors.Is(err, db.ErrReadOnly):
+		return errorCodef(err, http.StatusServiceUnavailable, CodeReadOnly, "database is read-only")
+	case errors.Is(err, db.ErrClosed):
+		return errorCodef(err, http.StatusServiceUnavailable, CodeUnavailable, "database closed")
+	case errors.Is(err, db.ErrCorrupt):
+		return errorCodef(err, http.StatusInternalServerError, CodeCorrupt, "database record is corrupt")
+	default:
+		return err
+	}
+}
+
+// errorMiddleware wraps a normal handler and converts errors to corresponding http status codes
+type errorMiddleware func(http.ResponseWriter, *http.Request) error
+
+func (fn errorMiddleware) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	err := fn(w, r)
+	if err == nil {
+		return
+	}
+	if info := getRequestInfo(r.Context()); info != nil {
+		info.err = err
+	}
+
+	// the causes of internal errors are logged, not returned
+	status := http.StatusInternalServerError
+	resp := ErrorResponse{Code: CodeInternal, Message: "internal server error", RequestID: requestID(r.Context())}
+	if herr, ok := err.(*httpError); ok {
+		status, resp.Code, resp.Message = herr.code, herr.errCode, herr.msg
+	}
+
+	body, err := json.Marshal(resp)
+	if err != nil {
+		http.Error(w, resp.Message, http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(body)
+}
@@ -0,0 +1,147 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gerlacdt/db-key-value-store/pb"
+	"github.com/gerlacdt/db-key-value-store/pkg/db"
+)
+
+// decodeError decodes the error response and checks the request ID.
+func decodeError(t *testing.T, resp *http.Response) ErrorResponse {
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "application/json" {
+		t.Fatalf("Content-Type expected application/json, got %s", ct)
+	}
+	var body ErrorResponse
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		t.Fatalf("error decoding error response %v", err)
+	}
+	if body.RequestID == "" || body.RequestID != resp.Header.Get(requestIDHeader) {
+		t.Fatalf("requestId expected %q, got %q", resp.Header.Get(requestIDHeader), body.RequestID)
+	}
+	return body
+}
+
+func TestHttpErrors(t *testing.T) {
+	r := setup(t)
+	srv := httptest.NewServer(r)
+	defer srv.Close()
+
+	tests := []struct {
+		name    string
+		method  string
+		path    string
+		ctype   string
+		status  int
+		code    string
+		message string
+	}{
+		{"get missing", "GET", "/db/missing", "", http.StatusNotFound, CodeNotFound, "key does not exist"},
+		{"delete missing", "DELETE", "/db/missing", "", http.StatusNotFound, CodeNotFound, "key does not exist"},
+		{"invalid key", "GET", "/db/foo/bar", "", http.StatusBadRequest, CodeInvalidKey, "requested key not valid"},
+		{"content type", "POST", "/db/foo", "text/", http.StatusUnsupportedMediaType, CodeUnsupportedMediaType, ""},
+		{"method", "PUT", "/db/foo", "", http.StatusMethodNotAllowed, CodeMethodNotAllowed, "PUT: method not allowed"},
+		{"invalid merge", "POST", "/db/foo?op=incr&by=x", "", http.StatusBadRequest, CodeInvalidArgument, "by must be an integer"},
+	}
+	for _, tt := range tests {
+		req, err := http.NewRequest(tt.method, srv.URL+tt.path, nil)
+		if err != nil {
+			t.Fatalf("error creating request %v", err)
+		}
+		if tt.ctype != "" {
+			req.Header.Set("Content-Type", tt.ctype)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("%s: error http %s %v", tt.name, tt.method, err)
+		}
+		if resp.StatusCode != tt.status {
+			t.Fatalf("%s: statusCode expected %d, got %d", tt.name, tt.status, resp.StatusCode)
+		}
+		body := decodeError(t, resp)
+		if body.Code != tt.code {
+			t.Fatalf("%s: code expected %s, got %s", tt.name, tt.code, body.Code)
+		}
+		if body.Message == "" || (tt.message != "" && body.Message != tt.message) {
+			t.Fatalf("%s: message expected %q, got %q", tt.name, tt.message, body.Message)
+		}
+	}
+}
+
+func TestHttpErrorRequestID(t *testing.T) {
+	r := setup(t)
+	srv := httptest.NewServer(r)
+	defer srv.Close()
+
+	req, err := http.NewRequest("GET", srv.URL+"/db/missing", nil)
+	if err != nil {
+		t.Fatalf("error creating request %v", err)
+	}
+	req.Header.Set(requestIDHeader, "req-1")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("error http GET %v", err)
+	}
+	if body := decodeError(t, resp); body.RequestID != "req-1" {
+		t.Fatalf("requestId expected req-1, got %s", body.RequestID)
+	}
+}
+
+func TestHttpReadOnlyError(t *testing.T) {
+	t.Parallel()
+	fs := db.NewFaultFS(db.NewMemFS())
+	d, err := db.Open(fs, "db")
+	if err != nil {
+		t.Fatalf("error opening %v", err)
+	}
+	h, err := New(d)
+	if err != nil {
+		t.Fatalf("could not create handler: %v", err)
+	}
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+
+	// the failed write makes the database read-only
+	fs.FailAfter(0)
+	if err := d.Set(&pb.Entity{Key: "foo"}); err == nil {
+		t.Fatalf("expected injected write error")
+	}
+	resp := doRequest(t, "POST", fmt.Sprintf("%s/db/foo", srv.URL), []byte("bar"))
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("statusCode expected %d, got %d", http.StatusServiceUnavailable, resp.StatusCode)
+	}
+	if body := decodeError(t, resp); body.Code != CodeReadOnly {
+		t.Fatalf("code expected %s, got %s", CodeReadOnly, body.Code)
+	}
+}
+
+func TestDbError(t *testing.T) {
+	tests := []struct {
+		err    error
+		status int
+		code   string
+	}{
+		{db.ErrNotFound, http.StatusNotFound, CodeNotFound},
+		{fmt.Errorf("wrapped: %w", db.ErrCorrupt), http.StatusInternalServerError, CodeCorrupt},
+		{db.ErrReadOnly, http.StatusServiceUnavailable, CodeReadOnly},
+		{db.ErrTooLarge, http.StatusRequestEntityTooLarge, CodeTooLarge},
+		{db.ErrQuotaExceeded, http.StatusRequestEntityTooLarge, CodeQuotaExceeded},
+		{db.ErrRateLimited, http.StatusTooManyRequests, CodeRateLimited},
+		{db.ErrClosed, http.StatusServiceUnavailable, CodeUnavailable},
+		{db.ErrLeaseHeld, http.StatusConflict, CodeConflict},
+	}
+	for _, tt := range tests {
+		herr, ok := dbError(httptest.NewRecorder(), tt.err).(*httpError)
+		if !ok {
+			t.Fatalf("%v: expected *httpError", tt.err)
+		}
+		if herr.code != tt.status || herr.errCode != tt.code {
+			t.Fatalf("%v: expected %d %s, got %d %s", tt.err, tt.status, tt.code, herr.code, herr.errCode)
+		}
+	}
+}
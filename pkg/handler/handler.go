@@ -1,13 +1,24 @@
 package handler
 
 import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	"io"
 	"io/ioutil"
+	"mime"
 	"net/http"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/gerlacdt/db-key-value-store/pb"
+	"github.com/gerlacdt/db-key-value-store/pkg/db"
+	"github.com/gerlacdt/db-key-value-store/pkg/metrics"
 )
 
 // DB provides all the methods needed for storage.
@@ -16,20 +27,75 @@ type DB interface {
 	Get(string) (*pb.Entity, error)
 	Set(*pb.Entity) error
 	Delete(string) error
+	Merge(string, db.MergeOp) (*pb.Entity, error)
+	Lease(string) (*db.Lease, error)
+	Acquire(string, string, time.Duration) (*db.Lease, error)
+	Renew(string, uint64, time.Duration) (*db.Lease, error)
+	Release(string, uint64) error
+	Export(io.Writer, db.Format) error
+	Import(io.Reader, db.Format) (int, error)
+	Snapshot() (*db.Snapshot, error)
+	Seq() uint64
+	Keys(string, int) []string
+	Subscribe(uint64) *db.Subscription
+	Watch(context.Context, string, bool, uint64) (*pb.Entity, error)
+	Query(string, db.IndexQuery) ([]string, error)
+	Stats() db.Stats
+	Status() db.Status
+	Metrics() db.Metrics
+	QuotaUsage() []db.QuotaUsage
+	Limits() db.Limits
+	Seal() error
+	Compact() (db.CompactionResult, error)
+	SetCompactionPaused(bool)
+	SetReadOnly(bool)
+}
+
+// Namespaces provides databases with isolated keyspaces.
+type Namespaces interface {
+	Namespace(string) (*db.DB, error)
+	Create(string) (*db.DB, error)
+	Drop(string) error
+	List() []string
 }
 
 // handler holds all http methods.
-type handler struct{ db DB }
+type handler struct {
+	db         DB
+	namespaces Namespaces
+	requests   *metrics.HistogramVec
+	accessLog  *accessLog
+	tokens     *Tokens // bearer tokens, authentication is disabled if nil
+	noAdmin    bool    // the /admin group is served by NewAdmin
+}
+
+// Option configures optional features of the handler.
+type Option func(*handler)
+
+// WithNamespaces serves the namespaces under /ns/<name>/db/<key> and
+// /admin/ns.
+func WithNamespaces(namespaces Namespaces) Option {
+	return func(h *handler) { h.namespaces = namespaces }
+}
+
+// WithSeparateAdmin removes the /admin group from the handler of New, so it
+// is only served by NewAdmin on a separate listener.
+func WithSeparateAdmin() Option {
+	return func(h *handler) { h.noAdmin = true }
+}
 
 // New creates all http handlers.
-func New(db DB) (http.Handler, error) {
+func New(db DB, opts ...Option) (http.Handler, error) {
 	r := http.NewServeMux()
 
 	if err := db.Recover(); err != nil {
 		return nil, fmt.Errorf("could not recover database: %v", err)
 	}
 
-	h := &handler{db}
+	h := &handler{db: db, requests: metrics.NewHistogramVec(metrics.DefBuckets, "method", "status")}
+	for _, opt := range opts {
+		opt(h)
+	}
 	r.Handle("/db/", errorMiddleware(h.handleDb))
 	r.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusOK)
@@ -38,7 +104,52 @@ func New(db DB) (http.Handler, error) {
 		w.WriteHeader(http.StatusOK)
 	})
 	r.Handle("/version", errorMiddleware(versionHandler))
-	return r, nil
+	r.Handle("/metrics", errorMiddleware(h.metricsHandler))
+	r.Handle("/changes", errorMiddleware(h.changesHandler))
+	r.Handle("/indexes/", errorMiddleware(h.queryHandler))
+	r.Handle("/leases/", errorMiddleware(h.leaseHandler))
+	r.Handle("/ns/", errorMiddleware(h.namespaceHandler))
+	if !h.noAdmin {
+		h.adminRoutes(r)
+	}
+	return h.requestMiddleware(h.instrument(h.withAuth(r))), nil
+}
+
+// NewAdmin creates the handlers of the /admin group for a separate listener.
+// The database must be recovered, e.g. by New.
+func NewAdmin(db DB, opts ...Option) http.Handler {
+	r := http.NewServeMux()
+	h := &handler{db: db, requests: metrics.NewHistogramVec(metrics.DefBuckets, "method", "status")}
+	for _, opt := range opts {
+		opt(h)
+	}
+	r.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	h.adminRoutes(r)
+	return h.requestMiddleware(h.withAuth(r))
+}
+
+// withAuth authorizes the requests if tokens are configured.
+func (h *handler) withAuth(next http.Handler) http.Handler {
+	if h.tokens == nil {
+		return next
+	}
+	return h.authorize(next)
+}
+
+// adminRoutes registers the /admin group.
+func (h *handler) adminRoutes(r *http.ServeMux) {
+	r.Handle("/admin/export", errorMiddleware(h.exportHandler))
+	r.Handle("/admin/import", errorMiddleware(h.importHandler))
+	r.Handle("/admin/backup", errorMiddleware(h.backupHandler))
+	r.Handle("/admin/stats", errorMiddleware(h.statsHandler))
+	r.Handle("/admin/quotas", errorMiddleware(h.quotasHandler))
+	r.Handle("/admin/compaction", errorMiddleware(h.compactionHandler))
+	r.Handle("/admin/seal", errorMiddleware(h.sealHandler))
+	r.Handle("/admin/readonly", errorMiddleware(h.readOnlyHandler))
+	r.Handle("/admin/ns", errorMiddleware(h.namespacesHandler))
+	r.Handle("/admin/ns/", errorMiddleware(h.namespaceAdminHandler))
 }
 
 func versionHandler(w http.ResponseWriter, r *http.Request) error {
@@ -61,50 +172,19 @@ func versionHandler(w http.ResponseWriter, r *http.Request) error {
 	return nil
 }
 
-// httpError contains http status code
-type httpError struct {
-	err  error
-	code int
-	msg  string
-}
-
-// errorf constructor
-func errorf(err error, code int, msg string) error {
-	return &httpError{err: err, code: code, msg: msg}
-}
-
-func (e *httpError) Error() string { return fmt.Sprintf("%v: %v [%d]", e.err, e.msg, e.code) }
-
-// errorMiddleware wraps a normal handler and converts errors to corresponding http status codes
-type errorMiddleware func(http.ResponseWriter, *http.Request) error
-
-func (fn errorMiddleware) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	err := fn(w, r)
-	if err == nil {
-		return
+// writeJSON writes v as JSON response with the given status code.
+func writeJSON(w http.ResponseWriter, code int, v interface{}) error {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("Could not encode response: %v", err)
 	}
-
-	msg := err.Error()
-	jsonError := struct {
-		msg string `json:"msg"`
-	}{msg}
-
 	w.Header().Set("Content-Type", "application/json")
-
-	if herr, ok := err.(*httpError); ok {
-		w.WriteHeader(herr.code)
-	} else {
-		w.WriteHeader(http.StatusInternalServerError)
-	}
-
-	body, err := json.Marshal(jsonError)
+	w.WriteHeader(code)
+	_, err = w.Write(body)
 	if err != nil {
-		fmt.Printf("Could not encode error data: %v", err)
-		http.Error(w, msg, http.StatusInternalServerError)
-	}
-	if _, err = w.Write(body); err != nil {
-		http.Error(w, msg, http.StatusInternalServerError)
+		return fmt.Errorf("error writing to http response: %v", err)
 	}
+	return nil
 }
 
 func getID(s string) (string, error) {
@@ -116,9 +196,38 @@ func getID(s string) (string, error) {
 	return id, nil
 }
 
+// checkKey checks the key of a write against the limits of the database.
+func checkKey(d DB, key string) error {
+	if err := d.Limits().CheckKey(key); err != nil {
+		return errorCodef(err, http.StatusBadRequest, CodeInvalidKey, err.Error())
+	}
+	return nil
+}
+
+// readBody reads the request body of at most the maximum value size of the
+// database, larger bodies are rejected without reading them completely.
+func readBody(d DB, r *http.Request) ([]byte, error) {
+	max := d.Limits().MaxValue()
+	tooLarge := func(size int64) error {
+		err := fmt.Errorf("%w: body of %d bytes", db.ErrTooLarge, size)
+		return errorCodef(err, http.StatusRequestEntityTooLarge, CodeTooLarge, fmt.Sprintf("value is larger than %d bytes", max))
+	}
+	if r.ContentLength > int64(max) {
+		return nil, tooLarge(r.ContentLength)
+	}
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, int64(max)+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(body) > max {
+		return nil, tooLarge(int64(len(body)))
+	}
+	return body, nil
+}
+
 func (h *handler) handleDb(w http.ResponseWriter, r *http.Request) error {
 	switch r.Method {
-	case http.MethodGet:
+	case http.MethodGet, http.MethodHead:
 		return h.getHandler(w, r)
 	case http.MethodPost:
 		return h.setHandler(w, r)
@@ -132,21 +241,37 @@ func (h *handler) handleDb(w http.ResponseWriter, r *http.Request) error {
 func (h *handler) setHandler(w http.ResponseWriter, r *http.Request) error {
 	key, err := getID(r.URL.Path)
 	if err != nil {
-		return errorf(err, http.StatusBadRequest, "requested key not valid")
+		return errorCodef(err, http.StatusBadRequest, CodeInvalidKey, "requested key not valid")
+	}
+	if err := checkKey(h.db, key); err != nil {
+		return err
+	}
+	if op := r.URL.Query().Get("op"); op != "" {
+		return h.mergeHandler(w, r, key, op)
 	}
 
-	if r.Header.Get("Content-Type") != "application/octet-stream" {
-		return errorf(fmt.Errorf(""), http.StatusBadRequest, "Mime-Type not supported, application/octet-stream is supported")
+	contentType := r.Header.Get("Content-Type")
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	if _, _, err := mime.ParseMediaType(contentType); err != nil {
+		return errorf(err, http.StatusUnsupportedMediaType, "Content-Type is not a valid media type")
 	}
 
-	body, err := ioutil.ReadAll(r.Body)
+	body, err := readBody(h.db, r)
 	if err != nil {
 		return err
 	}
-	entity := &pb.Entity{Key: key, Value: body}
+	entity := &pb.Entity{
+		Key:          key,
+		Value:        body,
+		ContentType:  contentType,
+		CacheControl: r.Header.Get(cacheControlHeader),
+		Metadata:     readMetadata(r.Header),
+	}
 	err = h.db.Set(entity)
 	if err != nil {
-		return err
+		return dbError(w, err)
 	}
 	w.WriteHeader(http.StatusCreated)
 	return nil
@@ -155,31 +280,121 @@ func (h *handler) setHandler(w http.ResponseWriter, r *http.Request) error {
 func (h *handler) getHandler(w http.ResponseWriter, r *http.Request) error {
 	key, err := getID(r.URL.Path)
 	if err != nil {
-		return errorf(err, http.StatusBadRequest, "requested key not valid")
+		return errorCodef(err, http.StatusBadRequest, CodeInvalidKey, "requested key not valid")
+	}
+	if r.URL.Query().Get("watch") == "true" {
+		return h.watchHandler(w, r, key)
+	}
+	if key == "" {
+		return h.listHandler(w, r)
 	}
 	entity, err := h.db.Get(key)
 	if err != nil {
-		return errorf(err, http.StatusInternalServerError, "error GET the requested key")
+		return dbError(w, err)
 	}
 	if entity == nil {
 		return errorf(fmt.Errorf(""), http.StatusNotFound, "key does not exist")
 	}
 
+	header := w.Header()
+	if entity.ContentType != "" {
+		header.Set("Content-Type", entity.ContentType)
+	}
 	if r.URL.Query().Get("format") == "json" {
-		w.Header().Set("Content-Type", "application/json")
+		header.Set("Content-Type", "application/json")
+	}
+	for name, value := range entity.Metadata {
+		header.Set(metaHeaderPrefix+name, value)
+	}
+	if entity.CacheControl != "" {
+		header.Set("Cache-Control", entity.CacheControl)
 	}
-	fmt.Fprintf(w, "%s", entity.Value)
+	header.Set("ETag", etag(entity, header.Get("Content-Type")))
+	// ServeContent sets Last-Modified and Content-Length, answers If-None-Match and If-Modified-Since with 304 and
+	// HEAD without body, without a stored content type the value is sniffed
+	http.ServeContent(w, r, "", time.Unix(0, entity.Timestamp), bytes.NewReader(entity.Value))
 	return nil
 }
 
+// etag returns the strong entity tag of the representation, the hash of the
+// value, the served content type, the cache control and the metadata. The hash
+// of values written before hashes were stored is computed.
+func etag(entity *pb.Entity, contentType string) string {
+	hash := entity.Hash
+	if len(hash) == 0 {
+		hash = db.Hash(entity.Value)
+	}
+	names := make([]string, 0, len(entity.Metadata))
+	for name := range entity.Metadata {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	h := sha256.New()
+	h.Write(hash)
+	// length prefixes, so the fields can not be shifted into each other
+	fields := []string{contentType, entity.CacheControl}
+	for _, name := range names {
+		fields = append(fields, name, entity.Metadata[name])
+	}
+	for _, field := range fields {
+		fmt.Fprintf(h, "%d:%s", len(field), field)
+	}
+	return `"` + hex.EncodeToString(h.Sum(nil)[:16]) + `"`
+}
+
+// cacheControlHeader is the request header of the Cache-Control hint of a
+// value, which is returned with the value. The Cache-Control header of a
+// request is a directive for the request itself, which clients and proxies set
+// on their own.
+const cacheControlHeader = "X-Cache-Control"
+
+// metaHeaderPrefix is the prefix of the headers of the user metadata of a
+// value.
+const metaHeaderPrefix = "X-Meta-"
+
+// readMetadata returns the user metadata of the X-Meta-* headers, the names
+// are lower case without the prefix.
+func readMetadata(header http.Header) map[string]string {
+	var metadata map[string]string
+	for name, values := range header {
+		if len(name) <= len(metaHeaderPrefix) || !strings.EqualFold(name[:len(metaHeaderPrefix)], metaHeaderPrefix) {
+			continue
+		}
+		if metadata == nil {
+			metadata = make(map[string]string)
+		}
+		metadata[strings.ToLower(name[len(metaHeaderPrefix):])] = strings.Join(values, ", ")
+	}
+	return metadata
+}
+
+// listHandler returns the sorted keys, GET /db/?prefix=<prefix>&limit=<n>.
+func (h *handler) listHandler(w http.ResponseWriter, r *http.Request) error {
+	query := r.URL.Query()
+	limit := 0
+	if s := query.Get("limit"); s != "" {
+		var err error
+		limit, err = strconv.Atoi(s)
+		if err != nil || limit < 0 {
+			return errorf(fmt.Errorf("invalid limit %q", s), http.StatusBadRequest, "limit must be a positive number")
+		}
+	}
+	return writeJSON(w, http.StatusOK, h.db.Keys(query.Get("prefix"), limit))
+}
+
 func (h *handler) deleteHandler(w http.ResponseWriter, r *http.Request) error {
 	key, err := getID(r.URL.Path)
 	if err != nil {
-		return errorf(err, http.StatusBadRequest, "requested key not valid")
+		return errorCodef(err, http.StatusBadRequest, CodeInvalidKey, "requested key not valid")
+	}
+	// keys are not checked against the limits, so keys written before stricter limits can be deleted
+	if key == "" {
+		return errorCodef(fmt.Errorf(""), http.StatusBadRequest, CodeInvalidKey, "key is empty")
 	}
 	err = h.db.Delete(key)
 	if err != nil {
-		return errorf(err, http.StatusBadRequest, "requested key does not exist")
+		return dbError(w, err)
 	}
 	w.WriteHeader(http.StatusOK)
 	return nil
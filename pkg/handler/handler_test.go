@@ -2,10 +2,12 @@ package handler
 
 import (
 	"bytes"
+	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"reflect"
 	"testing"
 
 	"github.com/gerlacdt/db-key-value-store/pkg/db"
@@ -14,6 +16,10 @@ import (
 
 func setup(t *testing.T) http.Handler {
 	t.Parallel()
+	return newHandler(t)
+}
+
+func newHandler(t *testing.T) http.Handler {
 	h, err := New(db.New(filebuffer.New(nil)))
 	if err != nil {
 		t.Fatalf("could not create handler: %v", err)
@@ -80,3 +86,297 @@ func TestSingleHttpSetAndGet(t *testing.T) {
 		t.Fatalf("body expected %s, got %s", value, body)
 	}
 }
+
+func TestHttpList(t *testing.T) {
+	r := setup(t)
+	srv := httptest.NewServer(r)
+	defer srv.Close()
+
+	for _, key := range []string{"foo-2", "bar", "foo-1"} {
+		resp := doRequest(t, "POST", fmt.Sprintf("%s/db/%s", srv.URL, key), []byte("value"))
+		if resp.StatusCode != http.StatusCreated {
+			t.Fatalf("statusCode expected %d, got %d", http.StatusCreated, resp.StatusCode)
+		}
+	}
+	resp, err := http.Get(fmt.Sprintf("%s/db/?prefix=foo-", srv.URL))
+	if err != nil {
+		t.Fatalf("error http GET %v", err)
+	}
+	defer resp.Body.Close()
+	var keys []string
+	if err := json.NewDecoder(resp.Body).Decode(&keys); err != nil {
+		t.Fatalf("error decoding keys %v", err)
+	}
+	if !reflect.DeepEqual([]string{"foo-1", "foo-2"}, keys) {
+		t.Fatalf("keys expected %v, got %v", []string{"foo-1", "foo-2"}, keys)
+	}
+}
+
+func TestHttpLimits(t *testing.T) {
+	t.Parallel()
+	d := db.New(filebuffer.New(nil))
+	pattern, err := db.ParseKeyPattern(`[a-z0-9-]+`)
+	if err != nil {
+		t.Fatalf("error parsing pattern %v", err)
+	}
+	d.SetLimits(db.Limits{MaxKeyLength: 10, MaxValueSize: 8, KeyPattern: pattern})
+	h, err := New(d)
+	if err != nil {
+		t.Fatalf("could not create handler: %v", err)
+	}
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+
+	tests := []struct {
+		method, path string
+		body         []byte
+		status       int
+		code         string
+	}{
+		{"POST", "/db/foo", []byte("12345678"), http.StatusCreated, ""},
+		{"POST", "/db/foo", []byte("123456789"), http.StatusRequestEntityTooLarge, CodeTooLarge},
+		{"POST", "/db/foo?op=append", []byte("123456789"), http.StatusRequestEntityTooLarge, CodeTooLarge},
+		{"POST", "/db/foo?op=append", []byte("9"), http.StatusRequestEntityTooLarge, CodeTooLarge},
+		{"POST", "/db/", []byte("value"), http.StatusBadRequest, CodeInvalidKey},
+		{"POST", "/db/very-long-key", []byte("value"), http.StatusBadRequest, CodeInvalidKey},
+		{"POST", "/db/Foo", []byte("value"), http.StatusBadRequest, CodeInvalidKey},
+		{"POST", "/db/foo%0A", []byte("value"), http.StatusBadRequest, CodeInvalidKey},
+		{"DELETE", "/db/", nil, http.StatusBadRequest, CodeInvalidKey},
+	}
+	for _, tt := range tests {
+		resp := doRequest(t, tt.method, srv.URL+tt.path, tt.body)
+		if resp.StatusCode != tt.status {
+			t.Fatalf("%s %s: statusCode expected %d, got %d", tt.method, tt.path, tt.status, resp.StatusCode)
+		}
+		if tt.code == "" {
+			resp.Body.Close()
+			continue
+		}
+		if body := decodeError(t, resp); body.Code != tt.code {
+			t.Fatalf("%s %s: code expected %s, got %s", tt.method, tt.path, tt.code, body.Code)
+		}
+	}
+
+	// bodies without content length are cut off at the limit
+	req, err := http.NewRequest("POST", srv.URL+"/db/bar", ioutil.NopCloser(bytes.NewReader(bytes.Repeat([]byte("x"), 64<<10))))
+	if err != nil {
+		t.Fatalf("error creating request %v", err)
+	}
+	if req.ContentLength != 0 {
+		t.Fatalf("request content length expected 0, got %d", req.ContentLength)
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("error http POST %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusRequestEntityTooLarge {
+		t.Fatalf("statusCode expected %d, got %d", http.StatusRequestEntityTooLarge, resp.StatusCode)
+	}
+}
+
+func TestHttpContentTypeAndMetadata(t *testing.T) {
+	r := setup(t)
+	srv := httptest.NewServer(r)
+	defer srv.Close()
+
+	req, err := http.NewRequest("POST", srv.URL+"/db/doc", bytes.NewReader([]byte("hello")))
+	if err != nil {
+		t.Fatalf("error creating request %v", err)
+	}
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	req.Header.Set("X-Meta-Owner", "alice")
+	req.Header.Set("x-meta-build-id", "42")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("error http POST %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("statusCode expected %d, got %d", http.StatusCreated, resp.StatusCode)
+	}
+
+	for _, method := range []string{"GET", "HEAD"} {
+		resp := doRequest(t, method, srv.URL+"/db/doc", nil)
+		body, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		header := resp.Header
+		if header.Get("Content-Type") != "text/plain; charset=utf-8" || header.Get("X-Meta-Owner") != "alice" ||
+			header.Get("X-Meta-Build-Id") != "42" || header.Get("Content-Length") != "5" {
+			t.Fatalf("%s: unexpected headers %v", method, header)
+		}
+		if expected := map[string]string{"GET": "hello", "HEAD": ""}[method]; string(body) != expected {
+			t.Fatalf("%s: body expected %q, got %q", method, expected, body)
+		}
+	}
+	// format=json overrides the stored content type
+	resp, err = http.Get(srv.URL + "/db/doc?format=json")
+	if err != nil {
+		t.Fatalf("error http GET %v", err)
+	}
+	resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "application/json" {
+		t.Fatalf("Content-Type expected application/json, got %s", ct)
+	}
+
+	// without Content-Type the value is binary
+	req, err = http.NewRequest("POST", srv.URL+"/db/bin", bytes.NewReader([]byte{0, 1}))
+	if err != nil {
+		t.Fatalf("error creating request %v", err)
+	}
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("error http POST %v", err)
+	}
+	resp.Body.Close()
+	resp, err = http.Get(srv.URL + "/db/bin")
+	if err != nil {
+		t.Fatalf("error http GET %v", err)
+	}
+	resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "application/octet-stream" {
+		t.Fatalf("Content-Type expected application/octet-stream, got %s", ct)
+	}
+
+	// metadata is limited
+	req, err = http.NewRequest("POST", srv.URL+"/db/doc", bytes.NewReader([]byte("hello")))
+	if err != nil {
+		t.Fatalf("error creating request %v", err)
+	}
+	req.Header.Set("X-Meta-Large", string(bytes.Repeat([]byte("x"), db.DefaultMaxMetadataSize)))
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("error http POST %v", err)
+	}
+	if resp.StatusCode != http.StatusRequestEntityTooLarge {
+		t.Fatalf("statusCode expected %d, got %d", http.StatusRequestEntityTooLarge, resp.StatusCode)
+	}
+	if body := decodeError(t, resp); body.Code != CodeTooLarge {
+		t.Fatalf("code expected %s, got %s", CodeTooLarge, body.Code)
+	}
+
+	// the export keeps content type and metadata
+	resp, err = http.Get(srv.URL + "/admin/export?format=jsonl")
+	if err != nil {
+		t.Fatalf("error http GET %v", err)
+	}
+	defer resp.Body.Close()
+	dec := json.NewDecoder(resp.Body)
+	var rec db.Record
+	if err := dec.Decode(&rec); err != nil {
+		t.Fatalf("error decoding export %v", err)
+	}
+	if rec.Key != "bin" || rec.ContentType != "application/octet-stream" {
+		t.Fatalf("unexpected record %+v", rec)
+	}
+	rec = db.Record{}
+	if err := dec.Decode(&rec); err != nil {
+		t.Fatalf("error decoding export %v", err)
+	}
+	if rec.ContentType != "text/plain; charset=utf-8" || !reflect.DeepEqual(rec.Metadata, map[string]string{"owner": "alice", "build-id": "42"}) {
+		t.Fatalf("unexpected record %+v", rec)
+	}
+}
+
+func TestHttpConditionalGet(t *testing.T) {
+	r := setup(t)
+	srv := httptest.NewServer(r)
+	defer srv.Close()
+
+	set := func(value string, header http.Header) {
+		req, err := http.NewRequest("POST", srv.URL+"/db/doc", bytes.NewReader([]byte(value)))
+		if err != nil {
+			t.Fatalf("error creating request %v", err)
+		}
+		req.Header.Set("X-Cache-Control", "max-age=60")
+		// a request directive is not stored
+		req.Header.Set("Cache-Control", "no-cache")
+		for name, values := range header {
+			req.Header[name] = values
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("error http POST %v", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusCreated {
+			t.Fatalf("statusCode expected %d, got %d", http.StatusCreated, resp.StatusCode)
+		}
+	}
+	get := func(method string, header http.Header) *http.Response {
+		req, err := http.NewRequest(method, srv.URL+"/db/doc", nil)
+		if err != nil {
+			t.Fatalf("error creating request %v", err)
+		}
+		for name, values := range header {
+			req.Header[name] = values
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("error http %s %v", method, err)
+		}
+		resp.Body.Close()
+		return resp
+	}
+
+	set("hello", nil)
+	resp := get("GET", nil)
+	etag, lastModified := resp.Header.Get("ETag"), resp.Header.Get("Last-Modified")
+	if resp.StatusCode != http.StatusOK || len(etag) != 34 || lastModified == "" {
+		t.Fatalf("expected 200 with ETag and Last-Modified, got %d %v", resp.StatusCode, resp.Header)
+	}
+	if cc := resp.Header.Get("Cache-Control"); cc != "max-age=60" {
+		t.Fatalf("Cache-Control expected max-age=60, got %s", cc)
+	}
+	resp = get("HEAD", nil)
+	if resp.Header.Get("ETag") != etag || resp.Header.Get("Last-Modified") != lastModified ||
+		resp.Header.Get("Content-Length") != "5" {
+		t.Fatalf("HEAD: unexpected headers %v", resp.Header)
+	}
+
+	tests := []struct {
+		method string
+		header http.Header
+		status int
+	}{
+		{"GET", http.Header{"If-None-Match": {etag}}, http.StatusNotModified},
+		{"HEAD", http.Header{"If-None-Match": {etag}}, http.StatusNotModified},
+		{"GET", http.Header{"If-None-Match": {`"other", ` + etag}}, http.StatusNotModified},
+		{"GET", http.Header{"If-None-Match": {`"other"`}}, http.StatusOK},
+		{"GET", http.Header{"If-Modified-Since": {lastModified}}, http.StatusNotModified},
+		{"GET", http.Header{"If-Modified-Since": {"Mon, 01 Jan 2001 00:00:00 GMT"}}, http.StatusOK},
+		// If-None-Match takes precedence over If-Modified-Since
+		{"GET", http.Header{"If-None-Match": {`"other"`}, "If-Modified-Since": {lastModified}}, http.StatusOK},
+	}
+	for _, tt := range tests {
+		resp := get(tt.method, tt.header)
+		if resp.StatusCode != tt.status {
+			t.Fatalf("%s %v: statusCode expected %d, got %d", tt.method, tt.header, tt.status, resp.StatusCode)
+		}
+		if resp.StatusCode == http.StatusNotModified && resp.Header.Get("ETag") != etag {
+			t.Fatalf("%s %v: 304 without ETag %v", tt.method, tt.header, resp.Header)
+		}
+	}
+
+	// the same value with other representation headers and a new value have a new ETag
+	etags := map[string]bool{etag: true}
+	for _, header := range []http.Header{
+		{"Content-Type": {"text/plain"}},
+		{"Content-Type": {"text/plain"}, "X-Meta-Owner": {"alice"}},
+		{"Content-Type": {"text/plain"}, "X-Meta-Owner": {"alice"}, "X-Cache-Control": {"no-store"}},
+	} {
+		set("hello", header)
+		resp = get("GET", http.Header{"If-None-Match": {etag}})
+		if resp.StatusCode != http.StatusOK || etags[resp.Header.Get("ETag")] {
+			t.Fatalf("%v: expected 200 with a new ETag, got %d %v", header, resp.StatusCode, resp.Header)
+		}
+		etag = resp.Header.Get("ETag")
+		etags[etag] = true
+	}
+	set("world", nil)
+	resp = get("GET", http.Header{"If-None-Match": {etag}})
+	if resp.StatusCode != http.StatusOK || etags[resp.Header.Get("ETag")] {
+		t.Fatalf("expected 200 with a new ETag, got %d %v", resp.StatusCode, resp.Header)
+	}
+}
@@ -0,0 +1,84 @@
+package handler
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gerlacdt/db-key-value-store/pkg/db"
+)
+
+// defaultLeaseTTL is the time to live of leases without ttl parameter.
+const defaultLeaseTTL = 30 * time.Second
+
+// leaseHandler serves the leases under /leases/<name>.
+//
+// POST acquires the lease for ?holder=<id>, PUT renews and DELETE releases the
+// lease of ?token=<fencing token>. POST and PUT take ?ttl=<duration>. GET
+// returns the current lease.
+func (h *handler) leaseHandler(w http.ResponseWriter, r *http.Request) error {
+	name := strings.TrimPrefix(r.URL.Path, "/leases/")
+	if name == "" || strings.Contains(name, "/") {
+		return errorf(fmt.Errorf("lease name %q", name), http.StatusBadRequest, "requested lease name not valid")
+	}
+	query := r.URL.Query()
+
+	ttl := defaultLeaseTTL
+	if s := query.Get("ttl"); s != "" {
+		var err error
+		ttl, err = time.ParseDuration(s)
+		if err != nil || ttl <= 0 {
+			return errorf(fmt.Errorf("ttl %q", s), http.StatusBadRequest, "ttl must be a positive duration")
+		}
+	}
+	token := func() (uint64, error) {
+		token, err := strconv.ParseUint(query.Get("token"), 10, 64)
+		if err != nil {
+			return 0, errorf(err, http.StatusBadRequest, "token must be a fencing token")
+		}
+		return token, nil
+	}
+
+	var (
+		lease *db.Lease
+		err   error
+	)
+	switch r.Method {
+	case http.MethodGet:
+		lease, err = h.db.Lease(name)
+		if err == nil && lease == nil {
+			return errorf(fmt.Errorf(""), http.StatusNotFound, "lease is not held")
+		}
+	case http.MethodPost:
+		holder := query.Get("holder")
+		if holder == "" {
+			return errorf(fmt.Errorf(""), http.StatusBadRequest, "holder is required")
+		}
+		lease, err = h.db.Acquire(name, holder, ttl)
+	case http.MethodPut:
+		t, terr := token()
+		if terr != nil {
+			return terr
+		}
+		lease, err = h.db.Renew(name, t, ttl)
+	case http.MethodDelete:
+		t, terr := token()
+		if terr != nil {
+			return terr
+		}
+		err = h.db.Release(name, t)
+	default:
+		return errorf(fmt.Errorf(""), http.StatusMethodNotAllowed, r.Method+": method not allowed")
+	}
+
+	switch {
+	case err != nil:
+		return dbError(w, err)
+	case lease == nil:
+		w.WriteHeader(http.StatusOK)
+		return nil
+	}
+	return writeJSON(w, http.StatusOK, lease)
+}
@@ -0,0 +1,55 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gerlacdt/db-key-value-store/pkg/db"
+)
+
+func TestHttpLease(t *testing.T) {
+	srv := httptest.NewServer(setup(t))
+	defer srv.Close()
+
+	resp := doRequest(t, "POST", fmt.Sprintf("%s/leases/jobs?holder=worker-1&ttl=1m", srv.URL), nil)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("statusCode expected %d, got %d", http.StatusOK, resp.StatusCode)
+	}
+	var lease db.Lease
+	err := json.NewDecoder(resp.Body).Decode(&lease)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatalf("error decoding lease %v", err)
+	}
+	if lease.Holder != "worker-1" || lease.Token == 0 {
+		t.Fatalf("unexpected lease %+v", lease)
+	}
+
+	resp = doRequest(t, "POST", fmt.Sprintf("%s/leases/jobs?holder=worker-2", srv.URL), nil)
+	if resp.StatusCode != http.StatusConflict {
+		t.Fatalf("statusCode expected %d, got %d", http.StatusConflict, resp.StatusCode)
+	}
+	resp = doRequest(t, "PUT", fmt.Sprintf("%s/leases/jobs?token=%d&ttl=2m", srv.URL, lease.Token), nil)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("statusCode expected %d, got %d", http.StatusOK, resp.StatusCode)
+	}
+	resp = doRequest(t, "PUT", fmt.Sprintf("%s/leases/jobs?ttl=-1s&token=%d", srv.URL, lease.Token), nil)
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("statusCode expected %d, got %d", http.StatusBadRequest, resp.StatusCode)
+	}
+	resp = doRequest(t, "DELETE", fmt.Sprintf("%s/leases/jobs?token=%d", srv.URL, lease.Token+1), nil)
+	if resp.StatusCode != http.StatusConflict {
+		t.Fatalf("statusCode expected %d, got %d", http.StatusConflict, resp.StatusCode)
+	}
+	resp = doRequest(t, "DELETE", fmt.Sprintf("%s/leases/jobs?token=%d", srv.URL, lease.Token), nil)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("statusCode expected %d, got %d", http.StatusOK, resp.StatusCode)
+	}
+	resp = doRequest(t, "GET", fmt.Sprintf("%s/leases/jobs", srv.URL), nil)
+	if resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("statusCode expected %d, got %d", http.StatusNotFound, resp.StatusCode)
+	}
+}
@@ -0,0 +1,229 @@
+package handler
+
+import (
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+// historyOp is a completed single-key operation with its invocation and
+// response times. Deletes are writes of the empty value, which is the value of
+// a missing key.
+type historyOp struct {
+	client int
+	key    string
+	write  bool
+	value  string // written or read value
+	call   int64
+	ret    int64
+}
+
+func (op historyOp) String() string {
+	kind := "read"
+	if op.write {
+		kind = "write"
+	}
+	return fmt.Sprintf("client %d %s(%q) = %q [%d, %d]", op.client, kind, op.key, op.value, op.call, op.ret)
+}
+
+// linearizable checks if the history of a single register has a linearization,
+// an order of the operations which respects their real time order and where
+// every read returns the latest write. It searches the orders depth first and
+// remembers the visited combinations of linearized operations and register
+// values (Wing & Gong with the cache of Lowe).
+func linearizable(history []historyOp) bool {
+	ops := append([]historyOp(nil), history...)
+	sort.Slice(ops, func(i, j int) bool { return ops[i].call < ops[j].call })
+	done := make([]bool, len(ops))
+	visited := make(map[string]bool)
+
+	var search func(n int, state string) bool
+	search = func(n int, state string) bool {
+		if n == len(ops) {
+			return true
+		}
+		var b strings.Builder
+		for _, d := range done {
+			if d {
+				b.WriteByte('1')
+			} else {
+				b.WriteByte('0')
+			}
+		}
+		cacheKey := b.String() + state
+		if visited[cacheKey] {
+			return false
+		}
+		visited[cacheKey] = true
+
+		// an operation can be linearized next if it was invoked before every
+		// other pending operation returned
+		minRet := int64(-1)
+		for i, op := range ops {
+			if !done[i] && (minRet < 0 || op.ret < minRet) {
+				minRet = op.ret
+			}
+		}
+		for i, op := range ops {
+			if done[i] || op.call > minRet {
+				continue
+			}
+			next := state
+			if op.write {
+				next = op.value
+			} else if op.value != state {
+				continue
+			}
+			done[i] = true
+			if search(n+1, next) {
+				return true
+			}
+			done[i] = false
+		}
+		return false
+	}
+	return search(0, "")
+}
+
+func TestLinearizableChecker(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name    string
+		history []historyOp
+		ok      bool
+	}{
+		{"sequential", []historyOp{
+			{write: true, value: "a", call: 0, ret: 1},
+			{value: "a", call: 2, ret: 3},
+		}, true},
+		{"concurrent read sees old or new value", []historyOp{
+			{write: true, value: "a", call: 0, ret: 1},
+			{write: true, value: "b", call: 2, ret: 5},
+			{value: "a", call: 3, ret: 4},
+			{value: "b", call: 3, ret: 6},
+		}, true},
+		{"stale read", []historyOp{
+			{write: true, value: "a", call: 0, ret: 1},
+			{write: true, value: "b", call: 2, ret: 3},
+			{value: "a", call: 4, ret: 5},
+		}, false},
+		{"new value read before old value", []historyOp{
+			{write: true, value: "a", call: 0, ret: 10},
+			{value: "a", call: 1, ret: 2},
+			{value: "", call: 3, ret: 4},
+		}, false},
+	}
+	for _, tt := range tests {
+		if linearizable(tt.history) != tt.ok {
+			t.Errorf("%s: linearizable expected %v", tt.name, tt.ok)
+		}
+	}
+}
+
+// TestHttpLinearizable records the history of concurrent clients reading,
+// writing and deleting a few keys and checks it for every key.
+func TestHttpLinearizable(t *testing.T) {
+	srv := httptest.NewServer(setup(t))
+	defer srv.Close()
+
+	const (
+		clients = 8
+		ops     = 40
+		keys    = 3
+	)
+	var (
+		clock   int64
+		lock    sync.Mutex
+		history = make(map[string][]historyOp)
+		wg      sync.WaitGroup
+	)
+	for c := 0; c < clients; c++ {
+		wg.Add(1)
+		go func(c int) {
+			defer wg.Done()
+			r := rand.New(rand.NewSource(int64(c)))
+			for i := 0; i < ops; i++ {
+				op := historyOp{client: c, key: fmt.Sprintf("key-%d", r.Intn(keys))}
+				url := fmt.Sprintf("%s/db/%s", srv.URL, op.key)
+				var err error
+				op.call = atomic.AddInt64(&clock, 1)
+				switch p := r.Intn(10); {
+				case p < 4:
+					op.write = true
+					op.value = fmt.Sprintf("%d-%d", c, i)
+					err = linearizableRequest("POST", url, op.value, http.StatusCreated, nil)
+				case p < 5:
+					// deleting a missing key is a read of the empty value
+					var deleted string
+					err = linearizableRequest("DELETE", url, "", http.StatusOK, &deleted)
+					op.write = err == nil && deleted != notFound
+				default:
+					err = linearizableRequest("GET", url, "", http.StatusOK, &op.value)
+				}
+				op.ret = atomic.AddInt64(&clock, 1)
+				if err != nil {
+					t.Errorf("%v: %v", op, err)
+					return
+				}
+				lock.Lock()
+				history[op.key] = append(history[op.key], op)
+				lock.Unlock()
+			}
+		}(c)
+	}
+	wg.Wait()
+
+	for key, ops := range history {
+		if !linearizable(ops) {
+			lines := make([]string, len(ops))
+			for i, op := range ops {
+				lines[i] = op.String()
+			}
+			t.Fatalf("history of %s is not linearizable:\n%s", key, strings.Join(lines, "\n"))
+		}
+	}
+}
+
+// notFound is the result of a delete of a missing key.
+const notFound = "\x00not found"
+
+// linearizableRequest sends the request and stores the body in value. Missing
+// keys read and delete as the empty value. It is called by the clients, so it
+// returns errors instead of failing the test.
+func linearizableRequest(method, url, body string, status int, value *string) error {
+	req, err := http.NewRequest(method, url, strings.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode == http.StatusNotFound && method == "GET" {
+		return nil
+	}
+	if resp.StatusCode == http.StatusNotFound && method == "DELETE" {
+		*value = notFound
+		return nil
+	}
+	if resp.StatusCode != status {
+		return fmt.Errorf("statusCode expected %d, got %d", status, resp.StatusCode)
+	}
+	if value != nil {
+		*value = string(data)
+	}
+	return nil
+}
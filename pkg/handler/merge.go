@@ -0,0 +1,56 @@
+package handler
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gerlacdt/db-key-value-store/pkg/db"
+)
+
+// mergeHandler applies a merge operator to the key atomically and returns the
+// resulting value.
+//
+// POST /db/<key>?op=incr&by=5 adds to a decimal counter (by defaults to 1),
+// op=append appends the body and op=merge applies the body as JSON merge
+// patch.
+func (h *handler) mergeHandler(w http.ResponseWriter, r *http.Request, key, op string) error {
+	var mergeOp db.MergeOp
+	switch op {
+	case "incr":
+		by := int64(1)
+		if s := r.URL.Query().Get("by"); s != "" {
+			var err error
+			by, err = strconv.ParseInt(s, 10, 64)
+			if err != nil {
+				return errorf(err, http.StatusBadRequest, "by must be an integer")
+			}
+		}
+		mergeOp = db.Incr(by)
+	case "append", "merge":
+		body, err := readBody(h.db, r)
+		if err != nil {
+			return err
+		}
+		if op == "append" {
+			mergeOp = db.Append(body)
+		} else {
+			mergeOp = db.MergePatch(body)
+		}
+	default:
+		return errorf(fmt.Errorf("unknown op %q", op), http.StatusBadRequest, "op must be incr, append or merge")
+	}
+
+	entity, err := h.db.Merge(key, mergeOp)
+	if err != nil {
+		return dbError(w, err)
+	}
+	if op == "merge" {
+		w.Header().Set("Content-Type", "application/json")
+	} else {
+		w.Header().Set("Content-Type", "application/octet-stream")
+	}
+	w.Header().Set("X-Version", strconv.FormatUint(entity.Seq, 10))
+	_, err = w.Write(entity.Value)
+	return err
+}
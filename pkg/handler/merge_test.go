@@ -0,0 +1,54 @@
+package handler
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestHttpMerge(t *testing.T) {
+	srv := httptest.NewServer(setup(t))
+	defer srv.Close()
+
+	tests := []struct {
+		url    string
+		body   string
+		status int
+		value  string
+	}{
+		{"/db/counter?op=incr&by=5", "", http.StatusOK, "5"},
+		{"/db/counter?op=incr", "", http.StatusOK, "6"},
+		{"/db/counter?op=incr&by=-10", "", http.StatusOK, "-4"},
+		{"/db/counter?op=incr&by=x", "", http.StatusBadRequest, ""},
+		{"/db/counter?op=append", "0", http.StatusOK, "-40"},
+		{"/db/text?op=append", "abc", http.StatusOK, "abc"},
+		{"/db/text?op=merge", `{"a": 1}`, http.StatusConflict, ""},
+		{"/db/doc?op=merge", `{"a": 1, "b": 2}`, http.StatusOK, `{"a":1,"b":2}`},
+		{"/db/doc?op=merge", `{"b": null}`, http.StatusOK, `{"a":1}`},
+		{"/db/doc?op=incr", "", http.StatusConflict, ""},
+		{"/db/doc?op=unknown", "", http.StatusBadRequest, ""},
+	}
+	for _, tt := range tests {
+		resp := doRequest(t, "POST", srv.URL+tt.url, []byte(tt.body))
+		body, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatalf("error reading body %v", err)
+		}
+		if resp.StatusCode != tt.status {
+			t.Fatalf("%s: statusCode expected %d, got %d", tt.url, tt.status, resp.StatusCode)
+		}
+		if tt.status == http.StatusOK && string(body) != tt.value {
+			t.Fatalf("%s: value expected %s, got %s", tt.url, tt.value, body)
+		}
+	}
+
+	resp := doRequest(t, "GET", fmt.Sprintf("%s/db/counter", srv.URL), nil)
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(body) != "-40" {
+		t.Fatalf("value expected %s, got %s", "-40", body)
+	}
+}
@@ -0,0 +1,123 @@
+package handler
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gerlacdt/db-key-value-store/pkg/db"
+	"github.com/gerlacdt/db-key-value-store/pkg/metrics"
+)
+
+// statusWriter records the status code and the size of a response.
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func (w *statusWriter) WriteHeader(code int) {
+	if w.status == 0 {
+		w.status = code
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *statusWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(b)
+	w.bytes += int64(n)
+	return n, err
+}
+
+// Flush keeps streaming responses like /changes working.
+func (w *statusWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// instrument records the count and the latency of requests by method and
+// status.
+func (h *handler) instrument(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		sw, ok := w.(*statusWriter)
+		if !ok {
+			sw = &statusWriter{ResponseWriter: w}
+		}
+		next.ServeHTTP(sw, r)
+		status := sw.status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		h.requests.With(methodLabel(r.Method), strconv.Itoa(status)).Observe(time.Since(start).Seconds())
+	})
+}
+
+// methodLabel returns the method as label, unknown methods are labeled other,
+// so clients can not create an unbounded number of series.
+func methodLabel(method string) string {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodDelete, http.MethodPut:
+		return method
+	}
+	return "other"
+}
+
+// metricsHandler serves the metrics in the Prometheus text format. The
+// database metrics are labeled with the namespace, the default keyspace has an
+// empty namespace.
+func (h *handler) metricsHandler(w http.ResponseWriter, r *http.Request) error {
+	dbs := map[string]db.Metrics{"": h.db.Metrics()}
+	names := []string{""}
+	if h.namespaces != nil {
+		for _, name := range h.namespaces.List() {
+			d, err := h.namespaces.Namespace(name)
+			if err != nil {
+				// dropped in the meantime
+				continue
+			}
+			dbs[name] = d.Metrics()
+			names = append(names, name)
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	mw := metrics.NewWriter(w)
+	mw.Header("kv_http_requests_total", "counter", "HTTP requests by method and status.")
+	mw.CountVec("kv_http_requests_total", h.requests)
+	mw.Header("kv_http_request_duration_seconds", "histogram", "HTTP request latency by method and status.")
+	mw.HistogramVec("kv_http_request_duration_seconds", h.requests)
+	if h.tokens != nil {
+		mw.Header("kv_http_auth_denied_total", "counter", "Requests denied by the bearer token authorization by op and reason.")
+		mw.CountVec("kv_http_auth_denied_total", h.tokens.denied)
+	}
+
+	gauges := []struct {
+		name, typ, help string
+		value           func(m db.Metrics) float64
+	}{
+		{"kv_db_keys", "gauge", "Live keys.", func(m db.Metrics) float64 { return float64(m.Keys) }},
+		{"kv_db_tombstones", "gauge", "Deleted keys with a tombstone in the index.", func(m db.Metrics) float64 { return float64(m.Tombstones) }},
+		{"kv_db_live_bytes", "gauge", "Bytes of the latest records of live keys.", func(m db.Metrics) float64 { return float64(m.LiveBytes) }},
+		{"kv_db_dead_bytes", "gauge", "Bytes of overwritten records and tombstones.", func(m db.Metrics) float64 { return float64(m.DeadBytes) }},
+		{"kv_db_log_size_bytes", "gauge", "Size of the log.", func(m db.Metrics) float64 { return float64(m.LogSize) }},
+		{"kv_db_seq", "gauge", "Sequence number of the last committed write.", func(m db.Metrics) float64 { return float64(m.Seq) }},
+		{"kv_db_recover_duration_seconds", "gauge", "Duration of the last recovery.", func(m db.Metrics) float64 { return m.RecoverDuration.Seconds() }},
+		{"kv_db_compactions_total", "counter", "Completed compactions.", func(m db.Metrics) float64 { return float64(m.Compactions) }},
+	}
+	for _, g := range gauges {
+		mw.Header(g.name, g.typ, g.help)
+		for _, name := range names {
+			mw.Sample(g.name, metrics.Labels{"namespace", name}, g.value(dbs[name]))
+		}
+	}
+	mw.Header("kv_db_fsync_duration_seconds", "histogram", "Latency of fsyncs of the log.")
+	for _, name := range names {
+		mw.Histogram("kv_db_fsync_duration_seconds", metrics.Labels{"namespace", name}, dbs[name].SyncLatency)
+	}
+	return mw.Err()
+}
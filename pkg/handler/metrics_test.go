@@ -0,0 +1,46 @@
+package handler
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestHttpMetrics(t *testing.T) {
+	srv := httptest.NewServer(setup(t))
+	defer srv.Close()
+
+	doRequest(t, "POST", fmt.Sprintf("%s/db/foo", srv.URL), []byte("bar")).Body.Close()
+	doRequest(t, "GET", fmt.Sprintf("%s/db/missing", srv.URL), nil).Body.Close()
+	doRequest(t, "FOO", fmt.Sprintf("%s/db/foo", srv.URL), nil).Body.Close()
+	doRequest(t, "BAR", fmt.Sprintf("%s/db/foo", srv.URL), nil).Body.Close()
+
+	resp, err := http.Get(fmt.Sprintf("%s/metrics", srv.URL))
+	if err != nil {
+		t.Fatalf("error http GET %v", err)
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatalf("error reading body %v", err)
+	}
+	for _, line := range []string{
+		`kv_http_requests_total{method="POST",status="201"} 1`,
+		`kv_http_requests_total{method="GET",status="404"} 1`,
+		`kv_http_request_duration_seconds_count{method="POST",status="201"} 1`,
+		`kv_http_requests_total{method="other",status="405"} 2`,
+		`kv_db_keys{namespace=""} 1`,
+		`kv_db_compactions_total{namespace=""} 0`,
+		`kv_db_fsync_duration_seconds_count{namespace=""} 0`,
+	} {
+		if !strings.Contains(string(body), line+"\n") {
+			t.Fatalf("expected %s in\n%s", line, body)
+		}
+	}
+	if strings.Contains(string(body), `method="FOO"`) {
+		t.Fatalf("unknown method expected to be labeled other in\n%s", body)
+	}
+}
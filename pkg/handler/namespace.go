@@ -0,0 +1,126 @@
+package handler
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gerlacdt/db-key-value-store/pkg/db"
+)
+
+// namespaceHandler serves /ns/<name>/db/<key> like /db/<key> on the database of
+// the namespace.
+func (h *handler) namespaceHandler(w http.ResponseWriter, r *http.Request) error {
+	if h.namespaces == nil {
+		return errorf(fmt.Errorf(""), http.StatusNotFound, "namespaces are not enabled")
+	}
+	path := strings.TrimPrefix(r.URL.Path, "/ns/")
+	i := strings.Index(path, "/db/")
+	if i < 0 {
+		return errorf(fmt.Errorf("path %s", r.URL.Path), http.StatusNotFound, "expected /ns/<name>/db/<key>")
+	}
+	d, err := h.namespaces.Namespace(path[:i])
+	if err == db.ErrNamespaceNotFound {
+		return errorf(err, http.StatusNotFound, "namespace does not exist")
+	}
+	if err != nil {
+		return err
+	}
+
+	nsr := r.Clone(r.Context())
+	nsr.URL.Path = path[i:]
+	sub := &handler{db: d}
+	return sub.handleDb(w, nsr)
+}
+
+// namespaceStats is the admin view of a namespace.
+type namespaceStats struct {
+	Name string `json:"name"`
+	db.Stats
+	Quotas []db.QuotaUsage `json:"quotas,omitempty"`
+}
+
+// namespacesHandler lists all namespaces with their stats.
+func (h *handler) namespacesHandler(w http.ResponseWriter, r *http.Request) error {
+	if h.namespaces == nil {
+		return errorf(fmt.Errorf(""), http.StatusNotFound, "namespaces are not enabled")
+	}
+	if r.Method != http.MethodGet {
+		return errorf(fmt.Errorf(""), http.StatusMethodNotAllowed, r.Method+": method not allowed")
+	}
+	list := []namespaceStats{}
+	for _, name := range h.namespaces.List() {
+		d, err := h.namespaces.Namespace(name)
+		if err == db.ErrNamespaceNotFound {
+			// dropped in the meantime
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		list = append(list, namespaceStats{name, d.Stats(), d.QuotaUsage()})
+	}
+	return writeJSON(w, http.StatusOK, list)
+}
+
+// namespaceAdminHandler creates (PUT), drops (DELETE) or shows (GET) a
+// namespace. /admin/ns/<name>/seal and /admin/ns/<name>/compaction serve
+// /admin/seal and /admin/compaction for the log of the namespace.
+func (h *handler) namespaceAdminHandler(w http.ResponseWriter, r *http.Request) error {
+	if h.namespaces == nil {
+		return errorf(fmt.Errorf(""), http.StatusNotFound, "namespaces are not enabled")
+	}
+	name := strings.TrimPrefix(r.URL.Path, "/admin/ns/")
+	if i := strings.Index(name, "/"); i >= 0 {
+		return h.namespaceSegmentsHandler(w, r, name[:i], name[i+1:])
+	}
+	switch r.Method {
+	case http.MethodGet:
+		d, err := h.namespaces.Namespace(name)
+		if err == db.ErrNamespaceNotFound {
+			return errorf(err, http.StatusNotFound, "namespace does not exist")
+		}
+		if err != nil {
+			return err
+		}
+		return writeJSON(w, http.StatusOK, namespaceStats{name, d.Stats(), d.QuotaUsage()})
+	case http.MethodPut:
+		d, err := h.namespaces.Create(name)
+		if err == db.ErrNamespaceExists {
+			return errorf(err, http.StatusConflict, "namespace already exists")
+		}
+		if err != nil {
+			return errorf(err, http.StatusBadRequest, "could not create namespace")
+		}
+		return writeJSON(w, http.StatusCreated, namespaceStats{name, d.Stats(), d.QuotaUsage()})
+	case http.MethodDelete:
+		err := h.namespaces.Drop(name)
+		if err == db.ErrNamespaceNotFound {
+			return errorf(err, http.StatusNotFound, "namespace does not exist")
+		}
+		if err != nil {
+			return err
+		}
+		w.WriteHeader(http.StatusOK)
+		return nil
+	default:
+		return errorf(fmt.Errorf(""), http.StatusMethodNotAllowed, r.Method+": method not allowed")
+	}
+}
+
+// namespaceSegmentsHandler seals or compacts the log of a namespace.
+func (h *handler) namespaceSegmentsHandler(w http.ResponseWriter, r *http.Request, name, action string) error {
+	d, err := h.namespaces.Namespace(name)
+	if err != nil {
+		return dbError(w, err)
+	}
+	sub := &handler{db: d}
+	switch action {
+	case "seal":
+		return sub.sealHandler(w, r)
+	case "compaction":
+		return sub.compactionHandler(w, r)
+	default:
+		return errorf(fmt.Errorf("path %s", r.URL.Path), http.StatusNotFound, "expected /admin/ns/<name>/seal or /admin/ns/<name>/compaction")
+	}
+}
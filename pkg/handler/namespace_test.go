@@ -0,0 +1,136 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/gerlacdt/db-key-value-store/pkg/db"
+	"github.com/mattetti/filebuffer"
+)
+
+func setupNamespaces(t *testing.T) *httptest.Server {
+	t.Parallel()
+	dir, err := ioutil.TempDir("", "namespaces")
+	if err != nil {
+		t.Fatalf("error creating temp dir %v", err)
+	}
+	namespaces, err := db.OpenNamespaces(dir)
+	if err != nil {
+		t.Fatalf("error opening namespaces %v", err)
+	}
+	t.Cleanup(func() {
+		namespaces.Close()
+		os.RemoveAll(dir)
+	})
+	h, err := New(db.New(filebuffer.New(nil)), WithNamespaces(namespaces))
+	if err != nil {
+		t.Fatalf("could not create handler: %v", err)
+	}
+	srv := httptest.NewServer(h)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func doRequest(t *testing.T, method, url string, body []byte) *http.Response {
+	req, err := http.NewRequest(method, url, bytes.NewReader(body))
+	if err != nil {
+		t.Fatalf("error creating %s request %v", method, err)
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("error http %s %v", method, err)
+	}
+	return resp
+}
+
+func TestHttpNamespaces(t *testing.T) {
+	srv := setupNamespaces(t)
+
+	resp := doRequest(t, "PUT", fmt.Sprintf("%s/admin/ns/team-a", srv.URL), nil)
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("statusCode expected %d, got %d", http.StatusCreated, resp.StatusCode)
+	}
+	resp = doRequest(t, "PUT", fmt.Sprintf("%s/admin/ns/team-a", srv.URL), nil)
+	if resp.StatusCode != http.StatusConflict {
+		t.Fatalf("statusCode expected %d, got %d", http.StatusConflict, resp.StatusCode)
+	}
+
+	// act
+	value := []byte("bar")
+	resp = doRequest(t, "POST", fmt.Sprintf("%s/ns/team-a/db/foo", srv.URL), value)
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("statusCode expected %d, got %d", http.StatusCreated, resp.StatusCode)
+	}
+
+	// assert
+	resp = doRequest(t, "GET", fmt.Sprintf("%s/ns/team-a/db/foo", srv.URL), nil)
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil || string(body) != string(value) {
+		t.Fatalf("body expected %s, got %s", value, body)
+	}
+	resp = doRequest(t, "GET", fmt.Sprintf("%s/db/foo", srv.URL), nil)
+	if resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("statusCode expected %d, got %d", http.StatusNotFound, resp.StatusCode)
+	}
+
+	resp = doRequest(t, "GET", fmt.Sprintf("%s/admin/ns", srv.URL), nil)
+	var list []struct {
+		Name string `json:"name"`
+		Keys int    `json:"keys"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&list)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatalf("error decoding namespaces %v", err)
+	}
+	if len(list) != 1 || list[0].Name != "team-a" || list[0].Keys != 1 {
+		t.Fatalf("unexpected namespaces %+v", list)
+	}
+
+	// the log of the namespace is sealed and compacted on its own
+	for _, path := range []string{"seal", "compaction"} {
+		resp = doRequest(t, "POST", fmt.Sprintf("%s/admin/ns/team-a/%s", srv.URL, path), nil)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("%s: statusCode expected %d, got %d", path, http.StatusOK, resp.StatusCode)
+		}
+	}
+	resp = doRequest(t, "GET", fmt.Sprintf("%s/ns/team-a/db/foo", srv.URL), nil)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("statusCode expected %d, got %d", http.StatusOK, resp.StatusCode)
+	}
+	resp = doRequest(t, "POST", fmt.Sprintf("%s/admin/ns/missing/compaction", srv.URL), nil)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("statusCode expected %d, got %d", http.StatusNotFound, resp.StatusCode)
+	}
+
+	resp = doRequest(t, "DELETE", fmt.Sprintf("%s/admin/ns/team-a", srv.URL), nil)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("statusCode expected %d, got %d", http.StatusOK, resp.StatusCode)
+	}
+	resp = doRequest(t, "GET", fmt.Sprintf("%s/ns/team-a/db/foo", srv.URL), nil)
+	if resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("statusCode expected %d, got %d", http.StatusNotFound, resp.StatusCode)
+	}
+}
+
+func TestHttpNamespacesDisabled(t *testing.T) {
+	r := setup(t)
+	srv := httptest.NewServer(r)
+	defer srv.Close()
+
+	resp := doRequest(t, "GET", fmt.Sprintf("%s/ns/team-a/db/foo", srv.URL), nil)
+	if resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("statusCode expected %d, got %d", http.StatusNotFound, resp.StatusCode)
+	}
+}
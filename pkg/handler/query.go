@@ -0,0 +1,66 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gerlacdt/db-key-value-store/pkg/db"
+)
+
+// queryHandler looks up keys by a secondary index, GET /indexes/<name>?eq=<value>
+// or ?from=<value>&to=<value>. With include=entities the JSON records are
+// returned instead of the keys.
+func (h *handler) queryHandler(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != http.MethodGet {
+		return errorf(fmt.Errorf(""), http.StatusMethodNotAllowed, r.Method+": method not allowed")
+	}
+	name := strings.TrimPrefix(r.URL.Path, "/indexes/")
+	query := r.URL.Query()
+
+	parse := func(param string) *db.IndexValue {
+		if _, ok := query[param]; !ok {
+			return nil
+		}
+		v := db.ParseIndexValue(query.Get(param))
+		return &v
+	}
+	q := db.IndexQuery{Eq: parse("eq"), From: parse("from"), To: parse("to")}
+	if q.Eq != nil && (q.From != nil || q.To != nil) {
+		return errorf(fmt.Errorf(""), http.StatusBadRequest, "eq can not be combined with from or to")
+	}
+
+	keys, err := h.db.Query(name, q)
+	if err == db.ErrUnknownIndex {
+		return errorf(err, http.StatusNotFound, "index does not exist")
+	}
+	if err != nil {
+		return errorf(err, http.StatusInternalServerError, "error querying the index")
+	}
+
+	var result interface{} = keys
+	if query.Get("include") == "entities" {
+		records := []db.Record{}
+		for _, key := range keys {
+			entity, err := h.db.Get(key)
+			if err != nil {
+				return errorf(err, http.StatusInternalServerError, "error GET the requested key")
+			}
+			if entity != nil {
+				records = append(records, db.NewRecord(entity))
+			}
+		}
+		result = records
+	}
+	body, err := json.Marshal(result)
+	if err != nil {
+		return fmt.Errorf("Could not encode query result: %v", err)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_, err = w.Write(body)
+	if err != nil {
+		return fmt.Errorf("error writing to http response: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,81 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/gerlacdt/db-key-value-store/pkg/db"
+	"github.com/mattetti/filebuffer"
+)
+
+func TestHttpQuery(t *testing.T) {
+	t.Parallel()
+	d := db.New(filebuffer.New(nil))
+	err := d.CreateIndex(db.IndexSpec{Name: "email", Prefix: "user-", Path: "$.email"})
+	if err != nil {
+		t.Fatalf("error creating index %v", err)
+	}
+	h, err := New(d)
+	if err != nil {
+		t.Fatalf("could not create handler: %v", err)
+	}
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+
+	values := map[string]string{
+		"user-1": `{"email": "a@example.com"}`,
+		"user-2": `{"email": "b@example.com"}`,
+	}
+	for key, value := range values {
+		resp, err := http.Post(fmt.Sprintf("%s/db/%s", srv.URL, key), "application/octet-stream", bytes.NewReader([]byte(value)))
+		if err != nil {
+			t.Fatalf("error http SET %v", err)
+		}
+		if resp.StatusCode != http.StatusCreated {
+			t.Fatalf("statusCode expected %d, got %d", http.StatusCreated, resp.StatusCode)
+		}
+	}
+
+	// act
+	resp, err := http.Get(fmt.Sprintf("%s/indexes/email?eq=b@example.com", srv.URL))
+	if err != nil {
+		t.Fatalf("error http query %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("statusCode expected %d, got %d", http.StatusOK, resp.StatusCode)
+	}
+	var keys []string
+	if err := json.NewDecoder(resp.Body).Decode(&keys); err != nil {
+		t.Fatalf("error decoding query result %v", err)
+	}
+	if !reflect.DeepEqual([]string{"user-2"}, keys) {
+		t.Fatalf("expected %v, got %v", []string{"user-2"}, keys)
+	}
+
+	resp, err = http.Get(fmt.Sprintf("%s/indexes/email?from=a&include=entities", srv.URL))
+	if err != nil {
+		t.Fatalf("error http query %v", err)
+	}
+	defer resp.Body.Close()
+	var records []db.Record
+	if err := json.NewDecoder(resp.Body).Decode(&records); err != nil {
+		t.Fatalf("error decoding query result %v", err)
+	}
+	if len(records) != 2 || string(records[0].Value) != values["user-1"] {
+		t.Fatalf("unexpected query result %+v", records)
+	}
+
+	resp, err = http.Get(fmt.Sprintf("%s/indexes/unknown?eq=1", srv.URL))
+	if err != nil {
+		t.Fatalf("error http query %v", err)
+	}
+	if resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("statusCode expected %d, got %d", http.StatusNotFound, resp.StatusCode)
+	}
+}
@@ -0,0 +1,56 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gerlacdt/db-key-value-store/pkg/db"
+	"github.com/mattetti/filebuffer"
+)
+
+func TestHttpQuotas(t *testing.T) {
+	t.Parallel()
+	d := db.New(filebuffer.New(nil))
+	d.SetQuotas([]db.Quota{{Prefix: "user-", MaxValueSize: 3}, {Prefix: "event-", WritesPerSecond: 1}})
+	h, err := New(d)
+	if err != nil {
+		t.Fatalf("could not create handler: %v", err)
+	}
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+
+	resp := doRequest(t, "POST", fmt.Sprintf("%s/db/user-1", srv.URL), []byte("too large"))
+	if resp.StatusCode != http.StatusRequestEntityTooLarge {
+		t.Fatalf("statusCode expected %d, got %d", http.StatusRequestEntityTooLarge, resp.StatusCode)
+	}
+	resp = doRequest(t, "POST", fmt.Sprintf("%s/db/user-1", srv.URL), []byte("bar"))
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("statusCode expected %d, got %d", http.StatusCreated, resp.StatusCode)
+	}
+
+	resp = doRequest(t, "POST", fmt.Sprintf("%s/db/event-1", srv.URL), []byte("bar"))
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("statusCode expected %d, got %d", http.StatusCreated, resp.StatusCode)
+	}
+	resp = doRequest(t, "DELETE", fmt.Sprintf("%s/db/event-1", srv.URL), nil)
+	if resp.StatusCode != http.StatusTooManyRequests {
+		t.Fatalf("statusCode expected %d, got %d", http.StatusTooManyRequests, resp.StatusCode)
+	}
+	if resp.Header.Get("Retry-After") == "" {
+		t.Fatalf("expected Retry-After header")
+	}
+
+	resp = doRequest(t, "GET", fmt.Sprintf("%s/admin/quotas", srv.URL), nil)
+	var usage []db.QuotaUsage
+	err = json.NewDecoder(resp.Body).Decode(&usage)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatalf("error decoding quota usage %v", err)
+	}
+	if len(usage) != 2 || usage[0].Keys != 1 || usage[0].MaxValueSize != 3 {
+		t.Fatalf("unexpected quota usage %+v", usage)
+	}
+}
@@ -0,0 +1,71 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gerlacdt/db-key-value-store/pkg/db"
+)
+
+const (
+	defaultWatchTimeout = 30 * time.Second
+	maxWatchTimeout     = 5 * time.Minute
+)
+
+// watchHandler long-polls a key, or with prefix=true all keys under a prefix.
+// It responds with the changed entity as JSON record, a tombstone for deletes,
+// or with 204 and the current version in X-Version after the timeout.
+func (h *handler) watchHandler(w http.ResponseWriter, r *http.Request, key string) error {
+	query := r.URL.Query()
+	since := h.db.Seq()
+	if s := query.Get("since"); s != "" {
+		var err error
+		since, err = strconv.ParseUint(s, 10, 64)
+		if err != nil {
+			return errorf(err, http.StatusBadRequest, "since must be a version")
+		}
+	}
+	timeout := defaultWatchTimeout
+	if s := query.Get("timeout"); s != "" {
+		var err error
+		timeout, err = time.ParseDuration(s)
+		if err != nil || timeout <= 0 {
+			return errorf(fmt.Errorf("invalid timeout %q", s), http.StatusBadRequest, "timeout must be a positive duration")
+		}
+		if timeout > maxWatchTimeout {
+			timeout = maxWatchTimeout
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), timeout)
+	defer cancel()
+	entity, err := h.db.Watch(ctx, key, query.Get("prefix") == "true", since)
+	if err == context.DeadlineExceeded {
+		w.Header().Set("X-Version", strconv.FormatUint(h.db.Seq(), 10))
+		w.WriteHeader(http.StatusNoContent)
+		return nil
+	}
+	if err == context.Canceled {
+		// client gone or server shutting down
+		return nil
+	}
+	if err != nil {
+		return dbError(w, err)
+	}
+
+	body, err := json.Marshal(db.NewRecord(entity))
+	if err != nil {
+		return fmt.Errorf("Could not encode watch result: %v", err)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Version", strconv.FormatUint(entity.Seq, 10))
+	_, err = w.Write(body)
+	if err != nil {
+		return fmt.Errorf("error writing to http response: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,101 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gerlacdt/db-key-value-store/pkg/db"
+	"github.com/mattetti/filebuffer"
+)
+
+func TestHttpWatch(t *testing.T) {
+	r := setup(t)
+	srv := httptest.NewServer(r)
+	defer srv.Close()
+
+	value := []byte("bar")
+	resp, err := http.Post(fmt.Sprintf("%s/db/foo", srv.URL), "application/octet-stream", bytes.NewReader(value))
+	if err != nil {
+		t.Fatalf("error http SET %v", err)
+	}
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("statusCode expected %d, got %d", http.StatusCreated, resp.StatusCode)
+	}
+
+	// act
+	type result struct {
+		resp *http.Response
+		err  error
+	}
+	results := make(chan result, 1)
+	go func() {
+		resp, err := http.Get(fmt.Sprintf("%s/db/foo?watch=true&since=1&timeout=10s", srv.URL))
+		results <- result{resp, err}
+	}()
+	resp, err = http.Post(fmt.Sprintf("%s/db/foo", srv.URL), "application/octet-stream", bytes.NewReader([]byte("baz")))
+	if err != nil {
+		t.Fatalf("error http SET %v", err)
+	}
+
+	// assert
+	res := <-results
+	if res.err != nil {
+		t.Fatalf("error http watch %v", res.err)
+	}
+	defer res.resp.Body.Close()
+	if res.resp.StatusCode != http.StatusOK {
+		t.Fatalf("statusCode expected %d, got %d", http.StatusOK, res.resp.StatusCode)
+	}
+	var rec db.Record
+	if err := json.NewDecoder(res.resp.Body).Decode(&rec); err != nil {
+		t.Fatalf("error decoding watch result %v", err)
+	}
+	if rec.Key != "foo" || string(rec.Value) != "baz" || rec.Seq != 2 {
+		t.Fatalf("unexpected watch result %+v", rec)
+	}
+	if res.resp.Header.Get("X-Version") != "2" {
+		t.Fatalf("X-Version expected %s, got %s", "2", res.resp.Header.Get("X-Version"))
+	}
+}
+
+func TestHttpWatchTimeout(t *testing.T) {
+	r := setup(t)
+	srv := httptest.NewServer(r)
+	defer srv.Close()
+
+	resp, err := http.Get(fmt.Sprintf("%s/db/foo?watch=true&timeout=10ms", srv.URL))
+	if err != nil {
+		t.Fatalf("error http watch %v", err)
+	}
+	if resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("statusCode expected %d, got %d", http.StatusNoContent, resp.StatusCode)
+	}
+	if resp.Header.Get("X-Version") != "0" {
+		t.Fatalf("X-Version expected %s, got %s", "0", resp.Header.Get("X-Version"))
+	}
+}
+
+func TestHttpWatchClosed(t *testing.T) {
+	t.Parallel()
+	d := db.New(filebuffer.New(nil))
+	h, err := New(d)
+	if err != nil {
+		t.Fatalf("could not create handler: %v", err)
+	}
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+	d.Close()
+
+	resp, err := http.Get(fmt.Sprintf("%s/db/foo?watch=true&timeout=10ms", srv.URL))
+	if err != nil {
+		t.Fatalf("error http watch %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("statusCode expected %d, got %d", http.StatusServiceUnavailable, resp.StatusCode)
+	}
+}
@@ -0,0 +1,211 @@
+// Package metrics implements histograms and the Prometheus text exposition
+// format for the few metrics of the server.
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DefBuckets are latency buckets in seconds from 0.5ms to 10s.
+var DefBuckets = []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// Histogram counts observations in buckets. It is safe for concurrent use.
+type Histogram struct {
+	lock    sync.Mutex
+	buckets []float64 // upper bounds in increasing order
+	counts  []uint64  // not cumulative, the last one counts the observations above all bounds
+	sum     float64
+}
+
+// NewHistogram returns a histogram with the upper bounds of the buckets in
+// increasing order.
+func NewHistogram(buckets []float64) *Histogram {
+	return &Histogram{buckets: buckets, counts: make([]uint64, len(buckets)+1)}
+}
+
+// Observe adds an observation.
+func (h *Histogram) Observe(v float64) {
+	i := sort.SearchFloat64s(h.buckets, v)
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.counts[i]++
+	h.sum += v
+}
+
+// Count returns the number of observations.
+func (h *Histogram) Count() uint64 {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	var n uint64
+	for _, c := range h.counts {
+		n += c
+	}
+	return n
+}
+
+// HistogramVec is a set of histograms partitioned by label values.
+type HistogramVec struct {
+	lock    sync.Mutex
+	buckets []float64
+	labels  []string
+	m       map[string]*labeledHistogram
+}
+
+type labeledHistogram struct {
+	values []string
+	h      *Histogram
+}
+
+// NewHistogramVec returns a histogram vector with the given label names.
+func NewHistogramVec(buckets []float64, labels ...string) *HistogramVec {
+	return &HistogramVec{buckets: buckets, labels: labels, m: make(map[string]*labeledHistogram)}
+}
+
+// With returns the histogram of the label values, which are in the order of
+// the label names.
+func (v *HistogramVec) With(values ...string) *Histogram {
+	key := strings.Join(values, "\xff")
+	v.lock.Lock()
+	defer v.lock.Unlock()
+	lh, ok := v.m[key]
+	if !ok {
+		lh = &labeledHistogram{values: values, h: NewHistogram(v.buckets)}
+		v.m[key] = lh
+	}
+	return lh.h
+}
+
+// each calls fn for every histogram in the order of the label values.
+func (v *HistogramVec) each(fn func(labels Labels, h *Histogram)) {
+	v.lock.Lock()
+	keys := make([]string, 0, len(v.m))
+	for k := range v.m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	hs := make([]*labeledHistogram, len(keys))
+	for i, k := range keys {
+		hs[i] = v.m[k]
+	}
+	v.lock.Unlock()
+
+	for _, lh := range hs {
+		labels := make(Labels, 0, 2*len(v.labels))
+		for i, name := range v.labels {
+			labels = append(labels, name, lh.values[i])
+		}
+		fn(labels, lh.h)
+	}
+}
+
+// Labels are label names and values in alternating order.
+type Labels []string
+
+func (l Labels) with(name, value string) Labels {
+	return append(append(Labels(nil), l...), name, value)
+}
+
+func (l Labels) String() string {
+	if len(l) == 0 {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteByte('{')
+	for i := 0; i+1 < len(l); i += 2 {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(l[i])
+		b.WriteString(`="`)
+		b.WriteString(labelReplacer.Replace(l[i+1]))
+		b.WriteByte('"')
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+var labelReplacer = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// Writer writes metrics in the Prometheus text exposition format. The first
+// error is kept and returned by Err.
+type Writer struct {
+	w   io.Writer
+	err error
+}
+
+// NewWriter returns a Writer to w.
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{w: w}
+}
+
+// Err returns the first write error.
+func (w *Writer) Err() error {
+	return w.err
+}
+
+func (w *Writer) printf(format string, args ...interface{}) {
+	if w.err != nil {
+		return
+	}
+	_, w.err = fmt.Fprintf(w.w, format, args...)
+}
+
+// Header writes the HELP and TYPE lines of a metric, typ is counter, gauge or
+// histogram.
+func (w *Writer) Header(name, typ, help string) {
+	w.printf("# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+}
+
+// Sample writes a sample of a counter or gauge.
+func (w *Writer) Sample(name string, labels Labels, v float64) {
+	w.printf("%s%s %s\n", name, labels, formatFloat(v))
+}
+
+// Histogram writes the samples of a histogram.
+func (w *Writer) Histogram(name string, labels Labels, h *Histogram) {
+	h.lock.Lock()
+	counts := append([]uint64(nil), h.counts...)
+	sum := h.sum
+	h.lock.Unlock()
+
+	var cumulative uint64
+	for i, bound := range h.buckets {
+		cumulative += counts[i]
+		w.printf("%s_bucket%s %d\n", name, labels.with("le", formatFloat(bound)), cumulative)
+	}
+	cumulative += counts[len(h.buckets)]
+	w.printf("%s_bucket%s %d\n", name, labels.with("le", "+Inf"), cumulative)
+	w.printf("%s_sum%s %s\n", name, labels, formatFloat(sum))
+	w.printf("%s_count%s %d\n", name, labels, cumulative)
+}
+
+// HistogramVec writes the samples of all histograms of the vector.
+func (w *Writer) HistogramVec(name string, v *HistogramVec) {
+	v.each(func(labels Labels, h *Histogram) {
+		w.Histogram(name, labels, h)
+	})
+}
+
+// CountVec writes the observation counts of all histograms of the vector as
+// counter samples.
+func (w *Writer) CountVec(name string, v *HistogramVec) {
+	v.each(func(labels Labels, h *Histogram) {
+		w.printf("%s%s %d\n", name, labels, h.Count())
+	})
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
@@ -0,0 +1,53 @@
+package metrics
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestHistogram(t *testing.T) {
+	t.Parallel()
+	h := NewHistogram([]float64{0.1, 1})
+	for _, v := range []float64{0.05, 0.1, 0.5, 2} {
+		h.Observe(v)
+	}
+
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	w.Header("latency_seconds", "histogram", "Latency.")
+	w.Histogram("latency_seconds", Labels{"op", `say "hi"`}, h)
+	if w.Err() != nil {
+		t.Fatalf("error writing metrics %v", w.Err())
+	}
+	expected := `# HELP latency_seconds Latency.
+# TYPE latency_seconds histogram
+latency_seconds_bucket{op="say \"hi\"",le="0.1"} 2
+latency_seconds_bucket{op="say \"hi\"",le="1"} 3
+latency_seconds_bucket{op="say \"hi\"",le="+Inf"} 4
+latency_seconds_sum{op="say \"hi\""} 2.65
+latency_seconds_count{op="say \"hi\""} 4
+`
+	if buf.String() != expected {
+		t.Fatalf("expected\n%s\ngot\n%s", expected, buf.String())
+	}
+}
+
+func TestHistogramVec(t *testing.T) {
+	t.Parallel()
+	v := NewHistogramVec([]float64{1}, "method", "status")
+	v.With("POST", "201").Observe(0.5)
+	v.With("GET", "200").Observe(0.5)
+	v.With("GET", "200").Observe(2)
+
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	w.CountVec("requests_total", v)
+	w.Sample("keys", nil, 3)
+	expected := `requests_total{method="GET",status="200"} 2
+requests_total{method="POST",status="201"} 1
+keys 3
+`
+	if buf.String() != expected {
+		t.Fatalf("expected\n%s\ngot\n%s", expected, buf.String())
+	}
+}